@@ -2,20 +2,35 @@ package alerts
 
 import (
 	"tracepr/config"
-	"tracepr/github"
 	"tracepr/utils"
+	"tracepr/vcs"
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
 )
 
-func CreatePrometheusAlert(suggestion config.AlertSuggestion, cfg config.Config) error {
+// PrometheusRuleFile is the rule file for a suggestion as committed to the PR branch, relative to
+// the repository root
+func PrometheusRuleFile(suggestion config.AlertSuggestion, cfg config.Config) vcs.FileChange {
+	return vcs.FileChange{
+		Path:    path.Join(filepath.ToSlash(cfg.PrometheusConfigPath), utils.NormalizeFileName(suggestion.Name)+".yml"),
+		Content: utils.BuildPrometheusAlertRule(suggestion),
+	}
+}
+
+// CreatePrometheusAlert writes the suggestion's rule file, committing it to the PR branch through p
+// when running in CI
+func CreatePrometheusAlert(ctx context.Context, p vcs.Provider, suggestion config.AlertSuggestion, cfg config.Config) error {
 	// Build alert rule content in YAML format
 	alertRule := utils.BuildPrometheusAlertRule(suggestion)
 
 	// If running in CI mode, commit to repository
 	if cfg.RunningInCI {
-		return github.CommitAlertToRepository(suggestion, alertRule, cfg.PrometheusConfigPath, cfg)
+		ruleFile := PrometheusRuleFile(suggestion, cfg)
+		return p.CommitFiles(ctx, []vcs.FileChange{ruleFile}, fmt.Sprintf("Add %s alert rule for %s", suggestion.Type, suggestion.Name))
 	}
 
 	// Otherwise, create local file as before
@@ -34,6 +49,6 @@ func CreatePrometheusAlert(suggestion config.AlertSuggestion, cfg config.Config)
 		return fmt.Errorf("failed to write Prometheus alert rule file: %w", err)
 	}
 
-	fmt.Printf("Created Prometheus alert rule at: %s\n", rulePath)
+	log.Printf("Created Prometheus alert rule at: %s", rulePath)
 	return nil
 }
@@ -0,0 +1,323 @@
+package apply
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a suggestion resolved against the checked-out file it edits
+type Change struct {
+	Suggestion config.FileSuggestion
+	Start      int      // first line replaced in the current file
+	End        int      // last line replaced in the current file
+	Current    []string // the lines that will be replaced
+	Relocated  bool     // the original lines moved since the suggestion was made
+	Verified   bool     // the replaced lines match the ones the suggestion was made for
+	Conflict   string   // why the suggestion can't be applied, empty when it can
+}
+
+// Lines describes the replaced lines, e.g. "lines 40-42"
+func (c *Change) Lines() string {
+	return vcs.DescribeLines(c.Start, c.End)
+}
+
+// Overlaps reports whether both changes edit some of the same lines of the same file
+func (c *Change) Overlaps(other *Change) bool {
+	return c.Suggestion.FileName == other.Suggestion.FileName && c.Start <= other.End && other.Start <= c.End
+}
+
+// file is the content of a source file split into lines
+type file struct {
+	lines           []string
+	trailingNewline bool
+}
+
+func parseFile(text string) *file {
+	f := &file{trailingNewline: strings.HasSuffix(text, "\n")}
+	if text != "" {
+		f.lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	return f
+}
+
+func readFile(path string) (*file, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFile(string(content)), nil
+}
+
+func (f *file) String() string {
+	text := strings.Join(f.lines, "\n")
+	if f.trailingNewline {
+		text += "\n"
+	}
+	return text
+}
+
+// Plan resolves every suggestion against the files under root. A suggestion whose lines changed
+// since it was made is looked up elsewhere in the file; if its lines can't be found the change
+// carries a conflict instead of being applied to the wrong code.
+func Plan(root string, suggestions []config.FileSuggestion) []*Change {
+	files := make(map[string]*file)
+	changes := make([]*Change, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		change := &Change{Suggestion: suggestion}
+		changes = append(changes, change)
+
+		if !ValidPath(suggestion.FileName) {
+			change.Conflict = fmt.Sprintf("path %q is outside the repository", suggestion.FileName)
+			continue
+		}
+		f, ok := files[suggestion.FileName]
+		if !ok {
+			var err error
+			f, err = readFile(filepath.Join(root, suggestion.FileName))
+			if err != nil {
+				log.Printf("Could not read %s: %v", suggestion.FileName, err)
+				change.Conflict = "the file no longer exists"
+				continue
+			}
+			files[suggestion.FileName] = f
+		}
+		resolve(change, f)
+	}
+	return changes
+}
+
+// PlanContent resolves the suggestions for a single file against its content, e.g. as fetched
+// from the host rather than checked out
+func PlanContent(content string, suggestions []config.FileSuggestion) []*Change {
+	f := parseFile(content)
+	changes := make([]*Change, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		change := &Change{Suggestion: suggestion}
+		resolve(change, f)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Applicable splits changes into the ones that can be applied without a person confirming them
+// and the rest. Unverified changes and ones overlapping an earlier change are given a conflict.
+func Applicable(changes []*Change) (applicable, skipped []*Change) {
+	for _, change := range changes {
+		if change.Conflict == "" && !change.Verified {
+			change.Conflict = "could not verify the lines the suggestion was made for"
+		}
+		for _, other := range applicable {
+			if change.Conflict == "" && change.Overlaps(other) {
+				change.Conflict = fmt.Sprintf("overlaps the suggestion applied at %s", other.Lines())
+			}
+		}
+		if change.Conflict != "" {
+			skipped = append(skipped, change)
+		} else {
+			applicable = append(applicable, change)
+		}
+	}
+	return applicable, skipped
+}
+
+// ValidPath reports whether a suggested file path stays inside the repository
+func ValidPath(path string) bool {
+	return filepath.IsLocal(path)
+}
+
+// resolve finds the lines of f the suggestion in change replaces
+func resolve(change *Change, f *file) {
+	suggestion := change.Suggestion
+	line, err := strconv.Atoi(suggestion.LineNum)
+	if err != nil || line <= 0 {
+		change.Conflict = fmt.Sprintf("invalid line number %q", suggestion.LineNum)
+		return
+	}
+	start := line
+	if startLine, err := strconv.Atoi(suggestion.StartLineNum); err == nil && startLine > 0 && startLine < line {
+		start = startLine
+	}
+
+	if suggestion.Original == "" {
+		// Suggestions saved before originals were recorded can only be trusted by position
+		if line > len(f.lines) {
+			change.Conflict = fmt.Sprintf("the file has only %d lines", len(f.lines))
+			return
+		}
+		change.Start, change.End = start, line
+	} else {
+		original := strings.Split(suggestion.Original, "\n")
+		at, found := findBlock(f.lines, original, start)
+		if !found {
+			change.Conflict = fmt.Sprintf("%s changed since the suggestion was made", vcs.DescribeLines(start, line))
+			return
+		}
+		change.Start, change.End = at, at+len(original)-1
+		change.Relocated = at != start
+		change.Verified = true
+	}
+	change.Current = f.lines[change.Start-1 : change.End]
+
+	if equalLines(change.Current, strings.Split(suggestion.Content, "\n")) {
+		change.Conflict = "the suggestion is already applied"
+	}
+}
+
+// findBlock returns the 1-based line where block occurs in lines, preferring want and otherwise
+// the occurrence closest to it
+func findBlock(lines, block []string, want int) (int, bool) {
+	best, bestDistance := 0, -1
+	for at := 1; at+len(block)-1 <= len(lines); at++ {
+		if !equalLines(lines[at-1:at-1+len(block)], block) {
+			continue
+		}
+		distance := at - want
+		if distance < 0 {
+			distance = -distance
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = at, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// equalLines compares lines ignoring CRLF line endings
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.TrimSuffix(a[i], "\r") != strings.TrimSuffix(b[i], "\r") {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply writes the changes to the files under root and returns the paths it modified
+func Apply(root string, changes []*Change) ([]string, error) {
+	byFile := make(map[string][]*Change)
+	var paths []string
+	for _, change := range changes {
+		if _, ok := byFile[change.Suggestion.FileName]; !ok {
+			paths = append(paths, change.Suggestion.FileName)
+		}
+		byFile[change.Suggestion.FileName] = append(byFile[change.Suggestion.FileName], change)
+	}
+
+	for _, path := range paths {
+		fullPath := filepath.Join(root, path)
+		f, err := readFile(fullPath)
+		if err != nil {
+			log.Printf("Error reading %s: %v", path, err)
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+
+		fileChanges := byFile[path]
+		if err := f.replace(fileChanges); err != nil {
+			return nil, fmt.Errorf("%s %v", path, err)
+		}
+
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		if err := os.WriteFile(fullPath, []byte(f.String()), info.Mode().Perm()); err != nil {
+			log.Printf("Error writing %s: %v", path, err)
+			return nil, fmt.Errorf("error writing %s: %v", path, err)
+		}
+		log.Printf("Applied %d suggestions to %s", len(fileChanges), path)
+	}
+	return paths, nil
+}
+
+// ApplyContent applies changes planned with PlanContent to content and returns the new content
+func ApplyContent(content string, changes []*Change) (string, error) {
+	f := parseFile(content)
+	if err := f.replace(changes); err != nil {
+		return "", err
+	}
+	return f.String(), nil
+}
+
+// replace applies the changes bottom-up so earlier replacements don't shift later ones
+func (f *file) replace(changes []*Change) error {
+	sorted := append([]*Change{}, changes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start > sorted[j].Start })
+	for _, change := range sorted {
+		if change.End > len(f.lines) || !equalLines(f.lines[change.Start-1:change.End], change.Current) {
+			return fmt.Errorf("changed while applying suggestions")
+		}
+		replacement := strings.Split(change.Suggestion.Content, "\n")
+		lines := append([]string{}, f.lines[:change.Start-1]...)
+		lines = append(lines, replacement...)
+		f.lines = append(lines, f.lines[change.End:]...)
+	}
+	return nil
+}
+
+// FormatSource formats Go source in-process, for files that are never checked out. Other
+// languages and code that doesn't parse are returned unchanged.
+func FormatSource(path, content string) string {
+	if strings.ToLower(filepath.Ext(path)) != ".go" {
+		return content
+	}
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		log.Printf("Warning: could not gofmt %s: %v", path, err)
+		return content
+	}
+	return string(formatted)
+}
+
+// formatters are the formatter commands run on changed files, by extension. The file paths are
+// appended to the arguments.
+var formatters = map[string][]string{
+	".go":  {"gofmt", "-w"},
+	".py":  {"black", "-q"},
+	".rs":  {"rustfmt"},
+	".js":  {"prettier", "--write", "--log-level", "warn"},
+	".jsx": {"prettier", "--write", "--log-level", "warn"},
+	".ts":  {"prettier", "--write", "--log-level", "warn"},
+	".tsx": {"prettier", "--write", "--log-level", "warn"},
+}
+
+// Format runs the language formatter of each changed file. Formatters that aren't installed are
+// skipped, and a formatter failing only leaves that file unformatted.
+func Format(root string, paths []string) {
+	byFormatter := make(map[string][]string)
+	for _, path := range paths {
+		if formatter, ok := formatters[strings.ToLower(filepath.Ext(path))]; ok {
+			key := strings.Join(formatter, " ")
+			byFormatter[key] = append(byFormatter[key], path)
+		}
+	}
+
+	for key, files := range byFormatter {
+		formatter := strings.Fields(key)
+		if _, err := exec.LookPath(formatter[0]); err != nil {
+			log.Printf("Warning: %s is not installed, leaving %s unformatted", formatter[0], strings.Join(files, ", "))
+			continue
+		}
+		cmd := exec.Command(formatter[0], append(formatter[1:], files...)...)
+		cmd.Dir = root
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("Warning: %s failed on %s: %v: %s", formatter[0], strings.Join(files, ", "), err, strings.TrimSpace(stderr.String()))
+			continue
+		}
+		log.Printf("Formatted %s with %s", strings.Join(files, ", "), formatter[0])
+	}
+}
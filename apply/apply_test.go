@@ -0,0 +1,224 @@
+package apply
+
+import (
+	"tracepr/config"
+	"strings"
+	"testing"
+)
+
+func TestFindBlock(t *testing.T) {
+	lines := []string{"a", "log(x)", "b", "c", "log(x)", "d", "log(x)"}
+	tests := []struct {
+		name      string
+		lines     []string
+		block     []string
+		want      int
+		wantAt    int
+		wantFound bool
+	}{
+		{"exact match", lines, []string{"b", "c"}, 3, 3, true},
+		{"moved down", lines, []string{"b", "c"}, 1, 3, true},
+		{"duplicate at the wanted line", lines, []string{"log(x)"}, 5, 5, true},
+		{"duplicate closest to the wanted line", lines, []string{"log(x)"}, 3, 2, true},
+		{"duplicate equally close prefers the earlier one", lines, []string{"log(x)"}, 6, 5, true},
+		{"CRLF line endings", []string{"a\r", "b\r", "c\r"}, []string{"b", "c"}, 2, 2, true},
+		{"re-indented block", []string{"a", "    b", "    c"}, []string{"b", "c"}, 2, 0, false},
+		{"missing block", lines, []string{"e"}, 1, 0, false},
+		{"block longer than the file", []string{"a"}, []string{"a", "b"}, 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, found := findBlock(tt.lines, tt.block, tt.want)
+			if at != tt.wantAt || found != tt.wantFound {
+				t.Errorf("findBlock() = %d, %v, want %d, %v", at, found, tt.wantAt, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	const content = "package main\n\nfunc main() {\n\trun()\n\tstop()\n}\n"
+	tests := []struct {
+		name          string
+		content       string
+		suggestion    config.FileSuggestion
+		wantStart     int
+		wantEnd       int
+		wantRelocated bool
+		wantVerified  bool
+		wantConflict  string // substring of the conflict, empty when there is none
+	}{
+		{
+			name:         "exact match",
+			content:      content,
+			suggestion:   config.FileSuggestion{LineNum: "4", Original: "\trun()", Content: "\tif err := run(); err != nil {\n\t\tlog.Println(err)\n\t}"},
+			wantStart:    4,
+			wantEnd:      4,
+			wantVerified: true,
+		},
+		{
+			name:         "multi-line range",
+			content:      content,
+			suggestion:   config.FileSuggestion{StartLineNum: "4", LineNum: "5", Original: "\trun()\n\tstop()", Content: "\trun()\n\tlog.Println(\"stopping\")\n\tstop()"},
+			wantStart:    4,
+			wantEnd:      5,
+			wantVerified: true,
+		},
+		{
+			name:          "lines moved since the suggestion",
+			content:       "package main\n\nimport \"log\"\n\nfunc main() {\n\trun()\n\tstop()\n}\n",
+			suggestion:    config.FileSuggestion{LineNum: "4", Original: "\trun()", Content: "\trun()\n\tlog.Println(\"ran\")"},
+			wantStart:     6,
+			wantEnd:       6,
+			wantRelocated: true,
+			wantVerified:  true,
+		},
+		{
+			name:         "CRLF line endings",
+			content:      strings.ReplaceAll(content, "\n", "\r\n"),
+			suggestion:   config.FileSuggestion{LineNum: "4", Original: "\trun()", Content: "\trun()\n\tlog.Println(\"ran\")"},
+			wantStart:    4,
+			wantEnd:      4,
+			wantVerified: true,
+		},
+		{
+			name:         "re-indented lines",
+			content:      "package main\n\nfunc main() {\n    run()\n    stop()\n}\n",
+			suggestion:   config.FileSuggestion{LineNum: "4", Original: "\trun()", Content: "\trun()\n\tlog.Println(\"ran\")"},
+			wantConflict: "line 4 changed since the suggestion was made",
+		},
+		{
+			name:         "missing lines",
+			content:      content,
+			suggestion:   config.FileSuggestion{LineNum: "4", Original: "\tstart()", Content: "\tstart()\n\tlog.Println(\"started\")"},
+			wantConflict: "changed since the suggestion was made",
+		},
+		{
+			name:         "already applied",
+			content:      content,
+			suggestion:   config.FileSuggestion{LineNum: "4", Original: "\trun()", Content: "\trun()"},
+			wantStart:    4,
+			wantEnd:      4,
+			wantVerified: true,
+			wantConflict: "already applied",
+		},
+		{
+			name:       "no original is trusted by position only",
+			content:    content,
+			suggestion: config.FileSuggestion{LineNum: "5", Content: "\tdefer stop()"},
+			wantStart:  5,
+			wantEnd:    5,
+		},
+		{
+			name:         "no original past the end of the file",
+			content:      content,
+			suggestion:   config.FileSuggestion{LineNum: "9", Content: "\tstop()"},
+			wantConflict: "the file has only 6 lines",
+		},
+		{
+			name:         "invalid line number",
+			content:      content,
+			suggestion:   config.FileSuggestion{LineNum: "four", Content: "\tstop()"},
+			wantConflict: "invalid line number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := PlanContent(tt.content, []config.FileSuggestion{tt.suggestion})[0]
+			if tt.wantConflict == "" && change.Conflict != "" {
+				t.Fatalf("unexpected conflict %q", change.Conflict)
+			}
+			if !strings.Contains(change.Conflict, tt.wantConflict) {
+				t.Fatalf("conflict = %q, want it to mention %q", change.Conflict, tt.wantConflict)
+			}
+			if change.Start != tt.wantStart || change.End != tt.wantEnd {
+				t.Errorf("lines = %d-%d, want %d-%d", change.Start, change.End, tt.wantStart, tt.wantEnd)
+			}
+			if change.Relocated != tt.wantRelocated || change.Verified != tt.wantVerified {
+				t.Errorf("relocated, verified = %v, %v, want %v, %v", change.Relocated, change.Verified, tt.wantRelocated, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestApplicableAndReplace(t *testing.T) {
+	const content = "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name        string
+		suggestions []config.FileSuggestion
+		wantApplied int
+		wantSkipped []string // substrings of the skipped changes' conflicts, in order
+		wantContent string
+	}{
+		{
+			name: "separate suggestions in one file",
+			suggestions: []config.FileSuggestion{
+				{LineNum: "2", Original: "b", Content: "b1\nb2"},
+				{LineNum: "4", Original: "d", Content: "d1"},
+			},
+			wantApplied: 2,
+			wantContent: "a\nb1\nb2\nc\nd1\ne\n",
+		},
+		{
+			name: "overlapping suggestions in one file",
+			suggestions: []config.FileSuggestion{
+				{StartLineNum: "2", LineNum: "3", Original: "b\nc", Content: "bc"},
+				{StartLineNum: "3", LineNum: "4", Original: "c\nd", Content: "cd"},
+				{LineNum: "5", Original: "e", Content: "e1"},
+			},
+			wantApplied: 2,
+			wantSkipped: []string{"overlaps the suggestion applied at lines 2-3"},
+			wantContent: "a\nbc\nd\ne1\n",
+		},
+		{
+			name: "duplicate suggestions for the same line",
+			suggestions: []config.FileSuggestion{
+				{LineNum: "2", Original: "b", Content: "b1"},
+				{LineNum: "2", Original: "b", Content: "b2"},
+			},
+			wantApplied: 1,
+			wantSkipped: []string{"overlaps the suggestion applied at line 2"},
+			wantContent: "a\nb1\nc\nd\ne\n",
+		},
+		{
+			name: "unverified and missing suggestions",
+			suggestions: []config.FileSuggestion{
+				{LineNum: "1", Content: "a1"},
+				{LineNum: "3", Original: "x", Content: "x1"},
+			},
+			wantSkipped: []string{"could not verify", "changed since the suggestion was made"},
+			wantContent: content,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applicable, skipped := Applicable(PlanContent(content, tt.suggestions))
+			if len(applicable) != tt.wantApplied {
+				t.Errorf("%d applicable changes, want %d", len(applicable), tt.wantApplied)
+			}
+			if len(skipped) != len(tt.wantSkipped) {
+				t.Fatalf("%d skipped changes, want %d", len(skipped), len(tt.wantSkipped))
+			}
+			for i, change := range skipped {
+				if !strings.Contains(change.Conflict, tt.wantSkipped[i]) {
+					t.Errorf("skipped change %d has conflict %q, want it to mention %q", i, change.Conflict, tt.wantSkipped[i])
+				}
+			}
+
+			got, err := ApplyContent(content, applicable)
+			if err != nil {
+				t.Fatalf("ApplyContent: %v", err)
+			}
+			if got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestReplaceRejectsChangedContent(t *testing.T) {
+	changes := PlanContent("a\nb\nc\n", []config.FileSuggestion{{LineNum: "2", Original: "b", Content: "b1"}})
+	if _, err := ApplyContent("a\nB\nc\n", changes); err == nil {
+		t.Error("applied a change to lines that changed after planning")
+	}
+}
@@ -0,0 +1,124 @@
+package bitbucket
+
+import (
+	"tracepr/config"
+	"tracepr/git"
+	"tracepr/llm"
+	"tracepr/vcs"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+)
+
+// pullRequest is the subset of the Bitbucket pull request resource TracePR reads
+type pullRequest struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	CreatedOn   string `json:"created_on"`
+	Author      struct {
+		DisplayName string `json:"display_name"`
+		Nickname    string `json:"nickname"`
+	} `json:"author"`
+	Source struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+}
+
+func (c *Client) getPullRequest(ctx context.Context, id int) (*pullRequest, error) {
+	var pr pullRequest
+	if _, err := c.Do(ctx, http.MethodGet, c.pullPath(id), nil, nil, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// FetchPRDetails builds the same prDetails map as github.FetchPRDetails for a Bitbucket pull request
+func FetchPRDetails(ctx context.Context, client *Client, cfg config.Config) (config.Config, map[string]interface{}, error) {
+	log.Printf("Fetching PR details for PR #%d in %s/%s", cfg.PRNumber, client.Workspace, client.RepoSlug)
+	result := make(map[string]interface{})
+
+	pr, err := client.getPullRequest(ctx, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching PR details: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR details: %v", err)
+	}
+
+	author := pr.Author.Nickname
+	if author == "" {
+		author = pr.Author.DisplayName
+	}
+	result["title"] = pr.Title
+	result["description"] = pr.Description
+	result["author"] = author
+	result["created_at"] = pr.CreatedOn
+	cfg.PRBranch = pr.Source.Branch.Name
+	cfg.HeadSHA = pr.Source.Commit.Hash
+	log.Println("PR branch:", cfg.PRBranch)
+
+	log.Printf("Fetching commits for PR #%d", cfg.PRNumber)
+	commits, err := listAll[struct {
+		Hash string `json:"hash"`
+	}](ctx, client, client.pullPath(cfg.PRNumber)+"/commits", nil)
+	if err != nil {
+		log.Printf("Error fetching PR commits: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR commits: %v", err)
+	}
+
+	// The diffstat endpoint carries no patches, so read the whole diff instead
+	log.Printf("Fetching diff for PR #%d", cfg.PRNumber)
+	_, diff, err := client.DoRaw(ctx, http.MethodGet, client.pullPath(cfg.PRNumber)+"/diff", nil, "", nil)
+	if err != nil {
+		log.Printf("Error fetching PR diff: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR diff: %v", err)
+	}
+
+	fileDetails := git.ParseUnifiedDiff(string(diff))
+	log.Printf("Processing %d files from PR", len(fileDetails))
+	llm.ApplyDiffBudget(result, fileDetails, cfg)
+	result["commits"] = len(commits)
+
+	log.Printf("Successfully fetched PR details with %d files and %d commits", len(result["files"].([]map[string]interface{})), len(commits))
+	return cfg, result, nil
+}
+
+// CommitFiles writes and deletes files on the PR branch in a single commit
+func CommitFiles(ctx context.Context, client *Client, cfg config.Config, changes []vcs.FileChange, message string) error {
+	changes = vcs.CollapseFileChanges(changes)
+
+	// The src endpoint takes each written file as a form field named after its path, and the
+	// paths to delete as "files" fields without content
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	fields := [][2]string{{"message", message}, {"branch", cfg.PRBranch}}
+	for _, change := range changes {
+		if change.Delete {
+			fields = append(fields, [2]string{"files", change.Path})
+		} else {
+			fields = append(fields, [2]string{change.Path, change.Content})
+		}
+	}
+	for _, field := range fields {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to build commit form: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to build commit form: %w", err)
+	}
+
+	if _, _, err := client.DoRaw(ctx, http.MethodPost, client.repoPath()+"/src", nil, writer.FormDataContentType(), form.Bytes()); err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	log.Printf("Committed %d files to PR branch %s", len(changes), cfg.PRBranch)
+	return nil
+}
@@ -0,0 +1,300 @@
+package bitbucket
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request is a call the fake Bitbucket received
+type request struct {
+	Method      string
+	Path        string
+	Query       string
+	ContentType string
+	Body        string
+}
+
+// fakeBitbucket answers the routed endpoints, keyed by "METHOD path", and 404s everything else
+type fakeBitbucket struct {
+	t        *testing.T
+	url      string
+	wantAuth string
+	routes   map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []request
+}
+
+func newFakeBitbucket(t *testing.T) (*fakeBitbucket, config.Config) {
+	f := &fakeBitbucket{t: t, wantAuth: "Bearer secret", routes: make(map[string]http.HandlerFunc)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	cfg := config.Config{
+		BitbucketBaseURL: server.URL + "/2.0",
+		BitbucketToken:   "secret",
+		RepoOwner:        "team",
+		RepoName:         "service",
+		PRNumber:         5,
+		ClaudeModel:      "claude-3-5-sonnet",
+	}
+	return f, cfg
+}
+
+func (f *fakeBitbucket) handle(method, path string, handler http.HandlerFunc) {
+	f.routes[method+" /2.0/repositories/team/service"+path] = handler
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.mu.Lock()
+	f.requests = append(f.requests, request{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, ContentType: r.Header.Get("Content-Type"), Body: string(body)})
+	f.mu.Unlock()
+
+	if auth := r.Header.Get("Authorization"); auth != f.wantAuth {
+		f.t.Errorf("%s %s was sent with Authorization %q, want %q", r.Method, r.URL.Path, auth, f.wantAuth)
+	}
+	handler, ok := f.routes[r.Method+" "+r.URL.EscapedPath()]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+// sent returns the requests made with method to a path ending in suffix
+func (f *fakeBitbucket) sent(method, suffix string) []request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matching []request
+	for _, req := range f.requests {
+		if req.Method == method && strings.HasSuffix(req.Path, suffix) {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func respondJSON(v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// paged serves one page per "page" query parameter and links them with the envelope's next URL
+func (f *fakeBitbucket) paged(pages ...interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		envelope := map[string]interface{}{"values": pages[page-1]}
+		if page < len(pages) {
+			envelope["next"] = fmt.Sprintf("%s%s?pagelen=%d&page=%d", f.url, r.URL.Path, pageLen, page+1)
+		}
+		respondJSON(envelope)(w, r)
+	}
+}
+
+func pullRequestJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          5,
+		"title":       "Add checkout metrics",
+		"description": "Counts checkouts",
+		"author":      map[string]string{"display_name": "Dev Eloper", "nickname": "dev"},
+		"source": map[string]interface{}{
+			"branch": map[string]string{"name": "feature/metrics"},
+			"commit": map[string]string{"hash": "head"},
+		},
+	}
+}
+
+const pullDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ a
++b
+ c
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-a
+-b
+`
+
+func TestFetchPRDetails(t *testing.T) {
+	f, cfg := newFakeBitbucket(t)
+	f.handle("GET", "/pullrequests/5", respondJSON(pullRequestJSON()))
+	f.handle("GET", "/pullrequests/5/commits", f.paged(
+		[]map[string]string{{"hash": "c1"}, {"hash": "c2"}},
+		[]map[string]string{{"hash": "c3"}},
+	))
+	f.handle("GET", "/pullrequests/5/diff", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, pullDiff)
+	})
+
+	cfg, details, err := FetchPRDetails(context.Background(), NewClient(cfg), cfg)
+	if err != nil {
+		t.Fatalf("FetchPRDetails: %v", err)
+	}
+
+	if cfg.PRBranch != "feature/metrics" || cfg.HeadSHA != "head" {
+		t.Errorf("got branch %q and head %q, want feature/metrics and head", cfg.PRBranch, cfg.HeadSHA)
+	}
+	if details["title"] != "Add checkout metrics" || details["author"] != "dev" {
+		t.Errorf("unexpected title %v or author %v", details["title"], details["author"])
+	}
+	if details["commits"] != 3 {
+		t.Errorf("commits = %v, want 3 across both pages", details["commits"])
+	}
+	pages := f.sent("GET", "/pullrequests/5/commits")
+	if len(pages) != 2 {
+		t.Fatalf("fetched %d pages of commits, want 2", len(pages))
+	}
+	if !strings.Contains(pages[0].Query, "pagelen=50") {
+		t.Errorf("commits requested without pagelen: %s", pages[0].Query)
+	}
+
+	files := details["files"].([]map[string]interface{})
+	byName := make(map[string]map[string]interface{})
+	for _, file := range files {
+		byName[file["filename"].(string)] = file
+	}
+	if file := byName["main.go"]; file == nil || file["additions"] != 1 || file["patch"] != "@@ -1,2 +1,3 @@\n a\n+b\n c" {
+		t.Errorf("main.go = %v", file)
+	}
+	if file := byName["gone.go"]; file == nil || file["status"] != "removed" || file["deletions"] != 2 {
+		t.Errorf("gone.go = %v", file)
+	}
+}
+
+func TestCreateObservabilityPRComments(t *testing.T) {
+	f, cfg := newFakeBitbucket(t)
+	f.routes["GET /2.0/user"] = respondJSON(map[string]string{"uuid": "{bot}"})
+	bot := map[string]string{"uuid": "{bot}"}
+	dev := map[string]string{"uuid": "{dev}"}
+	inline := map[string]interface{}{"path": "main.go", "to": 3}
+	f.handle("GET", "/pullrequests/5/comments", f.paged(
+		[]map[string]interface{}{
+			// A reviewer quoting the summary must not have their comment edited
+			{"id": 9, "user": dev, "content": map[string]string{"raw": "> Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")}},
+			{"id": 10, "user": bot, "content": map[string]string{"raw": "Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")}},
+			{"id": 11, "user": bot, "inline": inline, "content": map[string]string{"raw": "old\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:9")}},
+		},
+		[]map[string]interface{}{
+			{"id": 12, "user": bot, "inline": inline, "content": map[string]string{"raw": "c2\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:3")}},
+			{"id": 13, "user": bot, "deleted": true, "content": map[string]string{"raw": vcs.Marker(vcs.MarkerSummary, "check")}},
+			{"id": 14, "user": dev, "inline": inline, "content": map[string]string{"raw": "> old\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:8")}},
+		},
+	))
+	ok := respondJSON(map[string]interface{}{})
+	f.handle("POST", "/pullrequests/5/comments", ok)
+	f.handle("PUT", "/pullrequests/5/comments/10", ok)
+	f.handle("PUT", "/pullrequests/5/comments/11", ok)
+	f.handle("POST", "/pullrequests/5/request-changes", ok)
+
+	prDetails := map[string]interface{}{"files": []map[string]interface{}{
+		{"filename": "main.go", "patch": "@@ -1,2 +1,3 @@\n a\n+b\n c"},
+	}}
+	suggestions := []config.FileSuggestion{
+		{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "high"},
+		{FileName: "main.go", LineNum: "3", Content: "c2", Severity: "low"},
+	}
+	if err := CreateObservabilityPRComments(context.Background(), NewClient(cfg), suggestions, prDetails, cfg, "Two gaps"); err != nil {
+		t.Fatalf("CreateObservabilityPRComments: %v", err)
+	}
+
+	// Only the suggestion that wasn't posted before gets a new comment, and the summary is edited
+	created := f.sent("POST", "/comments")
+	if len(created) != 1 {
+		t.Fatalf("posted %d comments, want 1", len(created))
+	}
+	var posted struct {
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		Inline map[string]interface{} `json:"inline"`
+	}
+	json.Unmarshal([]byte(created[0].Body), &posted)
+	if posted.Inline["path"] != "main.go" || posted.Inline["to"] != float64(2) {
+		t.Errorf("unexpected inline position %v", posted.Inline)
+	}
+	if strings.Contains(posted.Content.Raw, "```suggestion") || !strings.Contains(posted.Content.Raw, vcs.Marker(vcs.MarkerSuggestion, "main.go:2")) {
+		t.Errorf("unexpected comment body %q", posted.Content.Raw)
+	}
+
+	if stale := f.sent("PUT", "/comments/11"); len(stale) != 1 || !strings.Contains(stale[0].Body, "Outdated") {
+		t.Errorf("stale suggestion edits = %v", stale)
+	}
+	if summary := f.sent("PUT", "/comments/10"); len(summary) != 1 || !strings.Contains(summary[0].Body, "Two gaps") {
+		t.Errorf("summary edits = %v", summary)
+	}
+	if requested := f.sent("POST", "/request-changes"); len(requested) != 1 {
+		t.Errorf("requested changes %d times, want once for the high severity suggestion", len(requested))
+	}
+	if edited := append(f.sent("PUT", "/comments/9"), f.sent("PUT", "/comments/14")...); len(edited) != 0 {
+		t.Errorf("edited another user's comments: %v", edited)
+	}
+}
+
+func TestProviderCommitFiles(t *testing.T) {
+	f, cfg := newFakeBitbucket(t)
+	// With a username the token is sent as an app password
+	cfg.BitbucketUsername = "bot"
+	f.wantAuth = "Basic Ym90OnNlY3JldA=="
+	f.handle("GET", "/pullrequests/5", respondJSON(pullRequestJSON()))
+	f.handle("POST", "/src", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	changes := []vcs.FileChange{
+		{Path: "rules/a.yml", Content: "first"},
+		{Path: "rules/b.yml", Content: "new rule"},
+		{Path: "rules/c.yml", Delete: true},
+		{Path: "rules/a.yml", Content: "second"},
+	}
+	// The provider looks up the PR branch itself when it hasn't been fetched
+	if err := NewProvider(cfg).CommitFiles(context.Background(), changes, "Add rules"); err != nil {
+		t.Fatalf("CommitFiles: %v", err)
+	}
+
+	commits := f.sent("POST", "/src")
+	if len(commits) != 1 {
+		t.Fatalf("created %d commits, want 1", len(commits))
+	}
+	_, params, err := mime.ParseMediaType(commits[0].ContentType)
+	if err != nil {
+		t.Fatalf("commit has content type %q: %v", commits[0].ContentType, err)
+	}
+	form, err := multipart.NewReader(strings.NewReader(commits[0].Body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("commit is not a multipart form: %v", err)
+	}
+	want := map[string][]string{
+		"message":     {"Add rules"},
+		"branch":      {"feature/metrics"},
+		"rules/a.yml": {"second"},
+		"rules/b.yml": {"new rule"},
+		"files":       {"rules/c.yml"},
+	}
+	if fmt.Sprint(form.Value) != fmt.Sprint(want) {
+		t.Errorf("form = %v, want %v", form.Value, want)
+	}
+}
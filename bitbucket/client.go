@@ -0,0 +1,71 @@
+package bitbucket
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// pageLen is the page size for list requests; 50 is the maximum most Bitbucket endpoints accept
+const pageLen = 50
+
+// Client is a minimal Bitbucket Cloud REST (2.0) client for the pull request endpoints TracePR uses
+type Client struct {
+	*vcs.APIClient
+	Workspace string
+	RepoSlug  string
+}
+
+// NewClient returns a client for the repository RepoOwner/RepoName (workspace/repo slug).
+// The token is sent as a bearer access token, or as an app password when a username is set.
+func NewClient(cfg config.Config) *Client {
+	return &Client{
+		APIClient: vcs.NewAPIClient("Bitbucket", cfg.BitbucketBaseURL, func(req *http.Request) {
+			if cfg.BitbucketUsername != "" {
+				req.SetBasicAuth(cfg.BitbucketUsername, cfg.BitbucketToken)
+			} else {
+				req.Header.Set("Authorization", "Bearer "+cfg.BitbucketToken)
+			}
+		}),
+		Workspace: cfg.RepoOwner,
+		RepoSlug:  cfg.RepoName,
+	}
+}
+
+// repoPath returns the URL prefix for the repository's endpoints
+func (c *Client) repoPath() string {
+	return "/repositories/" + url.PathEscape(c.Workspace) + "/" + url.PathEscape(c.RepoSlug)
+}
+
+func (c *Client) pullPath(id int) string {
+	return c.repoPath() + "/pullrequests/" + strconv.Itoa(id)
+}
+
+// page is the envelope Bitbucket wraps every list response in
+type page[T any] struct {
+	Values []T    `json:"values"`
+	Next   string `json:"next"`
+}
+
+// listAll fetches every page of a Bitbucket list endpoint, following the next link
+func listAll[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("pagelen", strconv.Itoa(pageLen))
+
+	var all []T
+	for path != "" {
+		var current page[T]
+		if _, err := c.Do(ctx, http.MethodGet, path, query, nil, &current); err != nil {
+			return all, err
+		}
+		all = append(all, current.Values...)
+		// The next link already carries the query
+		path, query = current.Next, nil
+	}
+	return all, nil
+}
@@ -0,0 +1,144 @@
+package bitbucket
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// comment is a Bitbucket pull request comment; inline comments carry the file and line
+type comment struct {
+	ID      int64 `json:"id"`
+	Deleted bool  `json:"deleted"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	Inline *struct {
+		Path string `json:"path"`
+	} `json:"inline"`
+	User struct {
+		UUID string `json:"uuid"`
+	} `json:"user"`
+}
+
+// botLogin returns the account UUID TracePR posts as: the configured one or the owner of the
+// token. Bitbucket users have no stable login, so comments are matched by UUID.
+func botLogin(ctx context.Context, client *Client, cfg config.Config) (string, error) {
+	if cfg.BotLogin != "" {
+		return cfg.BotLogin, nil
+	}
+	var user struct {
+		UUID string `json:"uuid"`
+	}
+	if _, err := client.Do(ctx, http.MethodGet, "/user", nil, nil, &user); err != nil {
+		log.Printf("Error fetching Bitbucket user: %v", err)
+		return "", fmt.Errorf("error fetching Bitbucket user: %v", err)
+	}
+	return user.UUID, nil
+}
+
+// listComments returns every comment on the PR, split into conversation comments and inline comments
+func listComments(ctx context.Context, client *Client, id int) (comments, inline []vcs.Comment, err error) {
+	all, err := listAll[comment](ctx, client, client.pullPath(id)+"/comments", nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing PR comments: %v", err)
+	}
+
+	for _, c := range all {
+		if c.Deleted {
+			continue
+		}
+		result := vcs.Comment{ID: c.ID, Author: c.User.UUID, Body: c.Content.Raw}
+		if c.Inline != nil {
+			inline = append(inline, result)
+		} else {
+			comments = append(comments, result)
+		}
+	}
+	return comments, inline, nil
+}
+
+func createComment(ctx context.Context, client *Client, id int, body string, inline map[string]interface{}) error {
+	payload := map[string]interface{}{"content": map[string]string{"raw": body}}
+	if inline != nil {
+		payload["inline"] = inline
+	}
+	_, err := client.Do(ctx, http.MethodPost, client.pullPath(id)+"/comments", nil, payload, nil)
+	return err
+}
+
+func editComment(ctx context.Context, client *Client, id int, commentID int64, body string) error {
+	path := fmt.Sprintf("%s/comments/%d", client.pullPath(id), commentID)
+	_, err := client.Do(ctx, http.MethodPut, path, nil, map[string]interface{}{"content": map[string]string{"raw": body}}, nil)
+	return err
+}
+
+// CreateObservabilityPRComments posts every inline suggestion as its own comment and keeps a single
+// summary comment up to date. High severity suggestions also request changes on the PR.
+func CreateObservabilityPRComments(ctx context.Context, client *Client, suggestions []config.FileSuggestion, prDetails map[string]interface{}, cfg config.Config, summary string) error {
+	log.Printf("Creating observability PR comments for PR #%d", cfg.PRNumber)
+
+	log.Printf("Loading previous TracePR PR comments")
+	comments, inline, err := listComments(ctx, client, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error listing PR comments: %v", err)
+		return err
+	}
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	plan := vcs.PlanReview(suggestions, prDetails, summary, inline, self)
+	body := vcs.PostInlineComments(plan, func(c vcs.ReviewComment) error {
+		// Bitbucket has no suggested changes, so show them as plain code
+		return createComment(ctx, client, cfg.PRNumber, vcs.WithMarker(vcs.PlainSuggestion(c.Body), vcs.MarkerSuggestion, c.Key), map[string]interface{}{
+			"path": c.Path,
+			"to":   c.Line,
+		})
+	}, func(commentID int64, body string) error {
+		return editComment(ctx, client, cfg.PRNumber, commentID, body)
+	})
+
+	err = vcs.SyncComments(comments, self, []vcs.MarkedComment{{Kind: vcs.MarkerSummary, Key: "check", Body: body}}, func(body string) error {
+		return createComment(ctx, client, cfg.PRNumber, body, nil)
+	}, func(commentID int64, body string) error {
+		return editComment(ctx, client, cfg.PRNumber, commentID, body)
+	})
+	if err != nil {
+		log.Printf("Error posting PR summary: %v", err)
+		return fmt.Errorf("error posting PR summary: %v", err)
+	}
+
+	if vcs.HasHighSeverity(suggestions) {
+		log.Printf("Requesting changes on PR #%d", cfg.PRNumber)
+		if _, err := client.Do(ctx, http.MethodPost, client.pullPath(cfg.PRNumber)+"/request-changes", nil, nil, nil); err != nil {
+			// Bitbucket refuses to let authors request changes on their own PR
+			log.Printf("Could not request changes: %v", err)
+		}
+	}
+
+	log.Printf("Successfully created observability PR comments")
+	return nil
+}
+
+// SyncPRComments makes the PR conversation match the desired comments, see vcs.SyncComments
+func SyncPRComments(ctx context.Context, client *Client, cfg config.Config, desired []vcs.MarkedComment, staleKinds ...string) error {
+	existing, _, err := listComments(ctx, client, cfg.PRNumber)
+	if err != nil {
+		return err
+	}
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	return vcs.SyncComments(existing, self, desired, func(body string) error {
+		return createComment(ctx, client, cfg.PRNumber, body, nil)
+	}, func(commentID int64, body string) error {
+		return editComment(ctx, client, cfg.PRNumber, commentID, body)
+	}, staleKinds...)
+}
@@ -0,0 +1,78 @@
+package bitbucket
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Provider reviews Bitbucket Cloud pull requests
+type Provider struct {
+	cfg    config.Config
+	client *Client
+}
+
+// NewProvider returns a Bitbucket provider for the pull request in cfg
+func NewProvider(cfg config.Config) *Provider {
+	log.Printf("Initializing Bitbucket client for %s", cfg.BitbucketBaseURL)
+	return &Provider{cfg: cfg, client: NewClient(cfg)}
+}
+
+func (p *Provider) Name() string {
+	return "bitbucket"
+}
+
+func (p *Provider) FetchChangeDetails(ctx context.Context) (config.Config, map[string]interface{}, error) {
+	cfg, prDetails, err := FetchPRDetails(ctx, p.client, p.cfg)
+	if err != nil {
+		return cfg, nil, err
+	}
+	p.cfg = cfg
+	return cfg, prDetails, nil
+}
+
+func (p *Provider) PostReview(ctx context.Context, suggestions []config.FileSuggestion, prDetails map[string]interface{}, summary string) error {
+	if _, err := p.Identity(ctx); err != nil {
+		return err
+	}
+	return CreateObservabilityPRComments(ctx, p.client, suggestions, prDetails, p.cfg, summary)
+}
+
+func (p *Provider) SyncComments(ctx context.Context, desired []vcs.MarkedComment, staleKinds ...string) error {
+	if _, err := p.Identity(ctx); err != nil {
+		return err
+	}
+	return SyncPRComments(ctx, p.client, p.cfg, desired, staleKinds...)
+}
+
+func (p *Provider) ListComments(ctx context.Context) ([]vcs.Comment, error) {
+	comments, _, err := listComments(ctx, p.client, p.cfg.PRNumber)
+	return comments, err
+}
+
+// Identity returns the account UUID TracePR posts as, looked up once per provider
+func (p *Provider) Identity(ctx context.Context) (string, error) {
+	if p.cfg.BotLogin == "" {
+		login, err := botLogin(ctx, p.client, p.cfg)
+		if err != nil {
+			return "", err
+		}
+		p.cfg.BotLogin = login
+	}
+	return p.cfg.BotLogin, nil
+}
+
+func (p *Provider) CommitFiles(ctx context.Context, changes []vcs.FileChange, message string) error {
+	if p.cfg.PRBranch == "" {
+		// The branch is only known once the PR has been fetched
+		log.Printf("Looking up head branch of PR #%d", p.cfg.PRNumber)
+		pr, err := p.client.getPullRequest(ctx, p.cfg.PRNumber)
+		if err != nil {
+			return fmt.Errorf("error fetching PR head branch: %v", err)
+		}
+		p.cfg.PRBranch = pr.Source.Branch.Name
+	}
+	return CommitFiles(ctx, p.client, p.cfg, changes, message)
+}
@@ -4,13 +4,17 @@ package cmd
 import (
 	"tracepr/alerts"
 	"tracepr/config"
-	"tracepr/github"
 	"tracepr/llm"
+	"tracepr/report"
+	"tracepr/store"
+	"tracepr/utils"
+	"tracepr/vcs"
 	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -41,7 +45,7 @@ func init() {
 	alertsCmd.Flags().BoolVar(&createAlertFlag, "create", false, "Create a specific alert")
 	alertsCmd.Flags().BoolVar(&createAllAlertsFlag, "create-all", false, "Create all suggested alerts")
 	alertsCmd.Flags().StringVar(&alertName, "name", "", "Name of the alert to create (used with --create)")
-	alertsCmd.Flags().StringVar(&alertType, "type", "", "Type of alert (prometheus, datadog)")
+	alertsCmd.Flags().StringVar(&alertType, "type", "", fmt.Sprintf("Type of alert (%s)", strings.Join(utils.AlertTypes, ", ")))
 	alertsCmd.Flags().BoolVar(&skipAlertPromptFlag, "skip-prompt", false, "Skip interactive prompts (for CI/CD)")
 	alertsCmd.Flags().BoolVar(&runningInCIFlag, "running-in-ci", false, "Specify if tool is running in CI")
 
@@ -52,35 +56,42 @@ func runAlerts() {
 	cfg := config.LoadConfig()
 
 	cfg.RunningInCI = runningInCIFlag
+	rep := report.New("alerts", cfg)
 
-	// Initialize GitHub client
-	log.Println("INFO: Initializing GitHub client...")
 	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
+	provider, err := newProvider(ctx, cfg)
+	if err != nil {
+		failRun(cfg, rep, "%v", err)
+	}
 
 	// Fetch PR details including diff
 	log.Printf("INFO: Fetching PR details for PR #%d...", cfg.PRNumber)
-	cfg, prDetails, err := github.FetchPRDetails(githubClient, cfg)
+	cfg, prDetails, err := provider.FetchChangeDetails(ctx)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to fetch PR details: %v", err)
+		failRun(cfg, rep, "Failed to fetch PR details: %v", err)
 	}
 	log.Printf("INFO: Successfully fetched PR details for '%s'", prDetails["title"])
+	rep.SetChange(cfg, prDetails)
 
 	// Check for specific alert creation first
 	if createAlertFlag && alertName != "" {
 		log.Printf("INFO: Creating specific alert: %s", alertName)
-		createSpecificAlert(cfg, alertName, alertType)
+		if err := createSpecificAlert(ctx, provider, cfg, alertName, alertType, rep); err != nil {
+			failRun(cfg, rep, "Failed to create alert: %v", err)
+		}
+		writeReport(cfg, rep)
 		return
 	}
 
 	if createAllAlertsFlag {
 		log.Println("INFO: Creating all suggested alerts...")
 		// First load saved suggestions
-		savedAlerts, err := loadSavedAlertSuggestions(cfg)
-		if err != nil || savedAlerts == nil || len(*savedAlerts) == 0 {
-			log.Fatalf("ERROR: No saved alert suggestions found for PR #%d", cfg.PRNumber)
+		savedAlerts, err := loadSavedAlertSuggestions(ctx, provider, cfg)
+		if err != nil || len(savedAlerts) == 0 {
+			failRun(cfg, rep, "No saved alert suggestions found for PR #%d", cfg.PRNumber)
 		}
-		createAllAlerts(*savedAlerts, cfg)
+		createAllAlerts(ctx, provider, savedAlerts, cfg, rep)
+		writeReport(cfg, rep)
 		return
 	}
 
@@ -97,69 +108,84 @@ func runAlerts() {
 		}
 	}
 
-	// Prepare prompt for Claude
-	log.Println("INFO: Building alerts analysis prompt...")
-	prompt := llm.BuildAlertsPrompt(prDetails, prdContent)
+	llmClient, err := llm.NewClient(cfg)
+	if err != nil {
+		failRun(cfg, rep, "Failed to initialize LLM client: %v", err)
+	}
 
-	// Call Claude API
-	log.Println("INFO: Calling Claude API for alerts analysis...")
-	suggestions, err, responseText := llm.CallClaudeAPIForAlerts(prompt, cfg)
+	// Call LLM
+	log.Printf("INFO: Calling %s for alerts analysis...", llmClient.Name())
+	analysis, err := llm.AnalyzeAlerts(ctx, llmClient, prDetails, prdContent, cfg)
+	if analysis != nil {
+		logAttempts(analysis.Attempts)
+		rep.AddAttempts(analysis.Attempts)
+	}
 	if err != nil {
-		log.Fatalf("ERROR: Failed to call Claude API: %v", err)
+		failRun(cfg, rep, "Failed to call LLM: %v", err)
 	}
 
-	if suggestions == nil || len(*suggestions) == 0 {
+	rep.Summary = analysis.Summary
+	rep.Alerts = analysis.Suggestions
+
+	if len(analysis.Suggestions) == 0 {
 		log.Println("INFO: No alert suggestions found")
-		log.Println("DEBUG: Claude API response:")
-		log.Println(responseText)
+		log.Println("DEBUG: LLM response:")
+		log.Println(analysis.ResponseText)
+		writeReport(cfg, rep)
 		return
 	}
 
-	log.Printf("INFO: Found %d alert suggestions!", len(*suggestions))
+	log.Printf("INFO: Found %d alert suggestions!", len(analysis.Suggestions))
+	suggestions := &analysis.Suggestions
 
 	// Log the suggestions
 	for i, suggestion := range *suggestions {
 		log.Printf("INFO: Alert %d: %s (%s) - Priority: %s", i+1, suggestion.Name, suggestion.Type, suggestion.Priority)
 	}
 
+	if err := store.Save(cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindAlerts, *suggestions); err != nil {
+		log.Printf("WARN: Could not save alert suggestions: %v", err)
+	}
+
 	// Create PR comments if suggestions exist
 	log.Println("INFO: Creating PR comments for alert suggestions...")
-	err = github.CreateAlertsPRComments(*suggestions, prDetails, cfg)
+	err = provider.SyncComments(ctx, vcs.AlertComments(*suggestions), vcs.MarkerAlert)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to create Alerts PR comments: %v", err)
+		failRun(cfg, rep, "Failed to create Alerts PR comments: %v", err)
 	}
 	log.Println("INFO: Successfully created PR comments")
 
 	// Interactive prompt if not in CI/CD mode
 	if !skipAlertPromptFlag {
 		reader := bufio.NewReader(os.Stdin)
-		fmt.Println("\nDo you want to create these alerts now? (y/n)")
+		fmt.Fprintln(os.Stderr, "\nDo you want to create these alerts now? (y/n)")
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(strings.ToLower(input))
 
 		if input == "y" || input == "yes" {
-			createAllAlerts(*suggestions, cfg)
+			createAllAlerts(ctx, provider, *suggestions, cfg, rep)
 		} else {
 			log.Println("INFO: Alert creation skipped. You can create them later from the PR comments.")
 		}
 	}
 
 	log.Println("INFO: Alerts analysis complete")
+	writeReport(cfg, rep)
 }
 
 // createSpecificAlert attempts to load and create a specific alert by name
-func createSpecificAlert(cfg config.Config, name string, alertType string) {
+func createSpecificAlert(ctx context.Context, provider vcs.Provider, cfg config.Config, name string, alertType string, rep *report.Report) error {
 	// Try to load saved alert suggestions from storage
-	savedAlerts, err := loadSavedAlertSuggestions(cfg)
-	if err != nil || savedAlerts == nil || len(*savedAlerts) == 0 {
-		log.Fatalf("ERROR: No saved alert suggestions found for PR #%d", cfg.PRNumber)
+	savedAlerts, err := loadSavedAlertSuggestions(ctx, provider, cfg)
+	if err != nil || len(savedAlerts) == 0 {
+		return fmt.Errorf("no saved alert suggestions found for PR #%d", cfg.PRNumber)
 	}
 
 	// Find the matching alert
 	var targetAlert config.AlertSuggestion
 	found := false
 
-	for _, alert := range *savedAlerts {
+	for _, alert := range savedAlerts {
 		if alert.Name == name && (alertType == "" || alert.Type == alertType) {
 			targetAlert = alert
 			found = true
@@ -168,58 +194,94 @@ func createSpecificAlert(cfg config.Config, name string, alertType string) {
 	}
 
 	if !found {
-		log.Fatalf("ERROR: No alert found with name: %s", name)
+		return fmt.Errorf("no alert found with name: %s", name)
 	}
 
 	log.Printf("INFO: Creating %s alert: %s", targetAlert.Type, targetAlert.Name)
-	err = createAlert(targetAlert, cfg)
+	err = createAlert(ctx, provider, targetAlert, cfg)
+	rep.AddResource("alert", targetAlert.Type, targetAlert.Name, err)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to create alert: %v", err)
+		return err
 	}
 	log.Printf("INFO: Successfully created alert: %s", name)
+	return nil
 }
 
-// createAllAlerts creates all alerts in the provided suggestions
-func createAllAlerts(suggestions []config.AlertSuggestion, cfg config.Config) {
+// createAllAlerts creates all alerts in the provided suggestions and records them in the report.
+// In CI the Prometheus rule files are committed to the PR branch together in a single commit.
+func createAllAlerts(ctx context.Context, provider vcs.Provider, suggestions []config.AlertSuggestion, cfg config.Config, rep *report.Report) {
 	log.Println("INFO: Starting alert creation process...")
+	var ruleFiles []vcs.FileChange
+	var ruleAlerts []config.AlertSuggestion
 	for _, suggestion := range suggestions {
+		if cfg.RunningInCI && isPrometheusAlert(suggestion.Type) {
+			ruleFiles = append(ruleFiles, alerts.PrometheusRuleFile(suggestion, cfg))
+			ruleAlerts = append(ruleAlerts, suggestion)
+			continue
+		}
+
 		log.Printf("INFO: Creating %s alert: %s", suggestion.Type, suggestion.Name)
-		err := createAlert(suggestion, cfg)
+		err := createAlert(ctx, provider, suggestion, cfg)
+		rep.AddResource("alert", suggestion.Type, suggestion.Name, err)
 		if err != nil {
 			log.Printf("ERROR: Failed to create %s alert '%s': %v", suggestion.Type, suggestion.Name, err)
 		} else {
 			log.Printf("INFO: Successfully created %s alert: %s", suggestion.Type, suggestion.Name)
 		}
 	}
+
+	if len(ruleFiles) > 0 {
+		log.Printf("INFO: Committing %d Prometheus alert rules to the PR branch...", len(ruleFiles))
+		err := provider.CommitFiles(ctx, ruleFiles, fmt.Sprintf("Add %d TracePR Prometheus alert rules", len(ruleFiles)))
+		for _, suggestion := range ruleAlerts {
+			rep.AddResource("alert", suggestion.Type, suggestion.Name, err)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to commit Prometheus alert rules: %v", err)
+		} else {
+			log.Printf("INFO: Successfully committed %d Prometheus alert rules", len(ruleFiles))
+		}
+	}
 	log.Println("INFO: Alert creation process completed")
 }
 
-// createAlert creates an alert based on its type
-func createAlert(suggestion config.AlertSuggestion, cfg config.Config) error {
-	switch suggestion.Type {
-	case "prometheus", "metric":
-		return alerts.CreatePrometheusAlert(suggestion, cfg)
-	case "datadog":
+// isPrometheusAlert reports whether alerts of this type are Prometheus rule files
+func isPrometheusAlert(alertType string) bool {
+	return slices.Contains(utils.PrometheusAlertTypes, alertType)
+}
+
+// createAlert creates an alert based on its type, one of utils.AlertTypes
+func createAlert(ctx context.Context, provider vcs.Provider, suggestion config.AlertSuggestion, cfg config.Config) error {
+	switch {
+	case isPrometheusAlert(suggestion.Type):
+		return alerts.CreatePrometheusAlert(ctx, provider, suggestion, cfg)
+	case suggestion.Type == "datadog":
 		return alerts.CreateDatadogAlert(suggestion, cfg)
 	default:
 		return fmt.Errorf("unsupported alert type: %s", suggestion.Type)
 	}
 }
 
-// loadSavedAlertSuggestions loads previously generated alert suggestions
-func loadSavedAlertSuggestions(cfg config.Config) (*[]config.AlertSuggestion, error) {
-	// Implement storage/retrieval of alert suggestions
-	// This could be from a file, database, or fetched from GitHub PR comments
-
-	// For now, let's assume we're getting this from the PR
-	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
+// loadSavedAlertSuggestions loads the alert suggestions generated for the PR by an earlier run from the
+// local store, falling back to the ones posted as PR comments when the store doesn't have them
+func loadSavedAlertSuggestions(ctx context.Context, provider vcs.Provider, cfg config.Config) ([]config.AlertSuggestion, error) {
+	suggestions, found, err := store.Load[config.AlertSuggestion](cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindAlerts)
+	if err != nil {
+		log.Printf("WARN: Could not read suggestion store, falling back to PR comments: %v", err)
+	}
+	if found {
+		return suggestions, nil
+	}
 
-	// Implementation needed: Parse alert suggestions from PR comments
-	suggestions, err := github.GetAlertSuggestionsFromPR(githubClient, cfg)
+	comments, err := provider.ListComments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading saved alert suggestions: %v", err)
+	}
+	// Only TracePR's own comments are trusted, since anyone can post a marker and its data
+	self, err := provider.Identity(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error loading saved alert suggestions: %v", err)
 	}
 
-	return suggestions, nil
+	return vcs.ParseAlertSuggestions(comments, self), nil
 }
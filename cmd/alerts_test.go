@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"tracepr/config"
+	"tracepr/report"
+	"tracepr/vcs"
+	"context"
+	"testing"
+)
+
+// fakeProvider records the commits made through it
+type fakeProvider struct {
+	commits [][]vcs.FileChange
+	ctxs    []context.Context
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) FetchChangeDetails(ctx context.Context) (config.Config, map[string]interface{}, error) {
+	return config.Config{}, nil, nil
+}
+
+func (p *fakeProvider) PostReview(ctx context.Context, suggestions []config.FileSuggestion, prDetails map[string]interface{}, summary string) error {
+	return nil
+}
+
+func (p *fakeProvider) SyncComments(ctx context.Context, desired []vcs.MarkedComment, staleKinds ...string) error {
+	return nil
+}
+
+func (p *fakeProvider) ListComments(ctx context.Context) ([]vcs.Comment, error) { return nil, nil }
+
+func (p *fakeProvider) Identity(ctx context.Context) (string, error) { return "tracepr", nil }
+
+func (p *fakeProvider) CommitFiles(ctx context.Context, changes []vcs.FileChange, message string) error {
+	p.commits = append(p.commits, changes)
+	p.ctxs = append(p.ctxs, ctx)
+	return nil
+}
+
+type ctxKey struct{}
+
+func TestCreateAlertsCommitThroughProvider(t *testing.T) {
+	cfg := config.Config{RunningInCI: true, PrometheusConfigPath: "alerts/prometheus/rules"}
+	suggestions := []config.AlertSuggestion{
+		{Name: "High Error Rate", Type: "prometheus", Query: "rate(errors[5m]) > 0.1"},
+		{Name: "Slow Checkout", Type: "metric", Query: "histogram_quantile(0.99, rate(checkout_seconds_bucket[5m])) > 2"},
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+
+	t.Run("create all", func(t *testing.T) {
+		provider := &fakeProvider{}
+		rep := report.New("alerts", cfg)
+		createAllAlerts(ctx, provider, suggestions, cfg, rep)
+		if len(provider.commits) != 1 || len(provider.commits[0]) != 2 {
+			t.Fatalf("commits = %v, want both rules in one commit", provider.commits)
+		}
+		if len(rep.Resources) != 2 || !rep.Resources[0].Created || !rep.Resources[1].Created {
+			t.Errorf("resources = %+v", rep.Resources)
+		}
+	})
+
+	t.Run("create one", func(t *testing.T) {
+		provider := &fakeProvider{}
+		if err := createAlert(ctx, provider, suggestions[0], cfg); err != nil {
+			t.Fatalf("createAlert: %v", err)
+		}
+		if len(provider.commits) != 1 || provider.commits[0][0].Path != "alerts/prometheus/rules/high_error_rate.yml" {
+			t.Fatalf("commits = %v, want the rule committed through the provider", provider.commits)
+		}
+		if provider.ctxs[0].Value(ctxKey{}) != "caller" {
+			t.Error("rule was committed without the caller's context")
+		}
+	})
+}
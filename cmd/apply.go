@@ -0,0 +1,200 @@
+// cmd/apply.go
+package cmd
+
+import (
+	"tracepr/apply"
+	"tracepr/config"
+	"tracepr/git"
+	"tracepr/llm"
+	"tracepr/store"
+	"tracepr/vcs"
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyAllFlag      bool
+	applyNoFormatFlag bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply observability suggestions to the local working tree",
+	Long: `Applies the observability suggestions TracePR made for a pull request to the
+checked-out files, asking before each one. Suggestions are loaded from the
+suggestion store written by "tracepr check".
+
+Use --base/--head or --patch to analyze local changes and apply the suggestions
+for them instead. Suggestions whose lines changed since they were made are
+skipped, and changed files are run through their language's formatter.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runApply(applySource(cmd))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().String("base", "", "Analyze local changes since this git ref and apply the suggestions for them (e.g. main)")
+	applyCmd.Flags().String("head", "HEAD", "Git ref whose changes are analyzed with --base")
+	applyCmd.Flags().String("patch", "", "Analyze a unified diff file and apply the suggestions for it (- reads stdin)")
+	applyCmd.Flags().String("repo-path", ".", "Working tree the suggestions are applied to")
+	applyCmd.Flags().BoolVar(&applyAllFlag, "yes", false, "Apply every suggestion without asking")
+	applyCmd.Flags().BoolVar(&applyNoFormatFlag, "no-format", false, "Don't run formatters on the changed files")
+}
+
+// applySource reads the local changes from apply's flags. check binds the same config keys to
+// its own flags, so these are passed to the config directly rather than bound.
+func applySource(cmd *cobra.Command) config.LocalSource {
+	flags := cmd.Flags()
+	var source config.LocalSource
+	source.DiffBase, _ = flags.GetString("base")
+	source.DiffHead, _ = flags.GetString("head")
+	source.PatchFile, _ = flags.GetString("patch")
+	source.RepoPath, _ = flags.GetString("repo-path")
+	return source
+}
+
+func runApply(source config.LocalSource) {
+	log.Println("INFO: Starting to apply observability suggestions...")
+	cfg := config.LoadConfigWithSource(source)
+
+	suggestions, err := loadApplySuggestions(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	if len(suggestions) == 0 {
+		log.Println("INFO: No observability suggestions to apply")
+		return
+	}
+
+	changes := apply.Plan(cfg.RepoPath, suggestions)
+	accepted := chooseChanges(changes)
+	if len(accepted) == 0 {
+		log.Println("INFO: No suggestions applied")
+		return
+	}
+
+	paths, err := apply.Apply(cfg.RepoPath, accepted)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to apply suggestions: %v", err)
+	}
+	if !applyNoFormatFlag {
+		apply.Format(cfg.RepoPath, paths)
+	}
+	log.Printf("INFO: Applied %d of %d suggestions to %d files", len(accepted), len(changes), len(paths))
+}
+
+// loadApplySuggestions returns the suggestions saved for the configured PR, or analyzes the
+// local changes when --base or --patch is set
+func loadApplySuggestions(ctx context.Context, cfg config.Config) ([]config.FileSuggestion, error) {
+	if !cfg.LocalMode {
+		suggestions, found, err := store.Load[config.FileSuggestion](cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindSuggestions)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("no saved suggestions found for PR #%d in %s. Run `tracepr check` first, or use --base or --patch to analyze local changes", cfg.PRNumber, cfg.SuggestionStorePath)
+		}
+		return suggestions, nil
+	}
+
+	log.Println("INFO: Reading local changes...")
+	cfg, prDetails, err := git.FetchLocalDetails(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local changes: %v", err)
+	}
+
+	llmClient, err := llm.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize LLM client: %v", err)
+	}
+	log.Printf("INFO: Calling %s for observability analysis...", llmClient.Name())
+	analysis, err := llm.AnalyzeObservability(ctx, llmClient, prDetails, "", cfg)
+	if analysis != nil {
+		logAttempts(analysis.Attempts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to call LLM: %v", err)
+	}
+	vcs.AttachOriginals(analysis.Suggestions, prDetails)
+	return analysis.Suggestions, nil
+}
+
+// chooseChanges shows every applicable change and returns the ones accepted. Conflicting and
+// overlapping changes are skipped.
+func chooseChanges(changes []*apply.Change) []*apply.Change {
+	reader := bufio.NewReader(os.Stdin)
+	acceptAll := applyAllFlag
+	var accepted []*apply.Change
+
+	for i, change := range changes {
+		suggestion := change.Suggestion
+		fmt.Printf("\n[%d/%d] %s:%s (%s severity)\n", i+1, len(changes), suggestion.FileName, suggestion.LineNum, suggestion.Severity)
+		if change.Conflict != "" {
+			fmt.Printf("Skipped: %s\n", change.Conflict)
+			continue
+		}
+		if overlapping := overlappingChange(change, accepted); overlapping != nil {
+			fmt.Printf("Skipped: overlaps the accepted suggestion at %s\n", overlapping.Lines())
+			continue
+		}
+
+		if change.Relocated {
+			fmt.Printf("The code moved since the suggestion was made; it now replaces %s\n", change.Lines())
+		} else if !change.Verified {
+			fmt.Println("Warning: could not verify these are the lines the suggestion was made for")
+		}
+		fmt.Println(formatChange(change))
+
+		if acceptAll {
+			if change.Verified {
+				accepted = append(accepted, change)
+			} else {
+				fmt.Println("Skipped: unverified suggestions are only applied when confirmed")
+			}
+			continue
+		}
+
+		fmt.Print("Apply this suggestion? [y]es, [n]o, [a]ll remaining, [q]uit: ")
+		input, _ := reader.ReadString('\n')
+		switch strings.TrimSpace(strings.ToLower(input)) {
+		case "y", "yes":
+			accepted = append(accepted, change)
+		case "a", "all":
+			accepted = append(accepted, change)
+			acceptAll = true
+		case "q", "quit":
+			return accepted
+		}
+	}
+	return accepted
+}
+
+// overlappingChange returns the accepted change editing some of the same lines, if any
+func overlappingChange(change *apply.Change, accepted []*apply.Change) *apply.Change {
+	for _, other := range accepted {
+		if change.Overlaps(other) {
+			return other
+		}
+	}
+	return nil
+}
+
+// formatChange renders the lines a change replaces and their replacement as a diff
+func formatChange(change *apply.Change) string {
+	var b strings.Builder
+	for _, line := range change.Current {
+		b.WriteString("- " + line + "\n")
+	}
+	for _, line := range strings.Split(change.Suggestion.Content, "\n") {
+		b.WriteString("+ " + line + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
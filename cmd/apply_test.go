@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"tracepr/config"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApplySourceReadsOwnFlags(t *testing.T) {
+	t.Cleanup(func() {
+		applyCmd.Flags().Set("base", "")
+		applyCmd.Flags().Set("repo-path", ".")
+	})
+	if err := applyCmd.ParseFlags([]string{"--base", "main", "--repo-path", "/src/service"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := config.LocalSource{DiffBase: "main", DiffHead: "HEAD", RepoPath: "/src/service"}
+	if got := applySource(applyCmd); got != want {
+		t.Errorf("applySource() = %+v, want %+v", got, want)
+	}
+	// The shared config keys still belong to check's flags
+	if base := viper.GetString("diff_base"); base != "" {
+		t.Errorf("diff_base = %q, want apply's flags left unbound", base)
+	}
+}
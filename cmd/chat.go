@@ -50,16 +50,19 @@ func runChat() {
 
 	// Initialize context
 	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
+	provider, err := newProvider(ctx, cfg)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
 
 	// Fetch PR details including diff
-	cfg, prDetails, err := github.FetchPRDetails(githubClient, cfg)
+	cfg, prDetails, err := provider.FetchChangeDetails(ctx)
 	if err != nil {
 		log.Fatalf("ERROR: Failed to fetch PR details: %v", err)
 	}
 
 	// Generate repo embeddings for context
-	repoURL := fmt.Sprintf("https://github.com/%s/%s", cfg.RepoOwner, cfg.RepoName)
+	repoURL := github.RepoURL(cfg)
 	log.Printf("INFO: Generating code embeddings for repository: %s", repoURL)
 	embeddings, err := llm.GenerateCodeEmbeddingsFromGitHub(cfg, repoURL)
 	if err != nil {
@@ -68,6 +71,11 @@ func runChat() {
 		log.Printf("INFO: Successfully generated embeddings for %d files", len(embeddings))
 	}
 
+	llmClient, err := llm.NewClient(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to initialize LLM client: %v", err)
+	}
+
 	// Start chat loop
 	reader := bufio.NewReader(os.Stdin)
 	conversation := []string{}
@@ -125,11 +133,11 @@ func runChat() {
 			prompt = codeContext + "\n\n" + prompt
 		}
 
-		// Call Claude API with conversation
-		log.Printf("DEBUG: Sending prompt to Claude API with %d conversation entries", len(conversation))
-		response, err := llm.SimpleClaudeChat(prompt, cfg)
+		// Call LLM with conversation
+		log.Printf("DEBUG: Sending prompt to %s with %d conversation entries", llmClient.Name(), len(conversation))
+		response, err := llm.Chat(ctx, llmClient, prompt)
 		if err != nil {
-			log.Printf("ERROR: Failed to get response from Claude API: %v", err)
+			log.Printf("ERROR: Failed to get response from LLM: %v", err)
 			continue
 		}
 
@@ -3,21 +3,32 @@ package cmd
 
 import (
 	"tracepr/config"
+	"tracepr/git"
 	"tracepr/github"
 	"tracepr/llm"
+	"tracepr/report"
+	"tracepr/store"
+	"tracepr/utils"
+	"tracepr/vcs"
 	"context"
+	"fmt"
 	"os"
+	"strings"
 
 	"log"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Check a pull request for observability issues",
 	Long: `Analyzes a GitHub pull request using Claude AI to identify 
-potential observability issues and suggests improvements. `,
+potential observability issues and suggests improvements. 
+
+Use --base/--head or --patch to check local changes before opening a PR;
+results are printed instead of posted as comments.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runCheck()
 	},
@@ -25,24 +36,77 @@ potential observability issues and suggests improvements. `,
 
 func init() {
 	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().String("base", "", "Check local changes since this git ref instead of a GitHub PR (e.g. main)")
+	checkCmd.Flags().String("head", "HEAD", "Git ref whose changes are checked with --base")
+	checkCmd.Flags().String("patch", "", "Check a unified diff file instead of a GitHub PR (- reads stdin)")
+	checkCmd.Flags().String("repo-path", ".", "Local git repository used with --base")
+
+	viper.BindPFlag("diff_base", checkCmd.Flags().Lookup("base"))
+	viper.BindPFlag("diff_head", checkCmd.Flags().Lookup("head"))
+	viper.BindPFlag("patch_file", checkCmd.Flags().Lookup("patch"))
+	viper.BindPFlag("repo_path", checkCmd.Flags().Lookup("repo-path"))
 }
 
 func runCheck() {
 	log.Println("INFO: Starting PR observability check...")
 	cfg := config.LoadConfig()
 
-	// Initialize GitHub client
-	log.Println("INFO: Initializing GitHub client...")
-	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
-
-	// Fetch PR details including diff
-	log.Printf("INFO: Fetching PR details for PR #%d...", cfg.PRNumber)
-	cfg, prDetails, err := github.FetchPRDetails(githubClient, cfg)
+	rep, err := checkChange(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to fetch PR details: %v", err)
+		failRun(cfg, rep, "%v", err)
+	}
+	writeReport(cfg, rep)
+}
+
+// checkChange analyzes the configured pull request (or local changes) and posts the review.
+// It is shared by the check command and the webhook server. The returned report covers as much
+// of the run as completed, even when it fails.
+func checkChange(ctx context.Context, cfg config.Config) (rep *report.Report, err error) {
+	rep = report.New("check", cfg)
+	var provider vcs.Provider
+	var prDetails map[string]interface{}
+	var checkRun *github.CheckRun
+	defer func() {
+		// A started check run must not stay in progress forever
+		if checkRun != nil && err != nil {
+			if failErr := checkRun.Fail(ctx, err); failErr != nil {
+				log.Printf("WARN: Could not complete check run: %v", failErr)
+			}
+		}
+	}()
+	if cfg.LocalMode {
+		log.Println("INFO: Reading local changes...")
+		cfg, prDetails, err = git.FetchLocalDetails(cfg)
+		if err != nil {
+			return rep, fmt.Errorf("failed to read local changes: %v", err)
+		}
+		if cfg.CheckRun {
+			log.Println("WARN: Check runs are not published for local changes")
+		}
+	} else {
+		provider, err = newProvider(ctx, cfg)
+		if err != nil {
+			return rep, err
+		}
+
+		// Fetch PR details including diff
+		log.Printf("INFO: Fetching PR details for PR #%d...", cfg.PRNumber)
+		cfg, prDetails, err = provider.FetchChangeDetails(ctx)
+		if err != nil {
+			return rep, fmt.Errorf("failed to fetch PR details: %v", err)
+		}
+
+		if cfg.CheckRun {
+			var startErr error
+			checkRun, startErr = startCheckRun(ctx, provider, cfg)
+			if startErr != nil {
+				log.Printf("WARN: Could not start check run, continuing without it: %v", startErr)
+			}
+		}
 	}
 	log.Printf("INFO: Successfully fetched PR details for '%s'", prDetails["title"])
+	rep.SetChange(cfg, prDetails)
 
 	// Read PRD content if provided
 	prdContent := ""
@@ -57,28 +121,109 @@ func runCheck() {
 		}
 	}
 
-	// Prepare prompt for Claude
-	log.Println("INFO: Building observability analysis prompt...")
-	prompt := llm.BuildObservabilityPrompt(prDetails, prdContent)
+	llmClient, err := llm.NewClient(cfg)
+	if err != nil {
+		return rep, fmt.Errorf("failed to initialize LLM client: %v", err)
+	}
 
-	// Call Claude API
-	log.Println("INFO: Calling Claude API for observability analysis...")
-	suggestions, err, _, summary := llm.CallClaudeAPIForObservability(prompt, cfg)
+	// Call LLM
+	log.Printf("INFO: Calling %s for observability analysis...", llmClient.Name())
+	analysis, err := llm.AnalyzeObservability(ctx, llmClient, prDetails, prdContent, cfg)
+	if analysis != nil {
+		logAttempts(analysis.Attempts)
+		rep.AddAttempts(analysis.Attempts)
+	}
 	if err != nil {
-		log.Fatalf("ERROR: Failed to call Claude API: %v", err)
+		return rep, fmt.Errorf("failed to call LLM: %v", err)
+	}
+	vcs.AttachOriginals(analysis.Suggestions, prDetails)
+	rep.Verdict = analysis.Verdict
+	rep.Summary = analysis.Summary
+	rep.Suggestions = analysis.Suggestions
+
+	// Local results are only printed, see writeReport
+	if cfg.LocalMode {
+		return rep, nil
+	}
+
+	// Keep the suggestions for `tracepr apply`
+	if err := store.Save(cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindSuggestions, analysis.Suggestions); err != nil {
+		log.Printf("WARN: Could not save observability suggestions: %v", err)
 	}
 
-	if suggestions == nil {
+	if analysis.Verdict == utils.VerdictApprove {
+		log.Println("INFO: No observability gaps found, posting approval summary...")
+		// Goes through the review path so suggestions from earlier runs are marked as outdated
+		err := provider.PostReview(ctx, nil, prDetails, vcs.BuildApproveSummary(analysis.Summary))
+		if err != nil {
+			return rep, fmt.Errorf("failed to post approval summary: %v", err)
+		}
+		log.Println("INFO: Successfully posted approval summary")
+	} else if len(analysis.Suggestions) == 0 {
 		log.Println("INFO: No observability suggestions found")
 	} else {
-		log.Printf("INFO: Found %d observability suggestions!", len(*suggestions))
+		log.Printf("INFO: Found %d observability suggestions!", len(analysis.Suggestions))
 
 		// Create PR comments if suggestions exist
 		log.Println("INFO: Creating PR comments for observability suggestions...")
-		err := github.CreateObservabilityPRComments(*suggestions, prDetails, cfg, summary)
+		err := provider.PostReview(ctx, analysis.Suggestions, prDetails, analysis.Summary)
 		if err != nil {
-			log.Fatalf("ERROR: Failed to create observability PR comments: %v", err)
+			return rep, fmt.Errorf("failed to create observability PR comments: %v", err)
 		}
 		log.Println("INFO: Successfully created PR comments")
+
+		if cfg.FixPR {
+			openFixPR(ctx, provider, cfg, analysis.Suggestions, analysis.Summary)
+		}
+	}
+
+	if checkRun != nil {
+		conclusion := github.CheckConclusion(analysis.Suggestions, cfg.CheckFailOn, cfg.CheckNeutralOn)
+		title := fmt.Sprintf("%d observability suggestions", len(analysis.Suggestions))
+		if len(analysis.Suggestions) == 0 {
+			title = "No observability gaps found"
+		}
+		if err := checkRun.Complete(ctx, conclusion, title, checkSummary(analysis.Summary), analysis.Suggestions); err != nil {
+			return rep, fmt.Errorf("failed to complete check run: %v", err)
+		}
+		log.Printf("INFO: Check run completed as %s", conclusion)
+	}
+	return rep, nil
+}
+
+// startCheckRun starts the TracePR check run on the PR's head commit. Check runs are a GitHub feature.
+func startCheckRun(ctx context.Context, provider vcs.Provider, cfg config.Config) (*github.CheckRun, error) {
+	githubProvider, ok := provider.(*github.Provider)
+	if !ok {
+		return nil, fmt.Errorf("check runs are not supported by %s", provider.Name())
+	}
+	log.Println("INFO: Starting check run...")
+	return github.StartCheckRun(ctx, githubProvider.Client(), cfg)
+}
+
+// openFixPR opens (or updates) the stacked PR applying the suggestions. The review is already
+// posted, so a failure here is only logged.
+func openFixPR(ctx context.Context, provider vcs.Provider, cfg config.Config, suggestions []config.FileSuggestion, summary string) {
+	githubProvider, ok := provider.(*github.Provider)
+	if !ok {
+		log.Printf("WARN: Fix PRs are not supported by %s", provider.Name())
+		return
+	}
+	log.Println("INFO: Opening fix PR with the suggestions applied...")
+	fixPR, err := github.OpenFixPR(ctx, githubProvider.Client(), cfg, suggestions, summary)
+	if err != nil {
+		log.Printf("WARN: Could not open fix PR: %v", err)
+		return
+	}
+	if fixPR != nil {
+		log.Printf("INFO: Fix PR #%d: %s", fixPR.GetNumber(), fixPR.GetHTMLURL())
+	}
+}
+
+// checkSummary is the check run summary, falling back to a generic one when the LLM gave none
+func checkSummary(summary string) string {
+	if strings.TrimSpace(summary) == "" {
+		return "TracePR analyzed the observability of this change."
 	}
+	return summary
 }
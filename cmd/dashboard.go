@@ -4,8 +4,10 @@ package cmd
 import (
 	"tracepr/config"
 	"tracepr/dashboard"
-	"tracepr/github"
 	"tracepr/llm"
+	"tracepr/report"
+	"tracepr/store"
+	"tracepr/vcs"
 	"bufio"
 	"context"
 	"fmt"
@@ -50,35 +52,43 @@ func runDashboard() {
 
 	cfg := config.LoadConfig()
 	log.Println("Config loaded successfully")
+	rep := report.New("dashboard", cfg)
 
-	// Initialize GitHub client
 	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
-	log.Println("GitHub client initialized")
+	provider, err := newProvider(ctx, cfg)
+	if err != nil {
+		failRun(cfg, rep, "Error initializing %s client: %v", cfg.SCMProvider, err)
+	}
+	log.Printf("%s client initialized", provider.Name())
 
 	// Fetch PR details including diff
 	log.Println("Fetching PR details...")
-	cfg, prDetails, err := github.FetchPRDetails(githubClient, cfg)
+	cfg, prDetails, err := provider.FetchChangeDetails(ctx)
 	if err != nil {
-		log.Fatalf("Error fetching PR details: %v", err)
+		failRun(cfg, rep, "Error fetching PR details: %v", err)
 	}
 	log.Printf("Successfully fetched PR details for PR #%d", prDetails["number"])
+	rep.SetChange(cfg, prDetails)
 
 	// Check for specific dashboard creation first
 	if createFlag && dashboardName != "" {
 		log.Printf("Creating specific dashboard: %s", dashboardName)
-		createSpecificDashboard(cfg, dashboardName, dashboardType)
+		if err := createSpecificDashboard(ctx, provider, cfg, dashboardName, dashboardType, rep); err != nil {
+			failRun(cfg, rep, "Error creating dashboard: %v", err)
+		}
+		writeReport(cfg, rep)
 		return
 	}
 
 	if createAllFlag {
 		log.Println("Creating all suggested dashboards...")
 		// First load saved suggestions, similar to createSpecificDashboard
-		savedSuggestions, err := loadSavedDashboardSuggestions(cfg)
-		if err != nil || savedSuggestions == nil || len(*savedSuggestions) == 0 {
-			log.Fatalf("No saved dashboard suggestions found for PR #%d", cfg.PRNumber)
+		savedSuggestions, err := loadSavedDashboardSuggestions(ctx, provider, cfg)
+		if err != nil || len(savedSuggestions) == 0 {
+			failRun(cfg, rep, "No saved dashboard suggestions found for PR #%d", cfg.PRNumber)
 		}
-		createAllDashboards(*savedSuggestions, cfg)
+		createAllDashboards(ctx, provider, savedSuggestions, cfg, rep)
+		writeReport(cfg, rep)
 		return
 	}
 	// Read PRD content if provided
@@ -94,69 +104,83 @@ func runDashboard() {
 		}
 	}
 
-	// Prepare prompt for Claude
-	log.Println("Building dashboard prompt...")
-	prompt := llm.BuildDashboardPrompt(prDetails, prdContent)
-	log.Println("Dashboard prompt built successfully")
-
-	// Call Claude API
-	log.Println("Calling Claude API for dashboard suggestions...")
-	suggestions, err, _, summary := llm.CallClaudeAPIForDashboards(prompt, cfg)
-	log.Printf("Received summary from Claude: %s", summary)
+	llmClient, err := llm.NewClient(cfg)
 	if err != nil {
-		log.Fatalf("Error calling Claude API: %v", err)
+		failRun(cfg, rep, "Error initializing LLM client: %v", err)
 	}
 
-	if suggestions == nil || len(*suggestions) == 0 {
-		log.Println("No dashboard suggestions were generated by Claude")
+	// Call LLM
+	log.Printf("Calling %s for dashboard suggestions...", llmClient.Name())
+	analysis, err := llm.AnalyzeDashboards(ctx, llmClient, prDetails, prdContent, cfg)
+	if analysis != nil {
+		logAttempts(analysis.Attempts)
+		rep.AddAttempts(analysis.Attempts)
+	}
+	if err != nil {
+		failRun(cfg, rep, "Error calling LLM: %v", err)
+	}
+	summary := analysis.Summary
+	log.Printf("Received summary from LLM: %s", summary)
+	rep.Summary = summary
+	rep.Dashboards = analysis.Suggestions
+
+	if len(analysis.Suggestions) == 0 {
+		log.Println("No dashboard suggestions were generated by the LLM")
+		writeReport(cfg, rep)
 		return
 	}
 
-	log.Printf("Successfully received %d dashboard suggestions from Claude", len(*suggestions))
+	log.Printf("Successfully received %d dashboard suggestions from the LLM", len(analysis.Suggestions))
+	suggestions := &analysis.Suggestions
 
 	// Log the suggestions
 	for i, suggestion := range *suggestions {
 		log.Printf("Dashboard suggestion %d: %s (%s) - Priority: %s", i+1, suggestion.Name, suggestion.Type, suggestion.Priority)
 	}
 
+	if err := store.Save(cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindDashboards, *suggestions); err != nil {
+		log.Printf("Warning: Could not save dashboard suggestions: %v", err)
+	}
+
 	// Create PR comments if suggestions exist
 	log.Println("Creating PR comments with dashboard suggestions...")
-	err = github.CreateDashboardPRComments(*suggestions, prDetails, cfg, summary)
+	err = provider.SyncComments(ctx, vcs.DashboardComments(*suggestions, prDetails, summary), vcs.MarkerDashboard)
 	if err != nil {
-		log.Fatalf("Error creating Dashboard PR comments: %v", err)
+		failRun(cfg, rep, "Error creating Dashboard PR comments: %v", err)
 	}
 	log.Println("Successfully created PR comments")
 
 	// Interactive prompt if not in CI/CD mode
 	if !skipPromptFlag {
 		reader := bufio.NewReader(os.Stdin)
-		fmt.Println("\nDo you want to create these dashboards now? (y/n)")
+		fmt.Fprintln(os.Stderr, "\nDo you want to create these dashboards now? (y/n)")
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(strings.ToLower(input))
 
 		if input == "y" || input == "yes" {
-			createAllDashboards(*suggestions, cfg)
+			createAllDashboards(ctx, provider, *suggestions, cfg, rep)
 		} else {
 			log.Println("Dashboard creation skipped. You can create them later from the PR comments.")
 		}
 	}
 
 	log.Println("Dashboard generation process completed")
+	writeReport(cfg, rep)
 }
 
 // createSpecificDashboard attempts to load and create a specific dashboard by name
-func createSpecificDashboard(cfg config.Config, name string, dashboardType string) {
+func createSpecificDashboard(ctx context.Context, provider vcs.Provider, cfg config.Config, name string, dashboardType string, rep *report.Report) error {
 	// Try to load saved suggestions from storage
-	savedSuggestions, err := loadSavedDashboardSuggestions(cfg)
-	if err != nil || savedSuggestions == nil || len(*savedSuggestions) == 0 {
-		log.Fatalf("No saved dashboard suggestions found for PR #%d", cfg.PRNumber)
+	savedSuggestions, err := loadSavedDashboardSuggestions(ctx, provider, cfg)
+	if err != nil || len(savedSuggestions) == 0 {
+		return fmt.Errorf("no saved dashboard suggestions found for PR #%d", cfg.PRNumber)
 	}
 
 	// Find the matching dashboard
 	var targetSuggestion config.DashboardSuggestion
 	found := false
 
-	for _, suggestion := range *savedSuggestions {
+	for _, suggestion := range savedSuggestions {
 		if suggestion.Name == name && (dashboardType == "" || suggestion.Type == dashboardType) {
 			targetSuggestion = suggestion
 			found = true
@@ -165,34 +189,69 @@ func createSpecificDashboard(cfg config.Config, name string, dashboardType strin
 	}
 
 	if !found {
-		log.Fatalf("No dashboard found with name: %s", name)
+		return fmt.Errorf("no dashboard found with name: %s", name)
 	}
 
 	log.Printf("Creating %s dashboard: %s", targetSuggestion.Type, targetSuggestion.Name)
-	err = createDashboard(targetSuggestion, cfg)
+	err = createDashboard(ctx, provider, targetSuggestion, cfg)
+	rep.AddResource("dashboard", targetSuggestion.Type, targetSuggestion.Name, err)
 	if err != nil {
-		log.Fatalf("Error creating dashboard: %v", err)
+		return err
 	}
 	log.Printf("Successfully created dashboard: %s", name)
+	return nil
 }
 
-// createAllDashboards creates all dashboards in the provided suggestions
-func createAllDashboards(suggestions []config.DashboardSuggestion, cfg config.Config) {
+// createAllDashboards creates all dashboards in the provided suggestions and records them in the report.
+// In CI with --dashboards-as-code the dashboard files are committed to the PR branch together in a single commit.
+func createAllDashboards(ctx context.Context, provider vcs.Provider, suggestions []config.DashboardSuggestion, cfg config.Config, rep *report.Report) {
 	log.Println("Starting dashboard creation process...")
+	var files []vcs.FileChange
+	var fileDashboards []config.DashboardSuggestion
 	for _, suggestion := range suggestions {
+		if cfg.RunningInCI && cfg.DashboardsAsCode && dashboard.SupportsFiles(suggestion.Type) {
+			file, err := dashboard.DashboardFile(suggestion, cfg)
+			if err != nil {
+				rep.AddResource("dashboard", suggestion.Type, suggestion.Name, err)
+				log.Printf("Error rendering %s dashboard '%s': %v", suggestion.Type, suggestion.Name, err)
+				continue
+			}
+			files = append(files, file)
+			fileDashboards = append(fileDashboards, suggestion)
+			continue
+		}
+
 		log.Printf("Creating %s dashboard: %s", suggestion.Type, suggestion.Name)
-		err := createDashboard(suggestion, cfg)
+		err := createDashboard(ctx, provider, suggestion, cfg)
+		rep.AddResource("dashboard", suggestion.Type, suggestion.Name, err)
 		if err != nil {
 			log.Printf("Error creating %s dashboard '%s': %v", suggestion.Type, suggestion.Name, err)
 		} else {
 			log.Printf("Successfully created %s dashboard: %s", suggestion.Type, suggestion.Name)
 		}
 	}
+
+	if len(files) > 0 {
+		log.Printf("Committing %d dashboard files to the PR branch...", len(files))
+		err := provider.CommitFiles(ctx, files, fmt.Sprintf("Add %d TracePR dashboards", len(files)))
+		for _, suggestion := range fileDashboards {
+			rep.AddResource("dashboard", suggestion.Type, suggestion.Name, err)
+		}
+		if err != nil {
+			log.Printf("Error committing dashboard files: %v", err)
+		} else {
+			log.Printf("Successfully committed %d dashboard files", len(files))
+		}
+	}
 	log.Println("Dashboard creation process completed")
 }
 
-// createDashboard creates a dashboard based on its type
-func createDashboard(suggestion config.DashboardSuggestion, cfg config.Config) error {
+// createDashboard creates a dashboard based on its type, or writes it as a file with --dashboards-as-code
+func createDashboard(ctx context.Context, provider vcs.Provider, suggestion config.DashboardSuggestion, cfg config.Config) error {
+	if cfg.DashboardsAsCode && dashboard.SupportsFiles(suggestion.Type) {
+		return dashboard.WriteDashboardFile(ctx, provider, suggestion, cfg)
+	}
+
 	switch suggestion.Type {
 	case "grafana":
 		return dashboard.CreateGrafanaDashboard(suggestion, cfg)
@@ -205,20 +264,26 @@ func createDashboard(suggestion config.DashboardSuggestion, cfg config.Config) e
 	}
 }
 
-// loadSavedDashboardSuggestions loads previously generated dashboard suggestions
-func loadSavedDashboardSuggestions(cfg config.Config) (*[]config.DashboardSuggestion, error) {
-	// Implement storage/retrieval of dashboard suggestions
-	// This could be from a file, database, or fetched from GitHub PR comments
-
-	// For now, let's assume we're getting this from the PR
-	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
+// loadSavedDashboardSuggestions loads the dashboard suggestions generated for the PR by an earlier run from the
+// local store, falling back to the ones posted as PR comments when the store doesn't have them
+func loadSavedDashboardSuggestions(ctx context.Context, provider vcs.Provider, cfg config.Config) ([]config.DashboardSuggestion, error) {
+	suggestions, found, err := store.Load[config.DashboardSuggestion](cfg.SuggestionStorePath, store.KeyFor(cfg), store.KindDashboards)
+	if err != nil {
+		log.Printf("Warning: Could not read suggestion store, falling back to PR comments: %v", err)
+	}
+	if found {
+		return suggestions, nil
+	}
 
-	// Implementation needed: Parse dashboard suggestions from PR comments
-	suggestions, err := github.GetDashboardSuggestionsFromPR(githubClient, cfg)
+	comments, err := provider.ListComments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading saved dashboard suggestions: %v", err)
+	}
+	// Only TracePR's own comments are trusted, since anyone can post a marker and its data
+	self, err := provider.Identity(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error loading saved dashboard suggestions: %v", err)
 	}
 
-	return suggestions, nil
+	return vcs.ParseDashboardSuggestions(comments, self), nil
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"tracepr/config"
+	"tracepr/report"
+	"context"
+	"testing"
+)
+
+func TestCreateDashboardsCommitThroughProvider(t *testing.T) {
+	cfg := config.Config{RunningInCI: true, DashboardsAsCode: true, DashboardsPath: "dashboards"}
+	suggestions := []config.DashboardSuggestion{
+		{Name: "Checkout", Type: "grafana", Queries: "[]", Panels: "[]", Alerts: "[]"},
+		{Name: "Payments", Type: "grafana", Queries: "[]", Panels: "[]", Alerts: "[]"},
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+
+	t.Run("create all", func(t *testing.T) {
+		provider := &fakeProvider{}
+		rep := report.New("dashboard", cfg)
+		createAllDashboards(ctx, provider, suggestions, cfg, rep)
+		if len(provider.commits) != 1 || len(provider.commits[0]) != 2 {
+			t.Fatalf("commits = %v, want both dashboards in one commit", provider.commits)
+		}
+		if len(rep.Resources) != 2 || !rep.Resources[0].Created || !rep.Resources[1].Created {
+			t.Errorf("resources = %+v", rep.Resources)
+		}
+	})
+
+	t.Run("create one", func(t *testing.T) {
+		provider := &fakeProvider{}
+		if err := createDashboard(ctx, provider, suggestions[0], cfg); err != nil {
+			t.Fatalf("createDashboard: %v", err)
+		}
+		if len(provider.commits) != 1 || provider.commits[0][0].Path != "dashboards/grafana/checkout.json" {
+			t.Fatalf("commits = %v, want the dashboard committed through the provider", provider.commits)
+		}
+		if provider.ctxs[0].Value(ctxKey{}) != "caller" {
+			t.Error("dashboard was committed without the caller's context")
+		}
+	})
+}
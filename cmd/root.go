@@ -2,6 +2,12 @@
 package cmd
 
 import (
+	"tracepr/config"
+	"tracepr/llm"
+	"tracepr/provider"
+	"tracepr/report"
+	"tracepr/vcs"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -13,6 +19,11 @@ import (
 var (
 	cfgFile       string
 	githubToken   string
+	githubBaseURL string
+	githubUpload  string
+	githubAppID   int64
+	githubInstall int64
+	githubAppKey  string
 	claudeAPIKey  string
 	repoOwner     string
 	repoName      string
@@ -22,6 +33,34 @@ var (
 	maxDiffSize   int
 	claudeModel   string
 	claudeBaseURL string
+	llmProvider   string
+	openAIAPIKey  string
+	openAIModel   string
+	openAIBaseURL string
+	ollamaModel   string
+	ollamaBaseURL string
+	maxRepairs    int
+	perPage       int
+	maxDiffTokens int
+	maxChunks     int
+	concurrency   int
+	scmProvider   string
+	gitlabToken   string
+	gitlabBaseURL string
+	giteaToken    string
+	giteaBaseURL  string
+	bbToken       string
+	bbUsername    string
+	bbBaseURL     string
+	botLogin      string
+	storePath     string
+	reportFile    string
+	checkRun      bool
+	checkFailOn   string
+	checkNeutral  string
+	fixPR         bool
+	dashAsCode    bool
+	dashPath      string
 )
 var asciiLogo = `
 
@@ -45,7 +84,7 @@ maintain code quality and standards.`,
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	fmt.Println(asciiLogo)
+	printBanner()
 	if err := rootCmd.Execute(); err != nil {
 		log.Println(err)
 		os.Exit(1)
@@ -57,28 +96,87 @@ func init() {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.tracepr.yaml)")
+	rootCmd.PersistentFlags().StringVar(&scmProvider, "scm-provider", "github", "Source control host of the reviewed change (github, gitlab, gitea, bitbucket)")
 	rootCmd.PersistentFlags().StringVar(&githubToken, "github-token", "", "GitHub API token")
+	rootCmd.PersistentFlags().StringVar(&githubBaseURL, "github-base-url", "", "GitHub Enterprise Server API URL (e.g. https://github.example.com/api/v3)")
+	rootCmd.PersistentFlags().Int64Var(&githubAppID, "github-app-id", 0, "Authenticate as this GitHub App instead of with --github-token")
+	rootCmd.PersistentFlags().Int64Var(&githubInstall, "github-app-installation-id", 0, "GitHub App installation ID (looked up from the repository by default)")
+	rootCmd.PersistentFlags().StringVar(&githubAppKey, "github-app-private-key-path", "", "Path to the GitHub App's PEM private key")
+	rootCmd.PersistentFlags().StringVar(&githubUpload, "github-upload-url", "", "GitHub Enterprise Server upload URL (defaults to the uploads endpoint of --github-base-url)")
+	rootCmd.PersistentFlags().StringVar(&gitlabToken, "gitlab-token", "", "GitLab API token")
+	rootCmd.PersistentFlags().StringVar(&gitlabBaseURL, "gitlab-base-url", "https://gitlab.com/api/v4", "GitLab API URL")
+	rootCmd.PersistentFlags().StringVar(&giteaToken, "gitea-token", "", "Gitea API token")
+	rootCmd.PersistentFlags().StringVar(&giteaBaseURL, "gitea-base-url", "", "Gitea API URL (e.g. https://gitea.example.com/api/v1)")
+	rootCmd.PersistentFlags().StringVar(&bbToken, "bitbucket-token", "", "Bitbucket access token or app password")
+	rootCmd.PersistentFlags().StringVar(&bbUsername, "bitbucket-username", "", "Bitbucket username when --bitbucket-token is an app password")
+	rootCmd.PersistentFlags().StringVar(&bbBaseURL, "bitbucket-base-url", "https://api.bitbucket.org/2.0", "Bitbucket API URL")
+	rootCmd.PersistentFlags().StringVar(&botLogin, "bot-login", "", "User TracePR posts as, whose comments it recognises on re-runs (looked up from the token by default; the account UUID on Bitbucket)")
 	rootCmd.PersistentFlags().StringVar(&claudeAPIKey, "claude-api-key", "", "Claude API key")
 	rootCmd.PersistentFlags().StringVar(&repoOwner, "repo-owner", "", "GitHub repository owner")
 	rootCmd.PersistentFlags().StringVar(&repoName, "repo-name", "", "GitHub repository name")
 	rootCmd.PersistentFlags().IntVar(&prNumber, "pr-number", 0, "GitHub PR number")
 	rootCmd.PersistentFlags().StringVar(&prdFilePath, "prd-file", "", "Path to PRD file")
-	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", "json", "Output format (json, markdown)")
-	rootCmd.PersistentFlags().IntVar(&maxDiffSize, "max-diff-size", 10000, "Maximum diff size to analyze")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", "", "Write a run report in this format (json, markdown, sarif)")
+	rootCmd.PersistentFlags().StringVar(&reportFile, "report-file", "", "Write the run report to this file instead of stdout")
+	rootCmd.PersistentFlags().IntVar(&maxDiffSize, "max-diff-size", 0, "Maximum diff size in bytes to analyze (0 for no byte limit)")
+	rootCmd.PersistentFlags().IntVar(&maxDiffTokens, "max-diff-tokens", 0, "Maximum diff tokens to send to the LLM (0 derives the budget from the model)")
 	rootCmd.PersistentFlags().StringVar(&claudeModel, "claude-model", "claude-3-7-sonnet-20250219", "Claude model to use")
 	rootCmd.PersistentFlags().StringVar(&claudeBaseURL, "claude-base-url", "https://api.anthropic.com/v1/messages", "Claude API base URL")
+	rootCmd.PersistentFlags().StringVar(&llmProvider, "llm-provider", "claude", "LLM provider to use (claude, openai, ollama)")
+	rootCmd.PersistentFlags().StringVar(&openAIAPIKey, "openai-api-key", "", "API key for the OpenAI-compatible provider")
+	rootCmd.PersistentFlags().StringVar(&openAIModel, "openai-model", "gpt-4o", "Model to use with the OpenAI-compatible provider")
+	rootCmd.PersistentFlags().StringVar(&openAIBaseURL, "openai-base-url", "https://api.openai.com/v1/chat/completions", "OpenAI-compatible chat completions URL (e.g. a llama.cpp server)")
+	rootCmd.PersistentFlags().StringVar(&ollamaModel, "ollama-model", "llama3.1", "Model to use with the Ollama provider")
+	rootCmd.PersistentFlags().StringVar(&ollamaBaseURL, "ollama-base-url", "http://localhost:11434/api/chat", "Ollama chat API URL")
+	rootCmd.PersistentFlags().IntVar(&maxChunks, "max-chunks", 4, "Maximum number of LLM calls a large PR's diff is split across")
+	rootCmd.PersistentFlags().IntVar(&concurrency, "llm-concurrency", 2, "Maximum number of diff chunks analyzed in parallel")
+	rootCmd.PersistentFlags().IntVar(&maxRepairs, "max-repair-attempts", 2, "Maximum follow-up requests asking the LLM to fix an unparseable response")
+	rootCmd.PersistentFlags().IntVar(&perPage, "github-per-page", 100, "Page size for GitHub list requests (max 100)")
+	rootCmd.PersistentFlags().BoolVar(&checkRun, "check-run", false, "Publish a GitHub check run with the suggestions as annotations")
+	rootCmd.PersistentFlags().StringVar(&checkFailOn, "fail-on", "high", "Lowest suggestion severity that fails the check run (high, medium, low, never)")
+	rootCmd.PersistentFlags().StringVar(&checkNeutral, "neutral-on", "low", "Lowest suggestion severity that makes the check run neutral (high, medium, low, never)")
+	rootCmd.PersistentFlags().BoolVar(&fixPR, "fix-pr", false, "Open a PR with the suggestions applied, stacked on the checked PR")
+	rootCmd.PersistentFlags().BoolVar(&dashAsCode, "dashboards-as-code", false, "Write Grafana and Datadog dashboards as JSON files (committed to the PR branch in CI) instead of creating them")
+	rootCmd.PersistentFlags().StringVar(&dashPath, "dashboards-path", "dashboards", "Repository directory for dashboard JSON files written with --dashboards-as-code")
+	rootCmd.PersistentFlags().StringVar(&storePath, "suggestion-store", ".tracepr/suggestions", "Directory where generated dashboard and alert suggestions are saved for --create and --create-all")
 
 	// Bind flags to viper
+	viper.BindPFlag("scm_provider", rootCmd.PersistentFlags().Lookup("scm-provider"))
 	viper.BindPFlag("github_token", rootCmd.PersistentFlags().Lookup("github-token"))
+	viper.BindPFlag("github_base_url", rootCmd.PersistentFlags().Lookup("github-base-url"))
+	viper.BindPFlag("github_upload_url", rootCmd.PersistentFlags().Lookup("github-upload-url"))
+	viper.BindPFlag("github_app_id", rootCmd.PersistentFlags().Lookup("github-app-id"))
+	viper.BindPFlag("github_app_installation_id", rootCmd.PersistentFlags().Lookup("github-app-installation-id"))
+	viper.BindPFlag("github_app_private_key_path", rootCmd.PersistentFlags().Lookup("github-app-private-key-path"))
+	viper.BindPFlag("gitlab_token", rootCmd.PersistentFlags().Lookup("gitlab-token"))
+	viper.BindPFlag("gitlab_base_url", rootCmd.PersistentFlags().Lookup("gitlab-base-url"))
+	viper.BindPFlag("gitea_token", rootCmd.PersistentFlags().Lookup("gitea-token"))
+	viper.BindPFlag("gitea_base_url", rootCmd.PersistentFlags().Lookup("gitea-base-url"))
+	viper.BindPFlag("bitbucket_token", rootCmd.PersistentFlags().Lookup("bitbucket-token"))
+	viper.BindPFlag("bitbucket_username", rootCmd.PersistentFlags().Lookup("bitbucket-username"))
+	viper.BindPFlag("bitbucket_base_url", rootCmd.PersistentFlags().Lookup("bitbucket-base-url"))
+	viper.BindPFlag("bot_login", rootCmd.PersistentFlags().Lookup("bot-login"))
 	viper.BindPFlag("claude_api_key", rootCmd.PersistentFlags().Lookup("claude-api-key"))
 	viper.BindPFlag("repo_owner", rootCmd.PersistentFlags().Lookup("repo-owner"))
 	viper.BindPFlag("repo_name", rootCmd.PersistentFlags().Lookup("repo-name"))
 	viper.BindPFlag("pr_number", rootCmd.PersistentFlags().Lookup("pr-number"))
 	viper.BindPFlag("prd_file", rootCmd.PersistentFlags().Lookup("prd-file"))
 	viper.BindPFlag("output_format", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("report_file", rootCmd.PersistentFlags().Lookup("report-file"))
 	viper.BindPFlag("max_diff_size", rootCmd.PersistentFlags().Lookup("max-diff-size"))
+	viper.BindPFlag("max_diff_tokens", rootCmd.PersistentFlags().Lookup("max-diff-tokens"))
 	viper.BindPFlag("claude_model", rootCmd.PersistentFlags().Lookup("claude-model"))
 	viper.BindPFlag("claude_base_url", rootCmd.PersistentFlags().Lookup("claude-base-url"))
+	viper.BindPFlag("llm_provider", rootCmd.PersistentFlags().Lookup("llm-provider"))
+	viper.BindPFlag("openai_api_key", rootCmd.PersistentFlags().Lookup("openai-api-key"))
+	viper.BindPFlag("openai_model", rootCmd.PersistentFlags().Lookup("openai-model"))
+	viper.BindPFlag("openai_base_url", rootCmd.PersistentFlags().Lookup("openai-base-url"))
+	viper.BindPFlag("ollama_model", rootCmd.PersistentFlags().Lookup("ollama-model"))
+	viper.BindPFlag("ollama_base_url", rootCmd.PersistentFlags().Lookup("ollama-base-url"))
+	viper.BindPFlag("max_chunks", rootCmd.PersistentFlags().Lookup("max-chunks"))
+	viper.BindPFlag("llm_concurrency", rootCmd.PersistentFlags().Lookup("llm-concurrency"))
+	viper.BindPFlag("max_repair_attempts", rootCmd.PersistentFlags().Lookup("max-repair-attempts"))
+	viper.BindPFlag("github_per_page", rootCmd.PersistentFlags().Lookup("github-per-page"))
 	viper.BindPFlag("amplitude_secret_key", rootCmd.PersistentFlags().Lookup("amplitude_secret_key"))
 	viper.BindPFlag("amplitude_api_key", rootCmd.PersistentFlags().Lookup("amplitude_api_key"))
 	viper.BindPFlag("grafana_service_account_token", rootCmd.PersistentFlags().Lookup("grafana_service_account_token"))
@@ -91,18 +189,56 @@ func init() {
 	viper.BindPFlag("prometheus_config_path", rootCmd.PersistentFlags().Lookup("prometheus_config_path"))
 	viper.BindPFlag("pr_branch", rootCmd.PersistentFlags().Lookup("pr_branch"))
 	viper.BindPFlag("running_in_ci", rootCmd.PersistentFlags().Lookup("running_in_ci"))
+	viper.BindPFlag("suggestion_store_path", rootCmd.PersistentFlags().Lookup("suggestion-store"))
+	viper.BindPFlag("check_run", rootCmd.PersistentFlags().Lookup("check-run"))
+	viper.BindPFlag("check_fail_on", rootCmd.PersistentFlags().Lookup("fail-on"))
+	viper.BindPFlag("check_neutral_on", rootCmd.PersistentFlags().Lookup("neutral-on"))
+	viper.BindPFlag("fix_pr", rootCmd.PersistentFlags().Lookup("fix-pr"))
+	viper.BindPFlag("dashboards_as_code", rootCmd.PersistentFlags().Lookup("dashboards-as-code"))
+	viper.BindPFlag("dashboards_path", rootCmd.PersistentFlags().Lookup("dashboards-path"))
 
 	// Bind env variables
+	viper.BindEnv("scm_provider", "SCM_PROVIDER")
 	viper.BindEnv("github_token", "GITHUB_TOKEN")
+	viper.BindEnv("github_base_url", "GITHUB_BASE_URL")
+	viper.BindEnv("github_upload_url", "GITHUB_UPLOAD_URL")
+	viper.BindEnv("github_app_id", "GITHUB_APP_ID")
+	viper.BindEnv("github_app_installation_id", "GITHUB_APP_INSTALLATION_ID")
+	viper.BindEnv("github_app_private_key", "GITHUB_APP_PRIVATE_KEY")
+	viper.BindEnv("github_app_private_key_path", "GITHUB_APP_PRIVATE_KEY_PATH")
+	viper.BindEnv("gitlab_token", "GITLAB_TOKEN")
+	viper.BindEnv("gitlab_base_url", "GITLAB_BASE_URL")
+	viper.BindEnv("gitea_token", "GITEA_TOKEN")
+	viper.BindEnv("gitea_base_url", "GITEA_BASE_URL")
+	viper.BindEnv("bitbucket_token", "BITBUCKET_TOKEN")
+	viper.BindEnv("bitbucket_username", "BITBUCKET_USERNAME")
+	viper.BindEnv("bitbucket_base_url", "BITBUCKET_BASE_URL")
 	viper.BindEnv("claude_api_key", "CLAUDE_API_KEY")
 	viper.BindEnv("repo_owner", "REPO_OWNER")
 	viper.BindEnv("repo_name", "REPO_NAME")
 	viper.BindEnv("pr_number", "PR_NUMBER")
 	viper.BindEnv("prd_file", "PRD_FILE")
 	viper.BindEnv("output_format", "OUTPUT_FORMAT")
+	viper.BindEnv("report_file", "REPORT_FILE")
 	viper.BindEnv("max_diff_size", "MAX_DIFF_SIZE")
+	viper.BindEnv("max_diff_tokens", "MAX_DIFF_TOKENS")
 	viper.BindEnv("claude_model", "CLAUDE_MODEL")
 	viper.BindEnv("claude_base_url", "CLAUDE_BASE_URL")
+	viper.BindEnv("llm_provider", "LLM_PROVIDER")
+	viper.BindEnv("openai_api_key", "OPENAI_API_KEY")
+	viper.BindEnv("openai_model", "OPENAI_MODEL")
+	viper.BindEnv("openai_base_url", "OPENAI_BASE_URL")
+	viper.BindEnv("ollama_model", "OLLAMA_MODEL")
+	viper.BindEnv("ollama_base_url", "OLLAMA_BASE_URL")
+	viper.BindEnv("max_chunks", "MAX_CHUNKS")
+	viper.BindEnv("llm_concurrency", "LLM_CONCURRENCY")
+	viper.BindEnv("max_repair_attempts", "MAX_REPAIR_ATTEMPTS")
+	viper.BindEnv("github_per_page", "GITHUB_PER_PAGE")
+	viper.BindEnv("webhook_secret", "GITHUB_WEBHOOK_SECRET")
+	viper.BindEnv("command_associations", "COMMAND_ASSOCIATIONS")
+	viper.BindEnv("jobs_token", "JOBS_TOKEN")
+	viper.BindEnv("bot_login", "BOT_LOGIN")
+	viper.BindEnv("github_actions", "GITHUB_ACTIONS")
 	viper.BindEnv("amplitude_secret_key", "AMPLITUDE_SECRET_KEY")
 	viper.BindEnv("amplitude_api_key", "AMPLITUDE_API_KEY")
 	viper.BindEnv("grafana_service_account_token", "GRAFANA_SERVICE_ACCOUNT_TOKEN")
@@ -115,6 +251,13 @@ func init() {
 	viper.BindEnv("prometheus_config_path", "PROMETHEUS_CONFIG_PATH")
 	viper.BindEnv("pr_branch", "PR_BRANCH")
 	viper.BindEnv("running_in_ci", "RUNNING_IN_CI")
+	viper.BindEnv("suggestion_store_path", "SUGGESTION_STORE_PATH")
+	viper.BindEnv("check_run", "CHECK_RUN")
+	viper.BindEnv("check_fail_on", "CHECK_FAIL_ON")
+	viper.BindEnv("check_neutral_on", "CHECK_NEUTRAL_ON")
+	viper.BindEnv("fix_pr", "FIX_PR")
+	viper.BindEnv("dashboards_as_code", "DASHBOARDS_AS_CODE")
+	viper.BindEnv("dashboards_path", "DASHBOARDS_PATH")
 }
 
 // initConfig reads in config file and ENV variables if set
@@ -142,3 +285,56 @@ func initConfig() {
 		log.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// logAttempts prints the outcome of every LLM call made while repairing a response
+func logAttempts(attempts []llm.Attempt) {
+	for _, attempt := range attempts {
+		label := fmt.Sprintf("%d", attempt.Number)
+		if attempt.Chunk > 0 {
+			label = fmt.Sprintf("%d (chunk %d)", attempt.Number, attempt.Chunk)
+		}
+		if attempt.Error == "" {
+			log.Printf("INFO: LLM attempt %s succeeded with %d suggestions", label, attempt.Accepted)
+		} else {
+			log.Printf("INFO: LLM attempt %s accepted %d suggestions, error: %s", label, attempt.Accepted, attempt.Error)
+		}
+	}
+}
+
+// printBanner prints the logo to stderr, since stdout carries reports and the MCP protocol
+func printBanner() {
+	fmt.Fprintln(os.Stderr, asciiLogo)
+}
+
+// writeReport writes the run report in the --output format, if one was requested. Local checks
+// have nowhere to post their results, so they print a Markdown report by default.
+func writeReport(cfg config.Config, rep *report.Report) {
+	format := cfg.OutputFormat
+	if format == "" && cfg.LocalMode {
+		format = "markdown"
+	}
+	if format == "" {
+		return
+	}
+	if err := rep.Write(format, cfg.ReportFile); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+// failRun records the error in the run report, writes it and exits
+func failRun(cfg config.Config, rep *report.Report, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	rep.Error = message
+	writeReport(cfg, rep)
+	log.Fatalf("ERROR: %s", message)
+}
+
+// newProvider initializes the client for the source control host the change lives on
+func newProvider(ctx context.Context, cfg config.Config) (vcs.Provider, error) {
+	log.Printf("INFO: Initializing %s client...", cfg.SCMProvider)
+	p, err := provider.New(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize source control provider: %v", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"tracepr/config"
+	"tracepr/report"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+// captureStdout returns everything fn writes to stdout
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestReportIsOnlyOutputOnStdout(t *testing.T) {
+	for _, format := range []string{"json", "sarif"} {
+		t.Run(format, func(t *testing.T) {
+			cfg := config.Config{LocalMode: true, OutputFormat: format}
+			rep := report.New("check", cfg)
+			rep.Suggestions = []config.FileSuggestion{{FileName: "main.go", LineNum: "12", Content: "log.Println(err)", Severity: "high"}}
+
+			stdout := captureStdout(t, func() {
+				printBanner()
+				writeReport(cfg, rep)
+			})
+
+			var parsed map[string]interface{}
+			if err := json.Unmarshal(stdout, &parsed); err != nil {
+				t.Fatalf("stdout is not valid %s: %v\n%s", format, err, stdout)
+			}
+			if format == "sarif" && parsed["version"] != "2.1.0" {
+				t.Errorf("SARIF version = %v, want 2.1.0", parsed["version"])
+			}
+		})
+	}
+}
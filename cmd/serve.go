@@ -0,0 +1,385 @@
+// cmd/serve.go
+package cmd
+
+import (
+	"tracepr/config"
+	"tracepr/github"
+	"tracepr/queue"
+	"tracepr/report"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gh "github.com/google/go-github/v53/github"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	serveAddr       string
+	queuePath       string
+	workers         int
+	repoConcurrency int
+	maxAttempts     int
+	retryBackoff    time.Duration
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a GitHub webhook server that checks PRs and handles /tracepr commands",
+	Long: `Receives GitHub webhooks on /webhook. Pull requests are checked when they are
+opened or updated, and these commands in PR comments create suggested dashboards and alerts:
+
+  /tracepr dashboard create <name>
+  /tracepr alert create <name>
+
+Configure the webhook with content type application/json, the same secret as
+--webhook-secret, and the "Pull requests" and "Issue comments" events.
+
+Work is queued in a local bbolt file so it survives restarts. Failed jobs are retried with
+backoff, a push to a PR supersedes checks of its earlier commits, and GET /jobs and
+/jobs/{id} report the state of the queue to requests with the --jobs-token bearer token.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runServe()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8080", "Address to listen on")
+	serveCmd.Flags().String("webhook-secret", "", "Secret configured on the GitHub webhook")
+	serveCmd.Flags().StringVar(&queuePath, "queue-path", "tracepr-queue.db", "File that stores the job queue")
+	serveCmd.Flags().IntVar(&workers, "workers", 4, "Number of jobs to run at once")
+	serveCmd.Flags().IntVar(&repoConcurrency, "repo-concurrency", 1, "Number of jobs to run at once per repository")
+	serveCmd.Flags().IntVar(&maxAttempts, "max-attempts", 3, "Attempts before a failing job is given up")
+	serveCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", 30*time.Second, "Delay before retrying a failed job, doubled on every further attempt")
+	serveCmd.Flags().String("jobs-token", "", "Bearer token for GET /jobs and /jobs/{id}, which are disabled without one")
+	serveCmd.Flags().StringSlice("command-associations", config.DefaultCommandAssociations, "Repository associations (e.g. OWNER, MEMBER, COLLABORATOR, CONTRIBUTOR) whose comments may run /tracepr commands")
+
+	viper.BindPFlag("webhook_secret", serveCmd.Flags().Lookup("webhook-secret"))
+	viper.BindPFlag("command_associations", serveCmd.Flags().Lookup("command-associations"))
+	viper.BindPFlag("jobs_token", serveCmd.Flags().Lookup("jobs-token"))
+}
+
+// slashCommandPattern matches "/tracepr dashboard create <name>" and "/tracepr alert create <name>"
+var slashCommandPattern = regexp.MustCompile(`(?m)^/tracepr\s+(dashboard|alerts?)\s+create\s+(.+?)\s*$`)
+
+// webhookJob is the work requested by a single webhook event
+type webhookJob struct {
+	Kind           string `json:"kind"` // "check", "dashboard" or "alert"
+	Owner          string `json:"owner"`
+	Repo           string `json:"repo"`
+	PRNumber       int    `json:"pr_number"`
+	HeadSHA        string `json:"head_sha,omitempty"`
+	Name           string `json:"name,omitempty"`       // dashboard or alert to create
+	CommentID      int64  `json:"comment_id,omitempty"` // comment that issued the slash command
+	InstallationID int64  `json:"installation_id,omitempty"`
+}
+
+func (j webhookJob) String() string {
+	if j.Name != "" {
+		return fmt.Sprintf("%s %q for %s/%s#%d", j.Kind, j.Name, j.Owner, j.Repo, j.PRNumber)
+	}
+	return fmt.Sprintf("%s for %s/%s#%d", j.Kind, j.Owner, j.Repo, j.PRNumber)
+}
+
+// queueJob wraps the job for the queue. Checks of a PR share a key so a new head SHA supersedes
+// them, while every slash command is its own job. Redelivered webhooks map to the same revision.
+func (j webhookJob) queueJob() (queue.Job, error) {
+	payload, err := json.Marshal(j)
+	if err != nil {
+		return queue.Job{}, err
+	}
+
+	repo := j.Owner + "/" + j.Repo
+	job := queue.Job{
+		Key:      fmt.Sprintf("%s#%d:%s", repo, j.PRNumber, j.Kind),
+		Revision: j.HeadSHA,
+		Group:    repo,
+		Payload:  payload,
+	}
+	if j.Kind != "check" {
+		job.Revision = strconv.FormatInt(j.CommentID, 10)
+		job.Key += ":" + job.Revision
+	}
+	return job, nil
+}
+
+func runServe() {
+	viper.Set("server_mode", true)
+	cfg := config.LoadConfig()
+	if cfg.SCMProvider != "" && cfg.SCMProvider != "github" {
+		log.Fatalf("ERROR: serve only receives GitHub webhooks, not %s", cfg.SCMProvider)
+	}
+
+	jobs, err := queue.Open(queuePath, queue.Options{
+		Workers:     workers,
+		PerGroup:    repoConcurrency,
+		MaxAttempts: maxAttempts,
+		Backoff:     retryBackoff,
+	})
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	defer jobs.Close()
+
+	go jobs.Run(context.Background(), func(ctx context.Context, job queue.Job) error {
+		var wj webhookJob
+		if err := json.Unmarshal(job.Payload, &wj); err != nil {
+			return queue.Permanent(fmt.Errorf("error decoding job payload: %v", err))
+		}
+		err := runWebhookJob(ctx, cfg, wj, job.LastAttempt())
+		if err != nil {
+			log.Printf("ERROR: %s failed: %v", wj, err)
+		}
+		return err
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", &webhookHandler{cfg: cfg, dispatch: func(job webhookJob) error {
+		queued, err := job.queueJob()
+		if err != nil {
+			return err
+		}
+		queued, added, err := jobs.Enqueue(queued)
+		if err != nil {
+			return err
+		}
+		if !added {
+			log.Printf("INFO: %s is already queued as job %d (%s)", job, queued.ID, queued.Status)
+		}
+		return nil
+	}})
+	// The queue names repositories and PRs and holds their errors, so it is never served openly
+	if cfg.JobsToken != "" {
+		status := &jobStatusHandler{jobs: jobs, token: cfg.JobsToken}
+		mux.Handle("GET /jobs", status)
+		mux.Handle("GET /jobs/{id}", status)
+	} else {
+		log.Printf("INFO: Job status endpoints are disabled, set --jobs-token to enable them")
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	log.Printf("INFO: Listening for GitHub webhooks on %s/webhook", serveAddr)
+	log.Fatal(http.ListenAndServe(serveAddr, mux))
+}
+
+// webhookHandler verifies GitHub webhook deliveries and dispatches the work they request
+type webhookHandler struct {
+	cfg      config.Config
+	dispatch func(job webhookJob) error
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := gh.ValidatePayload(r, []byte(h.cfg.WebhookSecret))
+	if err != nil {
+		log.Printf("WARN: Rejecting webhook delivery %s: %v", gh.DeliveryID(r), err)
+		http.Error(w, "invalid webhook signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := gh.ParseWebHook(gh.WebHookType(r), payload)
+	if err != nil {
+		// Events we didn't subscribe to are acknowledged so GitHub doesn't report failures
+		log.Printf("INFO: Ignoring %s webhook: %v", gh.WebHookType(r), err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	job, ok := jobForEvent(event, h.cfg.CommandAssociations)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("INFO: Received webhook %s, scheduling %s", gh.DeliveryID(r), job)
+	if err := h.dispatch(job); err != nil {
+		// GitHub shows failed deliveries so they can be redelivered
+		log.Printf("ERROR: Could not schedule %s: %v", job, err)
+		http.Error(w, "could not schedule job", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// jobStatusHandler reports queued jobs as JSON to requests bearing token: GET /jobs lists the
+// most recent ones, optionally filtered with ?status=, and GET /jobs/{id} returns a single job
+type jobStatusHandler struct {
+	jobs  *queue.Queue
+	token string
+}
+
+func (h *jobStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var result interface{}
+	if idParam := r.PathValue("id"); idParam != "" {
+		id, err := strconv.ParseUint(idParam, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid job id", http.StatusBadRequest)
+			return
+		}
+		job, found, err := h.jobs.Get(id)
+		if err != nil {
+			log.Printf("ERROR: Could not load job %d: %v", id, err)
+			http.Error(w, "could not load job", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		result = job
+	} else {
+		limit := 100
+		if param := r.URL.Query().Get("limit"); param != "" {
+			if n, err := strconv.Atoi(param); err == nil && n > 0 {
+				limit = n
+			}
+		}
+		list, err := h.jobs.List(r.URL.Query().Get("status"), limit)
+		if err != nil {
+			log.Printf("ERROR: Could not list jobs: %v", err)
+			http.Error(w, "could not list jobs", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []queue.Job{}
+		}
+		result = list
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// jobForEvent returns the work a webhook event asks for, if any. Slash commands are only accepted
+// from commenters whose association with the repository is one of associations, since they create
+// resources with the server's credentials.
+func jobForEvent(event interface{}, associations []string) (webhookJob, bool) {
+	switch e := event.(type) {
+	case *gh.PullRequestEvent:
+		if e.GetAction() != "opened" && e.GetAction() != "synchronize" {
+			return webhookJob{}, false
+		}
+		return webhookJob{
+			Kind:           "check",
+			Owner:          e.GetRepo().GetOwner().GetLogin(),
+			Repo:           e.GetRepo().GetName(),
+			PRNumber:       e.GetPullRequest().GetNumber(),
+			HeadSHA:        e.GetPullRequest().GetHead().GetSHA(),
+			InstallationID: e.GetInstallation().GetID(),
+		}, true
+
+	case *gh.IssueCommentEvent:
+		// Bots include TracePR itself, whose comments explain the commands
+		if e.GetAction() != "created" || !e.GetIssue().IsPullRequest() || e.GetComment().GetUser().GetType() == "Bot" {
+			return webhookJob{}, false
+		}
+		kind, name, ok := parseSlashCommand(e.GetComment().GetBody())
+		if !ok {
+			return webhookJob{}, false
+		}
+		if association := e.GetComment().GetAuthorAssociation(); !slices.Contains(associations, association) {
+			log.Printf("INFO: Ignoring /tracepr command from %s, whose association %s is not allowed to run commands", e.GetComment().GetUser().GetLogin(), association)
+			return webhookJob{}, false
+		}
+		return webhookJob{
+			Kind:           kind,
+			Owner:          e.GetRepo().GetOwner().GetLogin(),
+			Repo:           e.GetRepo().GetName(),
+			PRNumber:       e.GetIssue().GetNumber(),
+			Name:           name,
+			CommentID:      e.GetComment().GetID(),
+			InstallationID: e.GetInstallation().GetID(),
+		}, true
+	}
+	return webhookJob{}, false
+}
+
+// parseSlashCommand extracts the kind ("dashboard" or "alert") and name from a /tracepr comment
+func parseSlashCommand(body string) (kind, name string, ok bool) {
+	match := slashCommandPattern.FindStringSubmatch(body)
+	if match == nil {
+		return "", "", false
+	}
+	name = strings.Trim(match[2], "`\"'")
+	if name == "" {
+		return "", "", false
+	}
+	return strings.TrimSuffix(match[1], "s"), name, true
+}
+
+// runWebhookJob runs a job against the repository and PR it names. lastAttempt is false while a
+// failure would still be retried, so the command comment only gets a reaction once.
+func runWebhookJob(ctx context.Context, cfg config.Config, job webhookJob, lastAttempt bool) error {
+	cfg.RepoOwner = job.Owner
+	cfg.RepoName = job.Repo
+	cfg.PRNumber = job.PRNumber
+	if cfg.GithubAppID != 0 && job.InstallationID != 0 {
+		cfg.GithubAppInstallationID = job.InstallationID
+	}
+
+	log.Printf("INFO: Running %s", job)
+	if job.Kind == "check" {
+		// Results are posted to the PR, so the server has no use for the report
+		_, err := checkChange(ctx, cfg)
+		return err
+	}
+
+	provider, err := newProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	// Sets the PR branch alert rules are committed to and the head SHA suggestions are saved under
+	cfg, _, err = provider.FetchChangeDetails(ctx)
+	if err != nil {
+		return err
+	}
+	// The server has no checkout to write alert rules to, so they are committed to the PR branch
+	cfg.RunningInCI = true
+
+	switch job.Kind {
+	case "dashboard":
+		err = createSpecificDashboard(ctx, provider, cfg, job.Name, "", report.New("dashboard", cfg))
+	case "alert":
+		err = createSpecificAlert(ctx, provider, cfg, job.Name, "", report.New("alerts", cfg))
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	if err != nil && !lastAttempt {
+		return err
+	}
+
+	// React to the command so its author can see whether it worked
+	reaction := "rocket"
+	if err != nil {
+		reaction = "confused"
+	}
+	githubProvider, ok := provider.(*github.Provider)
+	if !ok {
+		log.Printf("WARN: Could not react to comment %d: %s is not a GitHub provider", job.CommentID, provider.Name())
+		return err
+	}
+	if reactErr := github.ReactToComment(ctx, githubProvider.Client(), cfg, job.CommentID, reaction); reactErr != nil {
+		log.Printf("WARN: Could not react to comment %d: %v", job.CommentID, reactErr)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"tracepr/config"
+	"tracepr/queue"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	gh "github.com/google/go-github/v53/github"
+)
+
+func commandEvent(body, association, userType string) *gh.IssueCommentEvent {
+	return &gh.IssueCommentEvent{
+		Action: gh.String("created"),
+		Issue: &gh.Issue{
+			Number:           gh.Int(7),
+			PullRequestLinks: &gh.PullRequestLinks{URL: gh.String("https://api.github.com/repos/o/r/pulls/7")},
+		},
+		Comment: &gh.IssueComment{
+			ID:                gh.Int64(42),
+			Body:              gh.String(body),
+			AuthorAssociation: gh.String(association),
+			User:              &gh.User{Login: gh.String("someone"), Type: gh.String(userType)},
+		},
+		Repo: &gh.Repository{Name: gh.String("r"), Owner: &gh.User{Login: gh.String("o")}},
+	}
+}
+
+func TestJobForEventSlashCommands(t *testing.T) {
+	tests := []struct {
+		name         string
+		event        *gh.IssueCommentEvent
+		associations []string
+		wantJob      bool
+	}{
+		{"owner", commandEvent("/tracepr dashboard create Service Metrics", "OWNER", "User"), config.DefaultCommandAssociations, true},
+		{"collaborator", commandEvent("/tracepr alert create High Error Rate", "COLLABORATOR", "User"), config.DefaultCommandAssociations, true},
+		{"outside contributor", commandEvent("/tracepr alert create High Error Rate", "CONTRIBUTOR", "User"), config.DefaultCommandAssociations, false},
+		{"anyone", commandEvent("/tracepr alert create High Error Rate", "NONE", "User"), config.DefaultCommandAssociations, false},
+		{"configured association", commandEvent("/tracepr alert create High Error Rate", "CONTRIBUTOR", "User"), []string{"CONTRIBUTOR"}, true},
+		{"bot", commandEvent("/tracepr alert create High Error Rate", "OWNER", "Bot"), config.DefaultCommandAssociations, false},
+		{"not a command", commandEvent("looks good", "OWNER", "User"), config.DefaultCommandAssociations, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, ok := jobForEvent(tt.event, tt.associations)
+			if ok != tt.wantJob {
+				t.Fatalf("jobForEvent returned a job: %v, want %v", ok, tt.wantJob)
+			}
+			if ok && (job.Owner != "o" || job.Repo != "r" || job.PRNumber != 7 || job.CommentID != 42) {
+				t.Errorf("unexpected job %+v", job)
+			}
+		})
+	}
+}
+
+func TestJobStatusRequiresToken(t *testing.T) {
+	jobs, err := queue.Open(filepath.Join(t.TempDir(), "jobs.db"), queue.Options{})
+	if err != nil {
+		t.Fatalf("queue.Open: %v", err)
+	}
+	t.Cleanup(func() { jobs.Close() })
+	queued, _, err := jobs.Enqueue(queue.Job{Key: "o/r#7:check", Revision: "head", Group: "o/r", Payload: []byte("{}")})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	status := &jobStatusHandler{jobs: jobs, token: "secret"}
+	mux.Handle("GET /jobs", status)
+	mux.Handle("GET /jobs/{id}", status)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{"list without a token", "/jobs", "", http.StatusUnauthorized},
+		{"job without a token", "/jobs/" + strconv.FormatUint(queued.ID, 10), "", http.StatusUnauthorized},
+		{"wrong token", "/jobs", "Bearer guess", http.StatusUnauthorized},
+		{"token without the bearer scheme", "/jobs", "secret", http.StatusUnauthorized},
+		{"list", "/jobs", "Bearer secret", http.StatusOK},
+		{"job", "/jobs/" + strconv.FormatUint(queued.ID, 10), "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK && strings.Contains(rec.Body.String(), "o/r") {
+				t.Errorf("unauthorized response leaked the queue: %s", rec.Body)
+			}
+		})
+	}
+}
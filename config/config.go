@@ -2,22 +2,71 @@ package config
 
 import (
 	"log"
+	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// LocalSource selects local changes to analyze instead of a PR: the changes on DiffHead since it
+// diverged from DiffBase in the repository at RepoPath, or a patch file
+type LocalSource struct {
+	DiffBase  string
+	DiffHead  string
+	PatchFile string
+	RepoPath  string
+}
+
 func LoadConfig() Config {
+	return LoadConfigWithSource(LocalSource{
+		DiffBase:  viper.GetString("diff_base"),
+		DiffHead:  viper.GetString("diff_head"),
+		PatchFile: viper.GetString("patch_file"),
+		RepoPath:  viper.GetString("repo_path"),
+	})
+}
+
+// LoadConfigWithSource loads the config like LoadConfig, taking the local changes from source
+// for commands that read them from their own flags
+func LoadConfigWithSource(source LocalSource) Config {
 	cfg := Config{
+		SCMProvider:                strings.ToLower(viper.GetString("scm_provider")),
 		GithubToken:                viper.GetString("github_token"),
+		GithubBaseURL:              viper.GetString("github_base_url"),
+		GithubAppID:                viper.GetInt64("github_app_id"),
+		GithubAppInstallationID:    viper.GetInt64("github_app_installation_id"),
+		GithubAppPrivateKey:        viper.GetString("github_app_private_key"),
+		GithubAppPrivateKeyPath:    viper.GetString("github_app_private_key_path"),
+		GithubUploadURL:            viper.GetString("github_upload_url"),
+		GitLabToken:                viper.GetString("gitlab_token"),
+		GitLabBaseURL:              viper.GetString("gitlab_base_url"),
+		GiteaToken:                 viper.GetString("gitea_token"),
+		GiteaBaseURL:               viper.GetString("gitea_base_url"),
+		BitbucketToken:             viper.GetString("bitbucket_token"),
+		BitbucketUsername:          viper.GetString("bitbucket_username"),
+		BitbucketBaseURL:           viper.GetString("bitbucket_base_url"),
 		ClaudeAPIKey:               viper.GetString("claude_api_key"),
 		RepoOwner:                  viper.GetString("repo_owner"),
 		RepoName:                   viper.GetString("repo_name"),
 		PRNumber:                   viper.GetInt("pr_number"),
 		PRDFilePath:                viper.GetString("prd_file"),
-		OutputFormat:               viper.GetString("output_format"),
+		OutputFormat:               strings.ToLower(viper.GetString("output_format")),
+		ReportFile:                 viper.GetString("report_file"),
 		MaxDiffSize:                viper.GetInt("max_diff_size"),
+		MaxDiffTokens:              viper.GetInt("max_diff_tokens"),
+		MaxChunks:                  viper.GetInt("max_chunks"),
+		LLMConcurrency:             viper.GetInt("llm_concurrency"),
 		ClaudeModel:                viper.GetString("claude_model"),
 		ClaudeBaseURL:              viper.GetString("claude_base_url"),
+		LLMProvider:                strings.ToLower(viper.GetString("llm_provider")),
+		OpenAIAPIKey:               viper.GetString("openai_api_key"),
+		OpenAIModel:                viper.GetString("openai_model"),
+		OpenAIBaseURL:              viper.GetString("openai_base_url"),
+		OllamaModel:                viper.GetString("ollama_model"),
+		OllamaBaseURL:              viper.GetString("ollama_base_url"),
+		MaxRepairAttempts:          viper.GetInt("max_repair_attempts"),
+		GithubPerPage:              viper.GetInt("github_per_page"),
 		AmplitudeSecretKey:         viper.GetString("amplitude_secret_key"),
 		AmplitudeAPIKey:            viper.GetString("amplitude_api_key"),
 		AmplitudeAPIToken:          viper.GetString("amplitude_api_token"),
@@ -29,17 +78,102 @@ func LoadConfig() Config {
 		DatadogAPIKey:              viper.GetString("datadog_api_key"),
 		DatadogAppKey:              viper.GetString("datadog_app_key"),
 		PRBranch:                   viper.GetString("pr_branch"),
+		DashboardsAsCode:           viper.GetBool("dashboards_as_code"),
+		DashboardsPath:             viper.GetString("dashboards_path"),
+		SuggestionStorePath:        viper.GetString("suggestion_store_path"),
+		CheckRun:                   viper.GetBool("check_run"),
+		CheckFailOn:                strings.ToLower(viper.GetString("check_fail_on")),
+		CheckNeutralOn:             strings.ToLower(viper.GetString("check_neutral_on")),
+		FixPR:                      viper.GetBool("fix_pr"),
 		RunningInCI:                viper.GetBool("running_in_ci"),
+		DiffBase:                   source.DiffBase,
+		DiffHead:                   source.DiffHead,
+		PatchFile:                  source.PatchFile,
+		RepoPath:                   source.RepoPath,
+		ServerMode:                 viper.GetBool("server_mode"),
+		WebhookSecret:              viper.GetString("webhook_secret"),
+		CommandAssociations:        splitList(viper.GetStringSlice("command_associations")),
+		JobsToken:                  viper.GetString("jobs_token"),
+		BotLogin:                   viper.GetString("bot_login"),
+		GithubActions:              viper.GetBool("github_actions"),
 	}
+	cfg.LocalMode = cfg.DiffBase != "" || cfg.PatchFile != ""
 
 	// Validate required parameters
-	if cfg.GithubToken == "" {
-		log.Fatal("GitHub token is required. Set GITHUB_TOKEN env var or use --github-token flag")
+	switch cfg.SCMProvider {
+	case "", "github":
+		if cfg.GithubAppID != 0 {
+			if cfg.GithubAppPrivateKey == "" && cfg.GithubAppPrivateKeyPath == "" {
+				log.Fatal("GitHub App private key is required. Set GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_PATH env var or use --github-app-private-key-path flag")
+			}
+		} else if cfg.GithubToken == "" && !cfg.LocalMode {
+			log.Fatal("GitHub token is required. Set GITHUB_TOKEN env var, use --github-token flag, or configure a GitHub App with --github-app-id")
+		}
+		for _, raw := range []string{cfg.GithubBaseURL, cfg.GithubUploadURL} {
+			if u, err := url.Parse(raw); raw != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+				log.Fatalf("Invalid GitHub URL %q. Use the full URL of your GitHub Enterprise Server, e.g. https://github.example.com/api/v3", raw)
+			}
+		}
+	case "gitlab":
+		if cfg.GitLabToken == "" && !cfg.LocalMode {
+			log.Fatal("GitLab token is required. Set GITLAB_TOKEN env var or use --gitlab-token flag")
+		}
+	case "gitea":
+		if cfg.GiteaToken == "" && !cfg.LocalMode {
+			log.Fatal("Gitea token is required. Set GITEA_TOKEN env var or use --gitea-token flag")
+		}
+		if cfg.GiteaBaseURL == "" && !cfg.LocalMode {
+			log.Fatal("Gitea API URL is required. Set GITEA_BASE_URL env var or use --gitea-base-url flag")
+		}
+	case "bitbucket":
+		if cfg.BitbucketToken == "" && !cfg.LocalMode {
+			log.Fatal("Bitbucket token is required. Set BITBUCKET_TOKEN env var or use --bitbucket-token flag")
+		}
+	default:
+		log.Fatalf("Unsupported source control provider %q. Use github, gitlab, gitea or bitbucket", cfg.SCMProvider)
+	}
+	switch cfg.LLMProvider {
+	case "", "claude", "anthropic":
+		if cfg.ClaudeAPIKey == "" {
+			log.Fatal("Claude API key is required. Set CLAUDE_API_KEY env var or use --claude-api-key flag")
+		}
+	case "openai":
+		if cfg.OpenAIAPIKey == "" && strings.Contains(cfg.OpenAIBaseURL, "api.openai.com") {
+			log.Fatal("OpenAI API key is required. Set OPENAI_API_KEY env var or use --openai-api-key flag")
+		}
+	case "ollama":
+		// Local models need no credentials
+	default:
+		log.Fatalf("Unsupported LLM provider %q. Use claude, openai or ollama", cfg.LLMProvider)
+	}
+	switch cfg.OutputFormat {
+	case "", "json", "markdown", "sarif":
+	default:
+		log.Fatalf("Unsupported output format %q. Use json, markdown or sarif", cfg.OutputFormat)
+	}
+	for _, threshold := range []string{cfg.CheckFailOn, cfg.CheckNeutralOn} {
+		switch threshold {
+		case "", "high", "medium", "low", "never":
+		default:
+			log.Fatalf("Unsupported check run threshold %q. Use high, medium, low or never", threshold)
+		}
+	}
+	if cfg.CheckRun && cfg.SCMProvider != "" && cfg.SCMProvider != "github" {
+		log.Fatal("Check runs are only supported with the github provider")
+	}
+	if cfg.FixPR && cfg.SCMProvider != "" && cfg.SCMProvider != "github" {
+		log.Fatal("Fix PRs are only supported with the github provider")
 	}
-	if cfg.ClaudeAPIKey == "" {
-		log.Fatal("Claude API key is required. Set CLAUDE_API_KEY env var or use --claude-api-key flag")
+	if cfg.ServerMode && cfg.WebhookSecret == "" {
+		log.Fatal("Webhook secret is required. Set GITHUB_WEBHOOK_SECRET env var or use --webhook-secret flag")
 	}
-	if cfg.RepoOwner == "" || cfg.RepoName == "" || cfg.PRNumber == 0 {
+	for _, association := range cfg.CommandAssociations {
+		if !slices.Contains(commentAssociations, association) {
+			log.Fatalf("Unsupported command association %q. Use %s", association, strings.Join(commentAssociations, ", "))
+		}
+	}
+	// The webhook server learns the repository and PR from each event
+	if (cfg.RepoOwner == "" || cfg.RepoName == "" || cfg.PRNumber == 0) && !cfg.LocalMode && !cfg.ServerMode {
 		log.Fatal("Repository details and PR number are required. Set REPO_OWNER, REPO_NAME, PR_NUMBER env vars or use flags")
 	}
 
@@ -48,16 +182,42 @@ func LoadConfig() Config {
 
 // Config holds configuration for the application
 type Config struct {
+	SCMProvider                string
 	GithubToken                string
+	GithubBaseURL              string // GitHub Enterprise Server API URL; empty for github.com
+	GithubUploadURL            string // defaults to the uploads endpoint of GithubBaseURL's host
+	GithubAppID                int64  // authenticate as this GitHub App instead of with GithubToken
+	GithubAppInstallationID    int64  // looked up from the repository when 0
+	GithubAppPrivateKey        string // PEM contents; takes precedence over GithubAppPrivateKeyPath
+	GithubAppPrivateKeyPath    string
+	GitLabToken                string
+	GitLabBaseURL              string
+	GiteaToken                 string
+	GiteaBaseURL               string
+	BitbucketToken             string
+	BitbucketUsername          string // set to authenticate the token as an app password
+	BitbucketBaseURL           string
 	ClaudeAPIKey               string
 	RepoOwner                  string
 	RepoName                   string
 	PRNumber                   int
 	PRDFilePath                string
-	OutputFormat               string
+	OutputFormat               string // format of the run report (json, markdown, sarif); empty writes none
+	ReportFile                 string // file the run report is written to instead of stdout
 	MaxDiffSize                int
+	MaxDiffTokens              int
+	MaxChunks                  int
+	LLMConcurrency             int
 	ClaudeModel                string
 	ClaudeBaseURL              string
+	LLMProvider                string
+	OpenAIAPIKey               string
+	OpenAIModel                string
+	OpenAIBaseURL              string
+	OllamaModel                string
+	OllamaBaseURL              string
+	MaxRepairAttempts          int
+	GithubPerPage              int
 	GrafanaServiceAccountToken string
 	GrafanaURL                 string
 	AmplitudeAPIKey            string
@@ -69,7 +229,47 @@ type Config struct {
 	DatadogAppKey              string
 	PrometheusConfigPath       string
 	PRBranch                   string
+	DashboardsAsCode           bool   // keep Grafana and Datadog dashboards as JSON files instead of creating them live
+	DashboardsPath             string // repository directory the dashboard files are written to
+	HeadSHA                    string // head commit of the PR, set when its details are fetched
+	SuggestionStorePath        string // directory of the local suggestion store
+	CheckRun                   bool   // publish a GitHub check run with annotations
+	CheckFailOn                string // lowest suggestion severity that fails the check run, or "never"
+	CheckNeutralOn             string // lowest suggestion severity that makes the check run neutral, or "never"
+	FixPR                      bool   // open a PR with the suggestions applied, stacked on the checked PR
 	RunningInCI                bool
+	DiffBase                   string
+	DiffHead                   string
+	PatchFile                  string
+	RepoPath                   string
+	LocalMode                  bool     // analyze a local diff instead of a GitHub PR
+	ServerMode                 bool     // serve webhooks; the repository and PR come from each event
+	WebhookSecret              string   // verifies the X-Hub-Signature-256 of GitHub webhooks
+	CommandAssociations        []string // author associations allowed to run /tracepr commands
+	JobsToken                  string   // bearer token required by serve's /jobs endpoints, which are off without it
+	BotLogin                   string   // user TracePR posts as, whose comments it recognises on re-runs; looked up from the token when empty
+	GithubActions              bool     // running in a GitHub Actions job, whose GITHUB_TOKEN posts as github-actions[bot]
+}
+
+// DefaultCommandAssociations are the commenters allowed to run /tracepr commands by default:
+// people with write access to the repository
+var DefaultCommandAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// commentAssociations are the author associations GitHub reports on comments
+var commentAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR", "CONTRIBUTOR", "FIRST_TIME_CONTRIBUTOR", "FIRST_TIMER", "MANNEQUIN", "NONE"}
+
+// splitList accepts both repeated values and comma-separated ones, as environment variables only
+// hold a single string
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.ToUpper(strings.TrimSpace(item)); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
 }
 
 // ObservabilityRecommendation represents the recommendations from Claude
@@ -117,7 +317,7 @@ type ClaudeRequest struct {
 	MaxTokens   int       `json:"max_tokens"`
 	Temperature float64   `json:"temperature"`
 	Messages    []Message `json:"messages"`
-	System      string    `json:"system"`
+	System      string    `json:"system,omitempty"`
 }
 
 type Message struct {
@@ -132,35 +332,48 @@ type ClaudeResponse struct {
 		Type string `json:"type"`
 		Text string `json:"text"`
 	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
 }
 
 // FileSuggestion represents a suggested change for a specific file and line
 type FileSuggestion struct {
-	FileName string
-	LineNum  string
-	Content  string
+	FileName     string `json:"file"`
+	LineNum      string `json:"line"`
+	StartLineNum string `json:"start_line,omitempty"` // Optional first line when the suggestion replaces a range
+	Content      string `json:"content"`
+	Severity     string `json:"severity"`           // high, medium or low
+	Original     string `json:"original,omitempty"` // the replaced lines as they were in the analyzed diff
+}
+
+// SkippedFile is a changed file left out of (or truncated in) the analysis to stay within the diff budget
+type SkippedFile struct {
+	FileName string `json:"file"`
+	Reason   string `json:"reason"`
 }
 
 // Example DashboardSuggestion struct for the config package
 type DashboardSuggestion struct {
-	Name     string
-	Type     string
-	Priority string
-	Queries  string
-	Panels   string
-	Alerts   string
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Priority string `json:"priority"`
+	Queries  string `json:"queries"`
+	Panels   string `json:"panels"`
+	Alerts   string `json:"alerts"`
 }
 
 type AlertSuggestion struct {
-	Name         string
-	Type         string
-	Priority     string
-	Query        string
-	Description  string
-	Threshold    string
-	Duration     string
-	Notification string
-	RunbookLink  string
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Priority     string `json:"priority"`
+	Query        string `json:"query"`
+	Description  string `json:"description"`
+	Threshold    string `json:"threshold"`
+	Duration     string `json:"duration"`
+	Notification string `json:"notification"`
+	RunbookLink  string `json:"runbook_link,omitempty"`
 }
 
 // CodeEmbedding represents an embedding for a code file
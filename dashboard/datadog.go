@@ -20,19 +20,52 @@ func CreateDatadogDashboard(suggestion config.DashboardSuggestion, cfg config.Co
 	configuration.AddDefaultHeader("DD-APPLICATION-KEY", cfg.DatadogAppKey)
 	apiClient := datadog.NewAPIClient(configuration)
 
+	dashboardRequest, err := BuildDatadogDashboard(suggestion)
+	if err != nil {
+		return err
+	}
+
+	// Debug the final request
+	requestBytes, _ := json.MarshalIndent(dashboardRequest, "", "  ")
+	log.Printf("Dashboard request: %s", string(requestBytes))
+
+	// Create the dashboard
+	ctx := context.Background()
+	dashboard, resp, err := apiClient.DashboardsApi.CreateDashboard(ctx, dashboardRequest)
+	if err != nil {
+		if resp != nil {
+			log.Printf("Failed to create Datadog dashboard, status: %v", resp.StatusCode)
+		} else {
+			log.Printf("Failed to create Datadog dashboard, response was nil")
+		}
+		if resp != nil && resp.Body != nil {
+			body := make([]byte, 1024)
+			n, _ := resp.Body.Read(body)
+			log.Printf("Error response: %s", string(body[:n]))
+		}
+		return fmt.Errorf("failed to create Datadog dashboard: %w", err)
+	}
+
+	log.Printf("Successfully created Datadog dashboard with ID: %s", dashboard.GetId())
+	return nil
+}
+
+// BuildDatadogDashboard builds the Datadog dashboard definition for a suggestion, as accepted by
+// the dashboards API and its JSON import
+func BuildDatadogDashboard(suggestion config.DashboardSuggestion) (datadog.Dashboard, error) {
 	// Parse the queries, panels, and alerts
 	var queries []map[string]interface{}
 	var panels []map[string]interface{}
 	var alerts []map[string]interface{}
 
 	if err := json.Unmarshal([]byte(suggestion.Queries), &queries); err != nil {
-		return fmt.Errorf("error parsing queries JSON: %v", err)
+		return datadog.Dashboard{}, fmt.Errorf("error parsing queries JSON: %v", err)
 	}
 	if err := json.Unmarshal([]byte(suggestion.Panels), &panels); err != nil {
-		return fmt.Errorf("error parsing panels JSON: %v", err)
+		return datadog.Dashboard{}, fmt.Errorf("error parsing panels JSON: %v", err)
 	}
 	if err := json.Unmarshal([]byte(suggestion.Alerts), &alerts); err != nil {
-		return fmt.Errorf("error parsing alerts JSON: %v", err)
+		return datadog.Dashboard{}, fmt.Errorf("error parsing alerts JSON: %v", err)
 	}
 
 	// Create widgets from panels
@@ -306,29 +339,7 @@ func CreateDatadogDashboard(suggestion config.DashboardSuggestion, cfg config.Co
 		NotifyList:        []string{},
 	}
 
-	// Debug the final request
-	requestBytes, _ := json.MarshalIndent(dashboardRequest, "", "  ")
-	log.Printf("Dashboard request: %s", string(requestBytes))
-
-	// Create the dashboard
-	ctx := context.Background()
-	dashboard, resp, err := apiClient.DashboardsApi.CreateDashboard(ctx, dashboardRequest)
-	if err != nil {
-		if resp != nil {
-			log.Printf("Failed to create Datadog dashboard, status: %v", resp.StatusCode)
-		} else {
-			log.Printf("Failed to create Datadog dashboard, response was nil")
-		}
-		if resp != nil && resp.Body != nil {
-			body := make([]byte, 1024)
-			n, _ := resp.Body.Read(body)
-			log.Printf("Error response: %s", string(body[:n]))
-		}
-		return fmt.Errorf("failed to create Datadog dashboard: %w", err)
-	}
-
-	log.Printf("Successfully created Datadog dashboard with ID: %s", dashboard.GetId())
-	return nil
+	return dashboardRequest, nil
 }
 
 // Helper function to safely convert interface{} to int64
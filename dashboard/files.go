@@ -0,0 +1,82 @@
+package dashboard
+
+import (
+	"tracepr/config"
+	"tracepr/utils"
+	"tracepr/vcs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SupportsFiles reports whether dashboards of this type can be kept as JSON files in the repository
+func SupportsFiles(dashboardType string) bool {
+	return dashboardType == "grafana" || dashboardType == "datadog"
+}
+
+// RenderDashboardFile renders a suggestion as the JSON file kept in the repository: the dashboard
+// model for Grafana file provisioning, or the dashboard definition for Datadog's JSON import
+func RenderDashboardFile(suggestion config.DashboardSuggestion) ([]byte, error) {
+	var dashboard interface{}
+	var err error
+	switch suggestion.Type {
+	case "grafana":
+		dashboard, err = BuildGrafanaDashboard(suggestion)
+	case "datadog":
+		dashboard, err = BuildDatadogDashboard(suggestion)
+	default:
+		return nil, fmt.Errorf("%s dashboards can't be written as files", suggestion.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(dashboard, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling dashboard JSON: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// DashboardFilePath is where a suggestion's dashboard file lives, relative to the repository root
+func DashboardFilePath(suggestion config.DashboardSuggestion, cfg config.Config) string {
+	return path.Join(filepath.ToSlash(cfg.DashboardsPath), suggestion.Type, utils.NormalizeFileName(suggestion.Name)+".json")
+}
+
+// DashboardFile is a suggestion's dashboard file as committed to the PR branch
+func DashboardFile(suggestion config.DashboardSuggestion, cfg config.Config) (vcs.FileChange, error) {
+	content, err := RenderDashboardFile(suggestion)
+	if err != nil {
+		return vcs.FileChange{}, err
+	}
+	return vcs.FileChange{Path: DashboardFilePath(suggestion, cfg), Content: string(content)}, nil
+}
+
+// WriteDashboardFile keeps a dashboard as code instead of creating it live. Like Prometheus rules,
+// it is committed to the PR branch through p when running in CI and written to the working tree
+// otherwise.
+func WriteDashboardFile(ctx context.Context, p vcs.Provider, suggestion config.DashboardSuggestion, cfg config.Config) error {
+	file, err := DashboardFile(suggestion, cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.RunningInCI {
+		return p.CommitFiles(ctx, []vcs.FileChange{file}, fmt.Sprintf("Add %s dashboard for %s", suggestion.Type, suggestion.Name))
+	}
+
+	localPath := filepath.FromSlash(file.Path)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dashboards directory: %w", err)
+	}
+	if err := os.WriteFile(localPath, []byte(file.Content), 0644); err != nil {
+		return fmt.Errorf("failed to write dashboard file: %w", err)
+	}
+
+	log.Printf("Created %s dashboard file at: %s", suggestion.Type, localPath)
+	return nil
+}
@@ -18,6 +18,56 @@ func CreateGrafanaDashboard(suggestion config.DashboardSuggestion, cfg config.Co
 		return fmt.Errorf("grafana service account token or URL not configured")
 	}
 
+	model, err := BuildGrafanaDashboard(suggestion)
+	if err != nil {
+		return err
+	}
+	dashboard := map[string]interface{}{
+		"dashboard": model,
+		"folderId":  0,
+		"overwrite": true,
+	}
+
+	// Send to Grafana API
+	log.Printf("Marshaling dashboard JSON")
+	dashboardJSON, err := json.Marshal(dashboard)
+	if err != nil {
+		log.Printf("Error marshaling dashboard JSON: %v", err)
+		return fmt.Errorf("error marshaling dashboard JSON: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/api/dashboards/db", cfg.GrafanaURL)
+	log.Printf("Sending request to Grafana API: %s", url)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(dashboardJSON))
+	if err != nil {
+		log.Printf("Error creating HTTP request: %v", err)
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.GrafanaServiceAccountToken))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error making request to Grafana API: %v", err)
+		return fmt.Errorf("error making request to Grafana API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		log.Printf("Grafana API error (%d): %s", resp.StatusCode, string(body))
+		return fmt.Errorf("grafana API error (%d): %s", resp.StatusCode, string(body))
+	}
+
+	log.Printf("Successfully created Grafana dashboard: %s", suggestion.Name)
+	return nil
+}
+
+// BuildGrafanaDashboard builds the Grafana dashboard model for a suggestion, as accepted by the
+// dashboard API and by file provisioning
+func BuildGrafanaDashboard(suggestion config.DashboardSuggestion) (map[string]interface{}, error) {
 	// Parse the queries and panels into proper JSON objects
 	var queries []map[string]interface{}
 	var panels []map[string]interface{}
@@ -26,34 +76,30 @@ func CreateGrafanaDashboard(suggestion config.DashboardSuggestion, cfg config.Co
 	log.Printf("Parsing dashboard queries, panels and alerts")
 	if err := json.Unmarshal([]byte(suggestion.Queries), &queries); err != nil {
 		log.Printf("Error parsing queries JSON: %v", err)
-		return fmt.Errorf("error parsing queries JSON: %v", err)
+		return nil, fmt.Errorf("error parsing queries JSON: %v", err)
 	}
 
 	if err := json.Unmarshal([]byte(suggestion.Panels), &panels); err != nil {
 		log.Printf("Error parsing panels JSON: %v", err)
-		return fmt.Errorf("error parsing panels JSON: %v", err)
+		return nil, fmt.Errorf("error parsing panels JSON: %v", err)
 	}
 
 	if err := json.Unmarshal([]byte(suggestion.Alerts), &alerts); err != nil {
 		log.Printf("Error parsing alerts JSON: %v", err)
-		return fmt.Errorf("error parsing alerts JSON: %v", err)
+		return nil, fmt.Errorf("error parsing alerts JSON: %v", err)
 	}
 
 	// Build Grafana dashboard JSON
 	log.Printf("Building Grafana dashboard JSON")
 	dashboard := map[string]interface{}{
-		"dashboard": map[string]interface{}{
-			"id":            nil,
-			"title":         suggestion.Name,
-			"tags":          []string{"auto-generated", "observability"},
-			"timezone":      "browser",
-			"schemaVersion": 16,
-			"version":       1,
-			"refresh":       "5s",
-			"panels":        panels,
-		},
-		"folderId":  0,
-		"overwrite": true,
+		"id":            nil,
+		"title":         suggestion.Name,
+		"tags":          []string{"auto-generated", "observability"},
+		"timezone":      "browser",
+		"schemaVersion": 16,
+		"version":       1,
+		"refresh":       "5s",
+		"panels":        panels,
 	}
 
 	// Add queries to panels
@@ -82,39 +128,5 @@ func CreateGrafanaDashboard(suggestion config.DashboardSuggestion, cfg config.Co
 		}
 	}
 
-	// Send to Grafana API
-	log.Printf("Marshaling dashboard JSON")
-	dashboardJSON, err := json.Marshal(dashboard)
-	if err != nil {
-		log.Printf("Error marshaling dashboard JSON: %v", err)
-		return fmt.Errorf("error marshaling dashboard JSON: %v", err)
-	}
-
-	url := fmt.Sprintf("%s/api/dashboards/db", cfg.GrafanaURL)
-	log.Printf("Sending request to Grafana API: %s", url)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(dashboardJSON))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return fmt.Errorf("error creating HTTP request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.GrafanaServiceAccountToken))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error making request to Grafana API: %v", err)
-		return fmt.Errorf("error making request to Grafana API: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Grafana API error (%d): %s", resp.StatusCode, string(body))
-		return fmt.Errorf("grafana API error (%d): %s", resp.StatusCode, string(body))
-	}
-
-	log.Printf("Successfully created Grafana dashboard: %s", suggestion.Name)
-	return nil
+	return dashboard, nil
 }
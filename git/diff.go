@@ -0,0 +1,142 @@
+package git
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkCountsPattern = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// ParseUnifiedDiff splits a multi-file unified diff (as produced by `git diff` or `diff -u`)
+// into the same per-file details FetchPRDetails builds from the GitHub API. Each patch starts
+// at its first "@@" hunk header, matching the patch field GitHub returns.
+func ParseUnifiedDiff(diff string) []map[string]interface{} {
+	var files []map[string]interface{}
+	var current map[string]interface{}
+	var patch []string
+	additions, deletions := 0, 0
+	inHunks := false
+	// Lines still to come in the current hunk; a plain `diff -u` file header can only start once both are used up
+	oldLeft, newLeft := 0, 0
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current["patch"] = strings.Join(patch, "\n")
+		current["additions"] = additions
+		current["deletions"] = deletions
+		if current["filename"].(string) != "" {
+			files = append(files, current)
+		}
+		current, patch, additions, deletions, inHunks = nil, nil, 0, 0, false
+		oldLeft, newLeft = 0, 0
+	}
+	start := func() {
+		flush()
+		current = map[string]interface{}{"filename": "", "status": "modified"}
+	}
+
+	gitFormat := strings.Contains(diff, "diff --git ")
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			start()
+			// Fall back to the header path for files without ---/+++ lines (e.g. binary or mode-only changes)
+			if idx := strings.LastIndex(line, " b/"); idx >= 0 {
+				current["filename"] = line[idx+3:]
+			}
+			continue
+		case !gitFormat && strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") && (current == nil || inHunks && oldLeft <= 0 && newLeft <= 0):
+			// A plain `diff -u` file header without a preceding "diff --git" line
+			start()
+		}
+		if current == nil {
+			continue
+		}
+
+		if inHunks {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				oldLeft, newLeft = hunkCounts(line)
+			case strings.HasPrefix(line, "+"):
+				additions++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				deletions++
+				oldLeft--
+			case strings.HasPrefix(line, " "), line == "":
+				oldLeft--
+				newLeft--
+			}
+			patch = append(patch, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunks = true
+			oldLeft, newLeft = hunkCounts(line)
+			patch = append(patch, line)
+		case strings.HasPrefix(line, "new file mode"):
+			current["status"] = "added"
+		case strings.HasPrefix(line, "deleted file mode"):
+			current["status"] = "removed"
+		case strings.HasPrefix(line, "rename from "):
+			current["status"] = "renamed"
+			current["previous_filename"] = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			current["filename"] = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "--- "):
+			if name := diffPath(line[4:]); name != "" {
+				current["filename"] = name
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if name := diffPath(line[4:]); name != "" {
+				current["filename"] = name
+			}
+		}
+	}
+	flush()
+
+	// A trailing newline in the input leaves an empty context line on the last hunk
+	for _, file := range files {
+		file["patch"] = strings.TrimRight(file["patch"].(string), "\n")
+	}
+	return files
+}
+
+// hunkCounts returns the old and new line counts of a "@@" hunk header, which default to 1 when
+// omitted. An unreadable header counts as empty.
+func hunkCounts(header string) (int, int) {
+	match := hunkCountsPattern.FindStringSubmatch(header)
+	if match == nil {
+		return 0, 0
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	return count(match[1]), count(match[2])
+}
+
+// diffPath strips the a/ or b/ prefix and any timestamp from a ---/+++ header path,
+// returning "" for /dev/null
+func diffPath(header string) string {
+	if tab := strings.Index(header, "\t"); tab >= 0 {
+		header = header[:tab]
+	}
+	header = strings.TrimSpace(header)
+	if header == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(header, "a/") || strings.HasPrefix(header, "b/") {
+		return header[2:]
+	}
+	return header
+}
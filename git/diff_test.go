@@ -0,0 +1,149 @@
+package git
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want []map[string]interface{}
+	}{
+		{
+			name: "multiple hunks",
+			diff: `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ a
++b
+ c
+@@ -10,2 +11,1 @@
+ x
+-y
+`,
+			want: []map[string]interface{}{
+				{"filename": "main.go", "status": "modified", "additions": 1, "deletions": 1,
+					"patch": "@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,2 +11,1 @@\n x\n-y"},
+			},
+		},
+		{
+			name: "rename",
+			diff: `diff --git a/old.go b/new.go
+similarity index 90%
+rename from old.go
+rename to new.go
+--- a/old.go
++++ b/new.go
+@@ -1 +1 @@
+-x
++y
+`,
+			want: []map[string]interface{}{
+				{"filename": "new.go", "previous_filename": "old.go", "status": "renamed", "additions": 1, "deletions": 1,
+					"patch": "@@ -1 +1 @@\n-x\n+y"},
+			},
+		},
+		{
+			name: "binary file",
+			diff: `diff --git a/logo.png b/logo.png
+new file mode 100644
+index 0000000..3333333
+Binary files /dev/null and b/logo.png differ
+diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1 +1 @@
+-a
++b
+`,
+			want: []map[string]interface{}{
+				{"filename": "logo.png", "status": "added", "additions": 0, "deletions": 0, "patch": ""},
+				{"filename": "main.go", "status": "modified", "additions": 1, "deletions": 1, "patch": "@@ -1 +1 @@\n-a\n+b"},
+			},
+		},
+		{
+			name: "no newline at end of file",
+			diff: `diff --git a/VERSION b/VERSION
+--- a/VERSION
++++ b/VERSION
+@@ -1 +1 @@
+-1.0
+\ No newline at end of file
++1.1
+\ No newline at end of file
+`,
+			want: []map[string]interface{}{
+				{"filename": "VERSION", "status": "modified", "additions": 1, "deletions": 1,
+					"patch": "@@ -1 +1 @@\n-1.0\n\\ No newline at end of file\n+1.1\n\\ No newline at end of file"},
+			},
+		},
+		{
+			name: "deleted file",
+			diff: `diff --git a/gone.go b/gone.go
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-a
+-b
+`,
+			want: []map[string]interface{}{
+				{"filename": "gone.go", "status": "removed", "additions": 0, "deletions": 2, "patch": "@@ -1,2 +0,0 @@\n-a\n-b"},
+			},
+		},
+		{
+			name: "plain diff -u with several files",
+			diff: `--- a/one.txt	2024-01-01 00:00:00
++++ b/one.txt	2024-01-02 00:00:00
+@@ -1 +1 @@
+-a
++b
+--- a/two.txt
++++ b/two.txt
+@@ -1 +1,2 @@
+ a
++b
+`,
+			want: []map[string]interface{}{
+				{"filename": "one.txt", "status": "modified", "additions": 1, "deletions": 1, "patch": "@@ -1 +1 @@\n-a\n+b"},
+				{"filename": "two.txt", "status": "modified", "additions": 1, "deletions": 0, "patch": "@@ -1 +1,2 @@\n a\n+b"},
+			},
+		},
+		{
+			// Removing "-- old" and adding "++ new" look like file headers, but the hunk isn't finished
+			name: "plain diff -u with header-like content",
+			diff: `--- a/schema.sql
++++ b/schema.sql
+@@ -1,3 +1,3 @@
+--- old
++++ new
+ select 1;
+-select 2;
++select 3;
+--- a/next.sql
++++ b/next.sql
+@@ -1 +1 @@
+-a
++b
+`,
+			want: []map[string]interface{}{
+				{"filename": "schema.sql", "status": "modified", "additions": 2, "deletions": 2,
+					"patch": "@@ -1,3 +1,3 @@\n--- old\n+++ new\n select 1;\n-select 2;\n+select 3;"},
+				{"filename": "next.sql", "status": "modified", "additions": 1, "deletions": 1, "patch": "@@ -1 +1 @@\n-a\n+b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseUnifiedDiff(tt.diff)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ParseUnifiedDiff() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package git
+
+import (
+	"tracepr/config"
+	"tracepr/llm"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FetchLocalDetails builds the same prDetails map as github.FetchPRDetails from a local
+// repository (the changes on cfg.DiffHead since it diverged from cfg.DiffBase) or from a
+// patch file, so a branch can be checked before a PR exists
+func FetchLocalDetails(cfg config.Config) (config.Config, map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	var diff string
+
+	if cfg.PatchFile != "" {
+		log.Printf("Reading patch from %s", cfg.PatchFile)
+		content, err := readPatch(cfg.PatchFile)
+		if err != nil {
+			log.Printf("Error reading patch: %v", err)
+			return cfg, nil, fmt.Errorf("error reading patch: %v", err)
+		}
+		diff = content
+
+		result["title"] = fmt.Sprintf("Local patch %s", cfg.PatchFile)
+		result["description"] = ""
+		result["author"] = runGitOrDefault(cfg.RepoPath, "", "config", "user.name")
+		result["created_at"] = time.Now().Format(time.RFC3339)
+		result["commits"] = 0
+	} else {
+		log.Printf("Diffing %s...%s in %s", cfg.DiffBase, cfg.DiffHead, cfg.RepoPath)
+		revRange := cfg.DiffBase + "..." + cfg.DiffHead
+
+		content, err := runGit(cfg.RepoPath, "diff", "--no-color", "--no-ext-diff", "-M", revRange)
+		if err != nil {
+			log.Printf("Error running git diff: %v", err)
+			return cfg, nil, fmt.Errorf("error running git diff: %v", err)
+		}
+		diff = content
+
+		commits, err := runGit(cfg.RepoPath, "rev-list", "--count", cfg.DiffBase+".."+cfg.DiffHead)
+		if err != nil {
+			log.Printf("Error counting commits: %v", err)
+			return cfg, nil, fmt.Errorf("error counting commits: %v", err)
+		}
+
+		result["title"] = runGitOrDefault(cfg.RepoPath, revRange, "log", "-1", "--format=%s", cfg.DiffHead)
+		result["description"] = runGitOrDefault(cfg.RepoPath, "", "log", "--format=%B", cfg.DiffBase+".."+cfg.DiffHead)
+		result["author"] = runGitOrDefault(cfg.RepoPath, "", "log", "-1", "--format=%an", cfg.DiffHead)
+		result["created_at"] = runGitOrDefault(cfg.RepoPath, time.Now().Format(time.RFC3339), "log", "-1", "--format=%cI", cfg.DiffHead)
+		result["commits"], _ = strconv.Atoi(strings.TrimSpace(commits))
+
+		if branch := runGitOrDefault(cfg.RepoPath, "", "rev-parse", "--abbrev-ref", cfg.DiffHead); branch != "HEAD" {
+			cfg.PRBranch = branch
+		}
+	}
+
+	fileDetails := ParseUnifiedDiff(diff)
+	if len(fileDetails) == 0 {
+		log.Printf("No changes found in diff")
+		return cfg, nil, fmt.Errorf("no changes found to analyze")
+	}
+
+	log.Printf("Processing %d files from local diff", len(fileDetails))
+	llm.ApplyDiffBudget(result, fileDetails, cfg)
+
+	log.Printf("Successfully built local diff details with %d files", len(result["files"].([]map[string]interface{})))
+	return cfg, result, nil
+}
+
+// readPatch reads a patch file, or stdin when path is "-"
+func readPatch(path string) (string, error) {
+	if path == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		return string(content), err
+	}
+	content, err := os.ReadFile(path)
+	return string(content), err
+}
+
+// runGit runs a git command in dir and returns its trimmed stdout
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// runGitOrDefault runs a git command for optional metadata, returning fallback when it fails
+func runGitOrDefault(dir, fallback string, args ...string) string {
+	out, err := runGit(dir, args...)
+	if err != nil || strings.TrimSpace(out) == "" {
+		return fallback
+	}
+	return strings.TrimSpace(out)
+}
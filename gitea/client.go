@@ -0,0 +1,61 @@
+package gitea
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// perPage is the page size for list requests; Gitea caps it at MAX_RESPONSE_ITEMS (50 by default)
+const perPage = 50
+
+// Client is a minimal Gitea REST (v1) client for the pull request endpoints TracePR uses
+type Client struct {
+	*vcs.APIClient
+	Owner string
+	Repo  string
+}
+
+// NewClient returns a client for RepoOwner/RepoName on cfg.GiteaBaseURL
+func NewClient(cfg config.Config) *Client {
+	return &Client{
+		APIClient: vcs.NewAPIClient("Gitea", cfg.GiteaBaseURL, func(req *http.Request) {
+			req.Header.Set("Authorization", "token "+cfg.GiteaToken)
+		}),
+		Owner: cfg.RepoOwner,
+		Repo:  cfg.RepoName,
+	}
+}
+
+// repoPath returns the URL prefix for the repository's endpoints
+func (c *Client) repoPath() string {
+	return "/repos/" + url.PathEscape(c.Owner) + "/" + url.PathEscape(c.Repo)
+}
+
+func (c *Client) pullPath(index int) string {
+	return c.repoPath() + "/pulls/" + strconv.Itoa(index)
+}
+
+// listAll fetches every page of a Gitea list endpoint, following the Link header
+func listAll[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("limit", strconv.Itoa(perPage))
+
+	var all []T
+	for path != "" {
+		var items []T
+		resp, err := c.Do(ctx, http.MethodGet, path, query, nil, &items)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, items...)
+		// The next link already carries the query
+		path, query = vcs.NextLink(resp), nil
+	}
+	return all, nil
+}
@@ -0,0 +1,128 @@
+package gitea
+
+import (
+	"tracepr/config"
+	"tracepr/git"
+	"tracepr/llm"
+	"tracepr/vcs"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// pullRequest is the subset of the Gitea pull request resource TracePR reads
+type pullRequest struct {
+	Number    int    `json:"number"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"created_at"`
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+func (c *Client) getPullRequest(ctx context.Context, index int) (*pullRequest, error) {
+	var pr pullRequest
+	if _, err := c.Do(ctx, http.MethodGet, c.pullPath(index), nil, nil, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// FetchPRDetails builds the same prDetails map as github.FetchPRDetails for a Gitea pull request
+func FetchPRDetails(ctx context.Context, client *Client, cfg config.Config) (config.Config, map[string]interface{}, error) {
+	log.Printf("Fetching PR details for PR #%d in %s/%s", cfg.PRNumber, client.Owner, client.Repo)
+	result := make(map[string]interface{})
+
+	pr, err := client.getPullRequest(ctx, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching PR details: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR details: %v", err)
+	}
+
+	result["title"] = pr.Title
+	result["description"] = pr.Body
+	result["author"] = pr.User.Login
+	result["created_at"] = pr.CreatedAt
+	cfg.PRBranch = pr.Head.Ref
+	cfg.HeadSHA = pr.Head.SHA
+	log.Println("PR branch:", cfg.PRBranch)
+
+	log.Printf("Fetching commits for PR #%d", cfg.PRNumber)
+	commits, err := listAll[struct {
+		SHA string `json:"sha"`
+	}](ctx, client, client.pullPath(cfg.PRNumber)+"/commits", nil)
+	if err != nil {
+		log.Printf("Error fetching PR commits: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR commits: %v", err)
+	}
+
+	// The files endpoint carries no patches, so read the whole diff instead
+	log.Printf("Fetching diff for PR #%d", cfg.PRNumber)
+	_, diff, err := client.DoRaw(ctx, http.MethodGet, client.pullPath(cfg.PRNumber)+".diff", nil, "", nil)
+	if err != nil {
+		log.Printf("Error fetching PR diff: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching PR diff: %v", err)
+	}
+
+	fileDetails := git.ParseUnifiedDiff(string(diff))
+	log.Printf("Processing %d files from PR", len(fileDetails))
+	llm.ApplyDiffBudget(result, fileDetails, cfg)
+	result["commits"] = len(commits)
+
+	log.Printf("Successfully fetched PR details with %d files and %d commits", len(result["files"].([]map[string]interface{})), len(commits))
+	return cfg, result, nil
+}
+
+// CommitFiles writes and deletes files on the PR branch in a single commit. It uses the
+// change-files endpoint added in Gitea 1.20.
+func CommitFiles(ctx context.Context, client *Client, cfg config.Config, changes []vcs.FileChange, message string) error {
+	changes = vcs.CollapseFileChanges(changes)
+	files := make([]map[string]string, 0, len(changes))
+	for _, change := range changes {
+		contentsPath := client.repoPath() + "/contents/" + (&url.URL{Path: change.Path}).EscapedPath()
+
+		// Replacing or deleting a file needs the SHA of its current blob
+		var existing struct {
+			SHA string `json:"sha"`
+		}
+		resp, err := client.Do(ctx, http.MethodGet, contentsPath, url.Values{"ref": {cfg.PRBranch}}, nil, &existing)
+		if err != nil && !vcs.IsNotFound(resp) {
+			return fmt.Errorf("failed to look up %s: %w", change.Path, err)
+		}
+
+		file := map[string]string{"path": change.Path, "operation": "create"}
+		switch {
+		case change.Delete:
+			file["operation"] = "delete"
+		case existing.SHA != "":
+			file["operation"] = "update"
+		}
+		if existing.SHA != "" {
+			file["sha"] = existing.SHA
+		}
+		if !change.Delete {
+			file["content"] = base64.StdEncoding.EncodeToString([]byte(change.Content))
+		}
+		files = append(files, file)
+	}
+
+	body := map[string]interface{}{
+		"branch":  cfg.PRBranch,
+		"message": message,
+		"files":   files,
+	}
+	if _, err := client.Do(ctx, http.MethodPost, client.repoPath()+"/contents", nil, body, nil); err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	log.Printf("Committed %d files to PR branch %s", len(changes), cfg.PRBranch)
+	return nil
+}
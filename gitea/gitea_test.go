@@ -0,0 +1,308 @@
+package gitea
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request is a call the fake Gitea received
+type request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+}
+
+// fakeGitea answers the routed endpoints, keyed by "METHOD path", and 404s everything else
+type fakeGitea struct {
+	t      *testing.T
+	url    string
+	routes map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []request
+}
+
+func newFakeGitea(t *testing.T) (*fakeGitea, config.Config) {
+	f := &fakeGitea{t: t, routes: make(map[string]http.HandlerFunc)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	cfg := config.Config{
+		GiteaBaseURL: server.URL + "/api/v1",
+		GiteaToken:   "secret",
+		RepoOwner:    "org",
+		RepoName:     "service",
+		PRNumber:     5,
+		ClaudeModel:  "claude-3-5-sonnet",
+	}
+	return f, cfg
+}
+
+func (f *fakeGitea) handle(method, path string, handler http.HandlerFunc) {
+	f.routes[method+" /api/v1/repos/org/service"+path] = handler
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.mu.Lock()
+	f.requests = append(f.requests, request{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Body: string(body)})
+	f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "token secret" {
+		f.t.Errorf("%s %s was sent without the token", r.Method, r.URL.Path)
+	}
+	handler, ok := f.routes[r.Method+" "+r.URL.EscapedPath()]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+// sent returns the requests made with method to a path ending in suffix
+func (f *fakeGitea) sent(method, suffix string) []request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matching []request
+	for _, req := range f.requests {
+		if req.Method == method && strings.HasSuffix(req.Path, suffix) {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func respondJSON(v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// paged serves one page per "page" query parameter and links them with a Link header
+func (f *fakeGitea) paged(pages ...interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < len(pages) {
+			next := fmt.Sprintf("%s%s?limit=%d&page=%d", f.url, r.URL.Path, perPage, page+1)
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="last"`, next, next))
+		}
+		respondJSON(pages[page-1])(w, r)
+	}
+}
+
+func pullRequestJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"number": 5,
+		"title":  "Add checkout metrics",
+		"body":   "Counts checkouts",
+		"user":   map[string]string{"login": "dev"},
+		"head":   map[string]string{"ref": "feature/metrics", "sha": "head"},
+	}
+}
+
+const pullDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ a
++b
+ c
+diff --git a/old.go b/new.go
+similarity index 90%
+rename from old.go
+rename to new.go
+--- a/old.go
++++ b/new.go
+@@ -1 +1 @@
+-x
++y
+`
+
+func TestFetchPRDetails(t *testing.T) {
+	f, cfg := newFakeGitea(t)
+	f.handle("GET", "/pulls/5", respondJSON(pullRequestJSON()))
+	f.handle("GET", "/pulls/5/commits", f.paged(
+		[]map[string]string{{"sha": "c1"}, {"sha": "c2"}},
+		[]map[string]string{{"sha": "c3"}},
+	))
+	f.handle("GET", "/pulls/5.diff", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, pullDiff)
+	})
+
+	cfg, details, err := FetchPRDetails(context.Background(), NewClient(cfg), cfg)
+	if err != nil {
+		t.Fatalf("FetchPRDetails: %v", err)
+	}
+
+	if cfg.PRBranch != "feature/metrics" || cfg.HeadSHA != "head" {
+		t.Errorf("got branch %q and head %q, want feature/metrics and head", cfg.PRBranch, cfg.HeadSHA)
+	}
+	if details["title"] != "Add checkout metrics" || details["author"] != "dev" {
+		t.Errorf("unexpected title %v or author %v", details["title"], details["author"])
+	}
+	if details["commits"] != 3 {
+		t.Errorf("commits = %v, want 3 across both pages", details["commits"])
+	}
+	if pages := f.sent("GET", "/pulls/5/commits"); len(pages) != 2 {
+		t.Errorf("fetched %d pages of commits, want 2", len(pages))
+	}
+
+	files := details["files"].([]map[string]interface{})
+	byName := make(map[string]map[string]interface{})
+	for _, file := range files {
+		byName[file["filename"].(string)] = file
+	}
+	if file := byName["main.go"]; file == nil || file["additions"] != 1 || file["patch"] != "@@ -1,2 +1,3 @@\n a\n+b\n c" {
+		t.Errorf("main.go = %v", file)
+	}
+	if file := byName["new.go"]; file == nil || file["status"] != "renamed" || file["previous_filename"] != "old.go" {
+		t.Errorf("new.go = %v", file)
+	}
+}
+
+func TestCreateObservabilityPRComments(t *testing.T) {
+	f, cfg := newFakeGitea(t)
+	f.handle("GET", "/pulls/5", respondJSON(pullRequestJSON()))
+	f.routes["GET /api/v1/user"] = respondJSON(map[string]string{"login": "tracepr"})
+	bot := map[string]string{"login": "tracepr"}
+	dev := map[string]string{"login": "dev"}
+	f.handle("GET", "/pulls/5/reviews", f.paged(
+		[]map[string]interface{}{{"id": 1, "user": bot}},
+		[]map[string]interface{}{{"id": 2, "user": bot}, {"id": 4, "user": dev}},
+	))
+	f.handle("GET", "/pulls/5/reviews/1/comments", respondJSON([]map[string]interface{}{
+		{"id": 11, "user": bot, "body": "old\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:9")},
+	}))
+	f.handle("GET", "/pulls/5/reviews/2/comments", respondJSON([]map[string]interface{}{
+		{"id": 12, "user": bot, "body": "c2\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:3")},
+	}))
+	f.handle("POST", "/pulls/5/reviews", func(w http.ResponseWriter, r *http.Request) {
+		// Like Gitea on the reviewer's own PR, refuse to request changes
+		var review struct {
+			Event string `json:"event"`
+		}
+		json.NewDecoder(r.Body).Decode(&review)
+		if review.Event == "REQUEST_CHANGES" {
+			http.Error(w, `{"message":"reject your own pull is not allowed"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		respondJSON(map[string]int{"id": 3})(w, r)
+	})
+	f.handle("GET", "/issues/5/comments", respondJSON([]map[string]interface{}{
+		// A reviewer quoting the summary must not have their comment edited
+		{"id": 9, "user": dev, "body": "> Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+		{"id": 10, "user": bot, "body": "Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+	}))
+	ok := respondJSON(map[string]interface{}{})
+	f.handle("PATCH", "/issues/comments/10", ok)
+	f.handle("PATCH", "/issues/comments/11", ok)
+
+	prDetails := map[string]interface{}{"files": []map[string]interface{}{
+		{"filename": "main.go", "patch": "@@ -1,2 +1,3 @@\n a\n+b\n c"},
+	}}
+	suggestions := []config.FileSuggestion{
+		{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "high"},
+		{FileName: "main.go", LineNum: "3", Content: "c2", Severity: "low"},
+	}
+	if err := CreateObservabilityPRComments(context.Background(), NewClient(cfg), suggestions, prDetails, cfg, "Two gaps"); err != nil {
+		t.Fatalf("CreateObservabilityPRComments: %v", err)
+	}
+
+	// The refused REQUEST_CHANGES review is retried as a comment review
+	reviews := f.sent("POST", "/pulls/5/reviews")
+	if len(reviews) != 2 {
+		t.Fatalf("submitted %d reviews, want 2", len(reviews))
+	}
+	var review struct {
+		CommitID string                   `json:"commit_id"`
+		Event    string                   `json:"event"`
+		Comments []map[string]interface{} `json:"comments"`
+	}
+	json.Unmarshal([]byte(reviews[1].Body), &review)
+	if review.Event != "COMMENT" || review.CommitID != "head" {
+		t.Errorf("retried review has event %q on %q", review.Event, review.CommitID)
+	}
+	// Only the suggestion that wasn't posted before is in the review
+	if len(review.Comments) != 1 || review.Comments[0]["path"] != "main.go" || review.Comments[0]["new_position"] != float64(2) {
+		t.Errorf("review comments = %v", review.Comments)
+	}
+	if body, _ := review.Comments[0]["body"].(string); strings.Contains(body, "```suggestion") {
+		t.Errorf("suggestion block was not converted to plain code: %q", body)
+	}
+
+	if stale := f.sent("PATCH", "/issues/comments/11"); len(stale) != 1 || !strings.Contains(stale[0].Body, "Outdated") {
+		t.Errorf("stale suggestion edits = %v", stale)
+	}
+	if summary := f.sent("PATCH", "/issues/comments/10"); len(summary) != 1 || !strings.Contains(summary[0].Body, "Two gaps") {
+		t.Errorf("summary edits = %v", summary)
+	}
+	if created := f.sent("POST", "/issues/5/comments"); len(created) != 0 {
+		t.Errorf("created %d comments, want the summary to be edited", len(created))
+	}
+	if other := append(f.sent("PATCH", "/issues/comments/9"), f.sent("GET", "/reviews/4/comments")...); len(other) != 0 {
+		t.Errorf("another user's review or comment was used: %v", other)
+	}
+}
+
+func TestProviderCommitFiles(t *testing.T) {
+	f, cfg := newFakeGitea(t)
+	f.handle("GET", "/pulls/5", respondJSON(pullRequestJSON()))
+	f.handle("GET", "/contents/rules/a.yml", func(w http.ResponseWriter, r *http.Request) {
+		if ref := r.URL.Query().Get("ref"); ref != "feature/metrics" {
+			t.Errorf("looked up a.yml on %q, want the PR branch", ref)
+		}
+		respondJSON(map[string]string{"sha": "sha-a"})(w, r)
+	})
+	f.handle("GET", "/contents/rules/c.yml", respondJSON(map[string]string{"sha": "sha-c"}))
+	f.handle("POST", "/contents", respondJSON(map[string]interface{}{}))
+
+	changes := []vcs.FileChange{
+		{Path: "rules/a.yml", Content: "first"},
+		{Path: "rules/b.yml", Content: "new rule"},
+		{Path: "rules/c.yml", Delete: true},
+		{Path: "rules/a.yml", Content: "second"},
+	}
+	// The provider looks up the PR branch itself when it hasn't been fetched
+	if err := NewProvider(cfg).CommitFiles(context.Background(), changes, "Add rules"); err != nil {
+		t.Fatalf("CommitFiles: %v", err)
+	}
+
+	commits := f.sent("POST", "/contents")
+	if len(commits) != 1 {
+		t.Fatalf("created %d commits, want 1", len(commits))
+	}
+	var commit struct {
+		Branch  string              `json:"branch"`
+		Message string              `json:"message"`
+		Files   []map[string]string `json:"files"`
+	}
+	json.Unmarshal([]byte(commits[0].Body), &commit)
+	if commit.Branch != "feature/metrics" || commit.Message != "Add rules" {
+		t.Errorf("committed %q to %q", commit.Message, commit.Branch)
+	}
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	want := []map[string]string{
+		{"operation": "update", "path": "rules/a.yml", "sha": "sha-a", "content": encode("second")},
+		{"operation": "create", "path": "rules/b.yml", "content": encode("new rule")},
+		{"operation": "delete", "path": "rules/c.yml", "sha": "sha-c"},
+	}
+	if fmt.Sprint(commit.Files) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v", commit.Files, want)
+	}
+}
@@ -0,0 +1,189 @@
+package gitea
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// comment is a Gitea issue or review comment
+type comment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+	User struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// botLogin returns the login TracePR posts as: the configured one or the owner of the token
+func botLogin(ctx context.Context, client *Client, cfg config.Config) (string, error) {
+	if cfg.BotLogin != "" {
+		return cfg.BotLogin, nil
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := client.Do(ctx, http.MethodGet, "/user", nil, nil, &user); err != nil {
+		log.Printf("Error fetching Gitea user: %v", err)
+		return "", fmt.Errorf("error fetching Gitea user: %v", err)
+	}
+	return user.Login, nil
+}
+
+// listIssueComments returns every conversation comment on the PR
+func listIssueComments(ctx context.Context, client *Client, index int) ([]vcs.Comment, error) {
+	comments, err := listAll[comment](ctx, client, fmt.Sprintf("%s/issues/%d/comments", client.repoPath(), index), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing PR comments: %v", err)
+	}
+	return toComments(comments), nil
+}
+
+// listReviewComments returns the inline comments of self's reviews on the PR
+func listReviewComments(ctx context.Context, client *Client, index int, self string) ([]vcs.Comment, error) {
+	reviews, err := listAll[comment](ctx, client, client.pullPath(index)+"/reviews", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing PR reviews: %v", err)
+	}
+
+	var result []vcs.Comment
+	for _, review := range reviews {
+		if !strings.EqualFold(review.User.Login, self) {
+			continue
+		}
+		var comments []comment
+		path := fmt.Sprintf("%s/reviews/%d/comments", client.pullPath(index), review.ID)
+		if _, err := client.Do(ctx, http.MethodGet, path, nil, nil, &comments); err != nil {
+			return nil, fmt.Errorf("error listing comments of review %d: %v", review.ID, err)
+		}
+		result = append(result, toComments(comments)...)
+	}
+	return result, nil
+}
+
+func toComments(comments []comment) []vcs.Comment {
+	result := make([]vcs.Comment, 0, len(comments))
+	for _, c := range comments {
+		result = append(result, vcs.Comment{ID: c.ID, Author: c.User.Login, Body: c.Body})
+	}
+	return result
+}
+
+func createIssueComment(ctx context.Context, client *Client, index int, body string) error {
+	_, err := client.Do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/comments", client.repoPath(), index), nil, map[string]string{"body": body}, nil)
+	return err
+}
+
+// editComment edits an issue or review comment, which share an ID space
+func editComment(ctx context.Context, client *Client, id int64, body string) error {
+	_, err := client.Do(ctx, http.MethodPatch, fmt.Sprintf("%s/issues/comments/%d", client.repoPath(), id), nil, map[string]string{"body": body}, nil)
+	return err
+}
+
+// submitReview creates a review with the inline comments on the PR head commit
+func submitReview(ctx context.Context, client *Client, index int, headSHA, event, body string, comments []vcs.ReviewComment) error {
+	drafts := make([]map[string]interface{}, 0, len(comments))
+	for _, c := range comments {
+		drafts = append(drafts, map[string]interface{}{
+			"path":         c.Path,
+			"new_position": c.Line,
+			// Gitea has no suggested changes, so show them as plain code
+			"body": vcs.WithMarker(vcs.PlainSuggestion(c.Body), vcs.MarkerSuggestion, c.Key),
+		})
+	}
+
+	_, err := client.Do(ctx, http.MethodPost, client.pullPath(index)+"/reviews", nil, map[string]interface{}{
+		"commit_id": headSHA,
+		"event":     event,
+		"body":      body,
+		"comments":  drafts,
+	}, nil)
+	return err
+}
+
+// CreateObservabilityPRComments posts the inline suggestions as a single review and keeps one summary
+// comment up to date. Review bodies can't be edited through the Gitea API, so unlike on GitHub the
+// summary lives in a conversation comment.
+func CreateObservabilityPRComments(ctx context.Context, client *Client, suggestions []config.FileSuggestion, prDetails map[string]interface{}, cfg config.Config, summary string) error {
+	log.Printf("Creating observability PR review for PR #%d", cfg.PRNumber)
+
+	pr, err := client.getPullRequest(ctx, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching PR to get HEAD SHA: %v", err)
+		return fmt.Errorf("error fetching PR to get HEAD SHA: %v", err)
+	}
+
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Loading previous TracePR review comments")
+	posted, err := listReviewComments(ctx, client, cfg.PRNumber, self)
+	if err != nil {
+		log.Printf("Error listing PR review comments: %v", err)
+		return err
+	}
+
+	plan := vcs.PlanReview(suggestions, prDetails, summary, posted, self)
+
+	for _, c := range plan.Stale {
+		_, key, _ := vcs.ParseMarker(c.Body)
+		log.Printf("Marking stale suggestion %s as outdated", key)
+		if err := editComment(ctx, client, c.ID, vcs.OutdatedBody(c.Body, key)); err != nil {
+			log.Printf("Error marking suggestion %s as outdated: %v", key, err)
+		}
+	}
+
+	summaryBody := plan.Summary
+	if len(plan.LineComments) > 0 {
+		event := "COMMENT"
+		if vcs.HasHighSeverity(suggestions) {
+			event = "REQUEST_CHANGES"
+		}
+		body := fmt.Sprintf("TracePR found %d new observability suggestions on %s.", len(plan.LineComments), vcs.ShortSHA(pr.Head.SHA))
+
+		log.Printf("Submitting %s review with %d inline comments", event, len(plan.LineComments))
+		err := submitReview(ctx, client, cfg.PRNumber, pr.Head.SHA, event, body, plan.LineComments)
+		if err != nil && event != "COMMENT" {
+			// Gitea refuses REQUEST_CHANGES on the reviewer's own PR
+			log.Printf("Could not submit %s review, retrying as COMMENT: %v", event, err)
+			err = submitReview(ctx, client, cfg.PRNumber, pr.Head.SHA, "COMMENT", body, plan.LineComments)
+		}
+		if err != nil {
+			log.Printf("Could not submit review with inline comments, adding all suggestions to the summary: %v", err)
+			summaryBody += vcs.FormatFileLevelComments(plan.LineComments)
+		}
+	}
+
+	cfg.BotLogin = self
+	if err := SyncIssueComments(ctx, client, cfg, []vcs.MarkedComment{{Kind: vcs.MarkerSummary, Key: "check", Body: summaryBody}}); err != nil {
+		log.Printf("Error posting PR summary: %v", err)
+		return fmt.Errorf("error posting PR summary: %v", err)
+	}
+
+	log.Printf("Successfully created observability PR review")
+	return nil
+}
+
+// SyncIssueComments makes the PR conversation match the desired comments, see vcs.SyncComments
+func SyncIssueComments(ctx context.Context, client *Client, cfg config.Config, desired []vcs.MarkedComment, staleKinds ...string) error {
+	existing, err := listIssueComments(ctx, client, cfg.PRNumber)
+	if err != nil {
+		return err
+	}
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	return vcs.SyncComments(existing, self, desired, func(body string) error {
+		return createIssueComment(ctx, client, cfg.PRNumber, body)
+	}, func(id int64, body string) error {
+		return editComment(ctx, client, id, body)
+	}, staleKinds...)
+}
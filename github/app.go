@@ -0,0 +1,196 @@
+package github
+
+import (
+	"tracepr/config"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appJWTLifetime is how long an app JWT is valid; GitHub rejects anything over 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// tokenRefreshMargin renews installation tokens this long before they expire
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// usesGithubApp reports whether TracePR should authenticate as a GitHub App installation
+func usesGithubApp(cfg config.Config) bool {
+	return cfg.GithubAppID != 0
+}
+
+// newAppTokenSource returns a token source that exchanges app JWTs for installation access
+// tokens and requests a new one shortly before the current token expires
+func newAppTokenSource(ctx context.Context, cfg config.Config) (oauth2.TokenSource, error) {
+	appClient, err := newAppClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	source := &installationTokenSource{
+		ctx:            ctx,
+		cfg:            cfg,
+		installationID: cfg.GithubAppInstallationID,
+		appClient:      appClient,
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(nil, source, tokenRefreshMargin), nil
+}
+
+// newAppClient returns a client authenticated as the app itself. App endpoints only accept the
+// JWT, never an installation token.
+func newAppClient(cfg config.Config) (*github.Client, error) {
+	key, err := loadAppPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signer := &appJWTSigner{appID: cfg.GithubAppID, key: key}
+	return newClient(&http.Client{Transport: &appJWTTransport{signer: signer}}, cfg)
+}
+
+// appBotLogin returns the login of the app's bot user, which installation tokens post as
+func appBotLogin(ctx context.Context, cfg config.Config) (string, error) {
+	appClient, err := newAppClient(cfg)
+	if err != nil {
+		return "", err
+	}
+	app, _, err := appClient.Apps.Get(ctx, "")
+	if err != nil {
+		log.Printf("Error fetching GitHub App: %v", err)
+		return "", fmt.Errorf("error fetching GitHub App: %v", err)
+	}
+	return app.GetSlug() + "[bot]", nil
+}
+
+// loadAppPrivateKey parses the app's PEM private key from the config or the key file
+func loadAppPrivateKey(cfg config.Config) (*rsa.PrivateKey, error) {
+	keyPEM := []byte(cfg.GithubAppPrivateKey)
+	if len(keyPEM) == 0 {
+		content, err := os.ReadFile(cfg.GithubAppPrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading GitHub App private key: %v", err)
+		}
+		keyPEM = content
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM encoded")
+	}
+	// GitHub issues PKCS#1 keys, but keys converted to PKCS#8 work too
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing GitHub App private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// appJWTSigner creates the short-lived RS256 JWTs that authenticate as the app itself
+type appJWTSigner struct {
+	appID int64
+	key   *rsa.PrivateKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Token returns a cached JWT, signing a new one when it is about to expire
+func (s *appJWTSigner) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Add(time.Minute).Before(s.expires) {
+		return s.token, nil
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		// Backdated to allow for clock drift between us and GitHub
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(s.appID, 10),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing GitHub App JWT: %v", err)
+	}
+
+	s.token = unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	s.expires = now.Add(appJWTLifetime)
+	return s.token, nil
+}
+
+// appJWTTransport authenticates requests as the app with a fresh JWT
+type appJWTTransport struct {
+	signer *appJWTSigner
+}
+
+func (t *appJWTTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.signer.Token()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// installationTokenSource exchanges the app JWT for an access token of the installation on the
+// configured repository. Without an explicit installation ID it is looked up once.
+type installationTokenSource struct {
+	ctx            context.Context
+	cfg            config.Config
+	installationID int64
+	appClient      *github.Client
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	if s.installationID == 0 {
+		log.Printf("Looking up GitHub App installation for %s/%s", s.cfg.RepoOwner, s.cfg.RepoName)
+		installation, _, err := s.appClient.Apps.FindRepositoryInstallation(s.ctx, s.cfg.RepoOwner, s.cfg.RepoName)
+		if err != nil {
+			log.Printf("Error finding GitHub App installation: %v", err)
+			return nil, fmt.Errorf("error finding GitHub App installation for %s/%s: %v", s.cfg.RepoOwner, s.cfg.RepoName, err)
+		}
+		s.installationID = installation.GetID()
+	}
+
+	log.Printf("Requesting access token for GitHub App installation %d", s.installationID)
+	token, _, err := s.appClient.Apps.CreateInstallationToken(s.ctx, s.installationID, nil)
+	if err != nil {
+		log.Printf("Error creating installation access token: %v", err)
+		return nil, fmt.Errorf("error creating installation access token: %v", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Time,
+	}, nil
+}
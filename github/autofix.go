@@ -0,0 +1,204 @@
+package github
+
+import (
+	"tracepr/apply"
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// FixBranch is the branch the auto-fix PR for a pull request is pushed to
+func FixBranch(prNumber int) string {
+	return fmt.Sprintf("tracepr/fix-pr-%d", prNumber)
+}
+
+// OpenFixPR applies every suggestion that can be applied safely to a branch based on the PR head
+// and opens a PR against the PR's branch with them, stacked on the original PR. A fix PR from an
+// earlier run is updated in place, and its branch is reset to the current PR head. It returns
+// nil when no suggestion could be applied.
+func OpenFixPR(ctx context.Context, client *github.Client, cfg config.Config, suggestions []config.FileSuggestion, summary string) (*github.PullRequest, error) {
+	pr, _, err := client.PullRequests.Get(ctx, cfg.RepoOwner, cfg.RepoName, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching PR #%d: %v", cfg.PRNumber, err)
+		return nil, fmt.Errorf("error fetching PR #%d: %v", cfg.PRNumber, err)
+	}
+	if pr.GetHead().GetRepo().GetFullName() != pr.GetBase().GetRepo().GetFullName() {
+		return nil, fmt.Errorf("PR #%d comes from a fork, so a fix PR can't be stacked on it", cfg.PRNumber)
+	}
+	headRef, headSHA := pr.GetHead().GetRef(), pr.GetHead().GetSHA()
+
+	files, applied, skipped := applySuggestions(ctx, client, cfg, headSHA, suggestions)
+	if len(applied) == 0 {
+		log.Printf("None of the %d suggestions could be applied, not opening a fix PR", len(suggestions))
+		return nil, nil
+	}
+
+	// Start the fix branch from the PR head and add the fix commit on top
+	branch := FixBranch(cfg.PRNumber)
+	if err := resetBranch(ctx, client, cfg, branch, headSHA); err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("Apply TracePR observability suggestions for #%d", cfg.PRNumber)
+	if _, err := CommitFiles(ctx, client, cfg, branch, files, message); err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("TracePR: observability fixes for #%d", cfg.PRNumber)
+	body := fixPRBody(cfg.PRNumber, summary, applied, skipped)
+	existing, _, err := client.PullRequests.List(ctx, cfg.RepoOwner, cfg.RepoName, &github.PullRequestListOptions{
+		State: "open",
+		Head:  cfg.RepoOwner + ":" + branch,
+		Base:  headRef,
+	})
+	if err != nil {
+		log.Printf("Error listing fix PRs: %v", err)
+		return nil, fmt.Errorf("error listing fix PRs: %v", err)
+	}
+	if len(existing) > 0 {
+		log.Printf("Updating fix PR #%d", existing[0].GetNumber())
+		fixPR, _, err := client.PullRequests.Edit(ctx, cfg.RepoOwner, cfg.RepoName, existing[0].GetNumber(), &github.PullRequest{
+			Title: github.String(title),
+			Body:  github.String(body),
+		})
+		if err != nil {
+			log.Printf("Error updating fix PR: %v", err)
+			return nil, fmt.Errorf("error updating fix PR: %v", err)
+		}
+		return fixPR, nil
+	}
+
+	log.Printf("Opening fix PR from %s into %s", branch, headRef)
+	fixPR, _, err := client.PullRequests.Create(ctx, cfg.RepoOwner, cfg.RepoName, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(branch),
+		Base:  github.String(headRef),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		log.Printf("Error opening fix PR: %v", err)
+		return nil, fmt.Errorf("error opening fix PR: %v", err)
+	}
+	return fixPR, nil
+}
+
+// applySuggestions applies the suggestions to the files at the PR head and returns the new
+// content of every changed file along with the applied and skipped changes
+func applySuggestions(ctx context.Context, client *github.Client, cfg config.Config, headSHA string, suggestions []config.FileSuggestion) ([]vcs.FileChange, []*apply.Change, []*apply.Change) {
+	byFile := make(map[string][]config.FileSuggestion)
+	var paths []string
+	for _, suggestion := range suggestions {
+		if _, ok := byFile[suggestion.FileName]; !ok {
+			paths = append(paths, suggestion.FileName)
+		}
+		byFile[suggestion.FileName] = append(byFile[suggestion.FileName], suggestion)
+	}
+	sort.Strings(paths)
+
+	var files []vcs.FileChange
+	var applied, skipped []*apply.Change
+	for _, path := range paths {
+		var changes []*apply.Change
+		content, err := fileContent(ctx, client, cfg, path, headSHA)
+		if err != nil {
+			log.Printf("Could not read %s at %s: %v", path, headSHA, err)
+			for _, suggestion := range byFile[path] {
+				changes = append(changes, &apply.Change{Suggestion: suggestion, Conflict: "the file could not be read"})
+			}
+			skipped = append(skipped, changes...)
+			continue
+		}
+
+		fileApplied, fileSkipped := apply.Applicable(apply.PlanContent(content, byFile[path]))
+		skipped = append(skipped, fileSkipped...)
+		if len(fileApplied) == 0 {
+			continue
+		}
+		updated, err := apply.ApplyContent(content, fileApplied)
+		if err != nil {
+			log.Printf("Could not apply suggestions to %s: %v", path, err)
+			for _, change := range fileApplied {
+				change.Conflict = "the file " + err.Error()
+			}
+			skipped = append(skipped, fileApplied...)
+			continue
+		}
+		files = append(files, vcs.FileChange{Path: path, Content: apply.FormatSource(path, updated)})
+		applied = append(applied, fileApplied...)
+	}
+	log.Printf("Applied %d suggestions to %d files, skipped %d", len(applied), len(files), len(skipped))
+	return files, applied, skipped
+}
+
+// fileContent returns the content of path at ref
+func fileContent(ctx context.Context, client *github.Client, cfg config.Config, path, ref string) (string, error) {
+	if !apply.ValidPath(path) {
+		return "", fmt.Errorf("path %q is outside the repository", path)
+	}
+	file, _, _, err := client.Repositories.GetContents(ctx, cfg.RepoOwner, cfg.RepoName, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return "", err
+	}
+	if file == nil {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	return file.GetContent()
+}
+
+// resetBranch points branch at sha, creating the branch if needed
+func resetBranch(ctx context.Context, client *github.Client, cfg config.Config, branch, sha string) error {
+	ref := "refs/heads/" + branch
+	_, resp, err := client.Git.GetRef(ctx, cfg.RepoOwner, cfg.RepoName, ref)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		log.Printf("Creating branch %s", branch)
+		_, _, err = client.Git.CreateRef(ctx, cfg.RepoOwner, cfg.RepoName, &github.Reference{
+			Ref:    github.String(ref),
+			Object: &github.GitObject{SHA: github.String(sha)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", branch, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get reference to branch: %w", err)
+	}
+
+	// The branch only ever holds TracePR's fix commit, so it is force-reset for the new one
+	log.Printf("Resetting branch %s", branch)
+	_, _, err = client.Git.UpdateRef(ctx, cfg.RepoOwner, cfg.RepoName, &github.Reference{
+		Ref:    github.String(ref),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}, true)
+	if err != nil {
+		return fmt.Errorf("failed to update reference: %w", err)
+	}
+	return nil
+}
+
+// fixPRBody describes the fix PR: the analysis summary followed by what was and wasn't applied
+func fixPRBody(prNumber int, summary string, applied, skipped []*apply.Change) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Applies TracePR's observability suggestions for #%d. Merging this PR adds them to #%d's branch.\n\n", prNumber, prNumber))
+	if strings.TrimSpace(summary) != "" {
+		b.WriteString(summary + "\n\n")
+	}
+
+	b.WriteString("### Applied suggestions\n\n")
+	for _, change := range applied {
+		b.WriteString(fmt.Sprintf("- `%s` %s (%s severity)\n", change.Suggestion.FileName, change.Lines(), change.Suggestion.Severity))
+	}
+	if len(skipped) > 0 {
+		b.WriteString("\n### Skipped suggestions\n\n")
+		for _, change := range skipped {
+			b.WriteString(fmt.Sprintf("- `%s` line %s: %s\n", change.Suggestion.FileName, change.Suggestion.LineNum, change.Conflict))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package github
+
+import (
+	"tracepr/config"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+)
+
+const (
+	// CheckRunName is the name branch protection rules require
+	CheckRunName = "TracePR"
+	// maxAnnotationsPerRequest is the number of annotations the Checks API accepts per update
+	maxAnnotationsPerRequest = 50
+	// maxCheckSummary is the longest check run summary the Checks API accepts
+	maxCheckSummary = 65535
+)
+
+// Check run conclusions
+const (
+	ConclusionSuccess = "success"
+	ConclusionNeutral = "neutral"
+	ConclusionFailure = "failure"
+)
+
+// severityRank orders suggestion severities; unknown severities rank as medium like everywhere else
+var severityRank = map[string]int{"low": 1, "medium": 2, "high": 3}
+
+// CheckRun is an in-progress TracePR check run on the head commit of a PR
+type CheckRun struct {
+	client *github.Client
+	cfg    config.Config
+	id     int64
+}
+
+// StartCheckRun creates an in-progress check run on the PR's head commit
+func StartCheckRun(ctx context.Context, client *github.Client, cfg config.Config) (*CheckRun, error) {
+	if cfg.HeadSHA == "" {
+		return nil, fmt.Errorf("no head SHA to create a check run on")
+	}
+
+	log.Printf("Creating %s check run on %s", CheckRunName, cfg.HeadSHA)
+	run, _, err := client.Checks.CreateCheckRun(ctx, cfg.RepoOwner, cfg.RepoName, github.CreateCheckRunOptions{
+		Name:      CheckRunName,
+		HeadSHA:   cfg.HeadSHA,
+		Status:    github.String("in_progress"),
+		StartedAt: &github.Timestamp{Time: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Error creating check run: %v", err)
+		return nil, fmt.Errorf("error creating check run: %v", err)
+	}
+	return &CheckRun{client: client, cfg: cfg, id: run.GetID()}, nil
+}
+
+// Complete publishes the suggestions as annotations and completes the check run with conclusion.
+// The Checks API takes at most 50 annotations per request, so they are sent in batches and the
+// run is only completed with the last one.
+func (c *CheckRun) Complete(ctx context.Context, conclusion, title, summary string, suggestions []config.FileSuggestion) error {
+	annotations := checkAnnotations(suggestions)
+	summary = truncateSummary(summary)
+
+	for start := 0; ; start += maxAnnotationsPerRequest {
+		end := start + maxAnnotationsPerRequest
+		last := end >= len(annotations)
+		if last {
+			end = len(annotations)
+		}
+
+		opts := github.UpdateCheckRunOptions{
+			Name: CheckRunName,
+			Output: &github.CheckRunOutput{
+				Title:       github.String(title),
+				Summary:     github.String(summary),
+				Annotations: annotations[start:end],
+			},
+		}
+		if last {
+			opts.Status = github.String("completed")
+			opts.Conclusion = github.String(conclusion)
+			opts.CompletedAt = &github.Timestamp{Time: time.Now()}
+		}
+
+		if _, _, err := c.client.Checks.UpdateCheckRun(ctx, c.cfg.RepoOwner, c.cfg.RepoName, c.id, opts); err != nil {
+			log.Printf("Error updating check run: %v", err)
+			return fmt.Errorf("error updating check run: %v", err)
+		}
+		if last {
+			break
+		}
+	}
+
+	log.Printf("Completed %s check run as %s with %d annotations", CheckRunName, conclusion, len(annotations))
+	return nil
+}
+
+// Fail completes the check run as neutral when the analysis could not finish, so a flaky LLM
+// call doesn't block merging
+func (c *CheckRun) Fail(ctx context.Context, cause error) error {
+	return c.Complete(ctx, ConclusionNeutral, "TracePR could not complete the analysis", cause.Error(), nil)
+}
+
+// CheckConclusion decides the check run conclusion from the most severe suggestion: failure when
+// it reaches failOn, neutral when it reaches neutralOn and success otherwise. A threshold of
+// "never" disables it.
+func CheckConclusion(suggestions []config.FileSuggestion, failOn, neutralOn string) string {
+	highest := 0
+	for _, suggestion := range suggestions {
+		if rank := rankSeverity(suggestion.Severity); rank > highest {
+			highest = rank
+		}
+	}
+
+	if rank, ok := severityRank[failOn]; ok && highest >= rank {
+		return ConclusionFailure
+	}
+	if rank, ok := severityRank[neutralOn]; ok && highest >= rank {
+		return ConclusionNeutral
+	}
+	return ConclusionSuccess
+}
+
+func rankSeverity(severity string) int {
+	if rank, ok := severityRank[severity]; ok {
+		return rank
+	}
+	return severityRank["medium"]
+}
+
+// checkAnnotations converts the suggestions into check run annotations. Suggestions without a
+// usable line number can't be annotated and are left to the review comments.
+func checkAnnotations(suggestions []config.FileSuggestion) []*github.CheckRunAnnotation {
+	var annotations []*github.CheckRunAnnotation
+	for _, suggestion := range suggestions {
+		line, err := strconv.Atoi(suggestion.LineNum)
+		if err != nil || line <= 0 {
+			log.Printf("Skipping annotation for %s: invalid line number %q", suggestion.FileName, suggestion.LineNum)
+			continue
+		}
+		startLine := line
+		if start, err := strconv.Atoi(suggestion.StartLineNum); err == nil && start > 0 && start < line {
+			startLine = start
+		}
+
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(suggestion.FileName),
+			StartLine:       github.Int(startLine),
+			EndLine:         github.Int(line),
+			AnnotationLevel: github.String(annotationLevel(suggestion.Severity)),
+			Title:           github.String(fmt.Sprintf("Observability suggestion (%s severity)", suggestion.Severity)),
+			Message:         github.String("Suggested change:\n\n" + suggestion.Content),
+			RawDetails:      github.String(suggestion.Content),
+		})
+	}
+	return annotations
+}
+
+// annotationLevel maps suggestion severities onto check run annotation levels
+func annotationLevel(severity string) string {
+	switch severity {
+	case "high":
+		return "failure"
+	case "low":
+		return "notice"
+	default:
+		return "warning"
+	}
+}
+
+// truncateSummary shortens summary to what the Checks API accepts
+func truncateSummary(summary string) string {
+	if len(summary) <= maxCheckSummary {
+		return summary
+	}
+	const note = "\n\n_Summary truncated._"
+	cut := maxCheckSummary - len(note)
+	// Don't split a multi-byte character
+	for cut > 0 && summary[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return summary[:cut] + note
+}
@@ -0,0 +1,93 @@
+package github
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// maxCommitAttempts is how often CommitFiles rebuilds its commit when the branch moved meanwhile
+const maxCommitAttempts = 3
+
+// CommitFiles writes and deletes files on branch in a single commit. If the branch moves between
+// reading its head and updating it, the commit is rebuilt on the new head and the update retried,
+// so concurrent writers don't overwrite each other.
+func CommitFiles(ctx context.Context, client *github.Client, cfg config.Config, branch string, changes []vcs.FileChange, message string) (*github.Commit, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no files to commit")
+	}
+
+	// Blobs don't depend on the branch head, so they are only uploaded once
+	entries, err := treeEntries(ctx, client, cfg, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		ref, _, err := client.Git.GetRef(ctx, cfg.RepoOwner, cfg.RepoName, "refs/heads/"+branch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reference to branch: %w", err)
+		}
+
+		parent, _, err := client.Git.GetCommit(ctx, cfg.RepoOwner, cfg.RepoName, ref.GetObject().GetSHA())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get commit: %w", err)
+		}
+
+		tree, _, err := client.Git.CreateTree(ctx, cfg.RepoOwner, cfg.RepoName, parent.GetTree().GetSHA(), entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tree: %w", err)
+		}
+
+		commit, _, err := client.Git.CreateCommit(ctx, cfg.RepoOwner, cfg.RepoName, &github.Commit{
+			Message: github.String(message),
+			Tree:    tree,
+			Parents: []*github.Commit{parent},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create commit: %w", err)
+		}
+
+		ref.Object.SHA = commit.SHA
+		_, resp, err := client.Git.UpdateRef(ctx, cfg.RepoOwner, cfg.RepoName, ref, false)
+		if err == nil {
+			log.Printf("Committed %d files to branch %s", len(entries), branch)
+			return commit, nil
+		}
+		// GitHub answers 422 when the branch no longer points at the parent
+		if resp == nil || resp.StatusCode != http.StatusUnprocessableEntity || attempt == maxCommitAttempts {
+			return nil, fmt.Errorf("failed to update reference: %w", err)
+		}
+		log.Printf("Branch %s moved while committing, retrying (attempt %d of %d)", branch, attempt+1, maxCommitAttempts)
+	}
+}
+
+// treeEntries uploads the written files as blobs and returns the tree entries for changes
+func treeEntries(ctx context.Context, client *github.Client, cfg config.Config, changes []vcs.FileChange) ([]*github.TreeEntry, error) {
+	var entries []*github.TreeEntry
+	for _, change := range vcs.CollapseFileChanges(changes) {
+		entry := &github.TreeEntry{
+			Path: github.String(change.Path),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
+		}
+		// An entry without a SHA deletes the path
+		if !change.Delete {
+			blob, _, err := client.Git.CreateBlob(ctx, cfg.RepoOwner, cfg.RepoName, &github.Blob{
+				Content:  github.String(change.Content),
+				Encoding: github.String("utf-8"),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create blob: %w", err)
+			}
+			entry.SHA = blob.SHA
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
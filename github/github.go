@@ -2,32 +2,102 @@ package github
 
 import (
 	"tracepr/config"
-	"tracepr/utils"
+	"tracepr/llm"
 	"context"
 	"fmt"
 	"log"
-	"path/filepath"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v53/github"
 	"golang.org/x/oauth2"
 )
 
-// initializeGithubClient creates and returns a GitHub client with proper authentication
-func InitializeGithubClient(config config.Config, ctx context.Context) *github.Client {
-	log.Printf("Initializing GitHub client")
-	return github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: config.GithubToken},
-	)))
+// initializeGithubClient creates and returns a GitHub client with proper authentication:
+// as a GitHub App installation when an app ID is configured, otherwise with the token.
+func InitializeGithubClient(config config.Config, ctx context.Context) (*github.Client, error) {
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.GithubToken})
+	if usesGithubApp(config) {
+		log.Printf("Authenticating as GitHub App %d", config.GithubAppID)
+		source, err := newAppTokenSource(ctx, config)
+		if err != nil {
+			log.Printf("Error setting up GitHub App authentication: %v", err)
+			return nil, fmt.Errorf("error setting up GitHub App authentication: %v", err)
+		}
+		tokenSource = source
+	}
+	return newClient(oauth2.NewClient(ctx, tokenSource), config)
+}
+
+// newClient returns a go-github client using httpClient for authentication. When GithubBaseURL
+// is set the client talks to that GitHub Enterprise Server instead of github.com.
+func newClient(httpClient *http.Client, config config.Config) (*github.Client, error) {
+	if !isEnterprise(config) {
+		log.Printf("Initializing GitHub client")
+		return github.NewClient(httpClient), nil
+	}
+
+	uploadURL := config.GithubUploadURL
+	if uploadURL == "" {
+		uploadURL = enterpriseHost(config)
+	}
+	log.Printf("Initializing GitHub Enterprise client for %s", config.GithubBaseURL)
+	client, err := github.NewEnterpriseClient(config.GithubBaseURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub Enterprise URL: %v", err)
+	}
+	return client, nil
+}
+
+// isEnterprise reports whether the config points at a GitHub Enterprise Server
+func isEnterprise(cfg config.Config) bool {
+	base := strings.TrimRight(cfg.GithubBaseURL, "/")
+	return base != "" && base != "https://api.github.com"
+}
+
+// enterpriseHost returns the web root of the GitHub Enterprise Server, e.g. https://github.example.com
+func enterpriseHost(cfg config.Config) string {
+	return strings.TrimSuffix(strings.TrimRight(cfg.GithubBaseURL, "/"), "/api/v3")
+}
+
+// RepoURL returns the web URL of the repository on github.com or the Enterprise Server
+func RepoURL(cfg config.Config) string {
+	host := "https://github.com"
+	if isEnterprise(cfg) {
+		host = enterpriseHost(cfg)
+	}
+	return fmt.Sprintf("%s/%s/%s", host, cfg.RepoOwner, cfg.RepoName)
 }
 
-func FetchPRDetails(client *github.Client, config config.Config) (config.Config, map[string]interface{}, error) {
+// botLogin returns the login TracePR posts as: the configured one, the app's bot user, or the owner
+// of the token. Actions' GITHUB_TOKEN may not read its own user and posts as github-actions[bot].
+func botLogin(ctx context.Context, client *github.Client, cfg config.Config) (string, error) {
+	if cfg.BotLogin != "" {
+		return cfg.BotLogin, nil
+	}
+	if usesGithubApp(cfg) {
+		return appBotLogin(ctx, cfg)
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		if cfg.GithubActions {
+			return "github-actions[bot]", nil
+		}
+		log.Printf("Error fetching authenticated user: %v", err)
+		return "", fmt.Errorf("error fetching authenticated user: %v", err)
+	}
+	return user.GetLogin(), nil
+}
+
+// FetchPRDetails fetches the PR, its commits and its files, making every API call with ctx
+func FetchPRDetails(ctx context.Context, client *github.Client, config config.Config) (config.Config, map[string]interface{}, error) {
 	log.Printf("Fetching PR details for PR #%d in %s/%s", config.PRNumber, config.RepoOwner, config.RepoName)
 	result := make(map[string]interface{})
 
 	// Fetch PR details
 	pr, _, err := client.PullRequests.Get(
-		context.Background(),
+		ctx,
 		config.RepoOwner,
 		config.RepoName,
 		config.PRNumber,
@@ -42,19 +112,14 @@ func FetchPRDetails(client *github.Client, config config.Config) (config.Config,
 	result["author"] = pr.GetUser().GetLogin()
 	result["created_at"] = pr.GetCreatedAt().Format(time.RFC3339)
 	config.PRBranch = pr.GetHead().GetRef()
+	config.HeadSHA = pr.GetHead().GetSHA()
 	log.Println("PR branch:", config.PRBranch)
 
 	// Fetch PR diff
-	opt := &github.ListOptions{}
-	ctx := context.Background()
 	log.Printf("Fetching commits for PR #%d", config.PRNumber)
-	commits, _, err := client.PullRequests.ListCommits(
-		ctx,
-		config.RepoOwner,
-		config.RepoName,
-		config.PRNumber,
-		opt,
-	)
+	commits, err := ListAll(ctx, config.GithubPerPage, func(ctx context.Context, opts github.ListOptions) ([]*github.RepositoryCommit, *github.Response, error) {
+		return client.PullRequests.ListCommits(ctx, config.RepoOwner, config.RepoName, config.PRNumber, &opts)
+	})
 	if err != nil {
 		log.Printf("Error fetching PR commits: %v", err)
 		return config, nil, fmt.Errorf("error fetching PR commits: %v", err)
@@ -62,13 +127,9 @@ func FetchPRDetails(client *github.Client, config config.Config) (config.Config,
 
 	// Get PR files (diff)
 	log.Printf("Fetching files for PR #%d", config.PRNumber)
-	files, _, err := client.PullRequests.ListFiles(
-		context.Background(),
-		config.RepoOwner,
-		config.RepoName,
-		config.PRNumber,
-		opt,
-	)
+	files, err := ListAll(ctx, config.GithubPerPage, func(ctx context.Context, opts github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+		return client.PullRequests.ListFiles(ctx, config.RepoOwner, config.RepoName, config.PRNumber, &opts)
+	})
 	if err != nil {
 		log.Printf("Error fetching PR files: %v", err)
 		return config, nil, fmt.Errorf("error fetching PR files: %v", err)
@@ -76,18 +137,9 @@ func FetchPRDetails(client *github.Client, config config.Config) (config.Config,
 
 	// Process files
 	fileDetails := []map[string]interface{}{}
-	totalDiffSize := 0
 
 	log.Printf("Processing %d files from PR", len(files))
 	for _, file := range files {
-		// Check if we're exceeding max diff size
-		patchSize := len(file.GetPatch())
-		if totalDiffSize+patchSize > config.MaxDiffSize {
-			log.Printf("Skipping file %s as it would exceed max diff size", file.GetFilename())
-			continue
-		}
-		totalDiffSize += patchSize
-
 		fileDetail := map[string]interface{}{
 			"filename":  file.GetFilename(),
 			"status":    file.GetStatus(),
@@ -98,69 +150,9 @@ func FetchPRDetails(client *github.Client, config config.Config) (config.Config,
 		fileDetails = append(fileDetails, fileDetail)
 	}
 
-	result["files"] = fileDetails
+	llm.ApplyDiffBudget(result, fileDetails, config)
 	result["commits"] = len(commits)
 
-	log.Printf("Successfully fetched PR details with %d files and %d commits", len(fileDetails), len(commits))
+	log.Printf("Successfully fetched PR details with %d files and %d commits", len(result["files"].([]map[string]interface{})), len(commits))
 	return config, result, nil
 }
-
-// Shared function to commit an alert rule to the repository
-func CommitAlertToRepository(suggestion config.AlertSuggestion, alertRule string, configPath string, cfg config.Config) error {
-	fileName := utils.NormalizeFileName(suggestion.Name) + ".yml"
-	repoPath := filepath.Join(configPath, fileName)
-
-	ctx := context.Background()
-	client := InitializeGithubClient(cfg, ctx)
-
-	ref, _, err := client.Git.GetRef(ctx, cfg.RepoOwner, cfg.RepoName, fmt.Sprintf("refs/heads/%s", cfg.PRBranch))
-	if err != nil {
-		return fmt.Errorf("failed to get reference to branch: %w", err)
-	}
-
-	commit, _, err := client.Git.GetCommit(ctx, cfg.RepoOwner, cfg.RepoName, *ref.Object.SHA)
-	if err != nil {
-		return fmt.Errorf("failed to get commit: %w", err)
-	}
-
-	blob, _, err := client.Git.CreateBlob(ctx, cfg.RepoOwner, cfg.RepoName, &github.Blob{
-		Content:  github.String(alertRule),
-		Encoding: github.String("utf-8"),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create blob: %w", err)
-	}
-
-	entries := []*github.TreeEntry{
-		{
-			Path: github.String(repoPath),
-			Mode: github.String("100644"),
-			Type: github.String("blob"),
-			SHA:  blob.SHA,
-		},
-	}
-
-	tree, _, err := client.Git.CreateTree(ctx, cfg.RepoOwner, cfg.RepoName, *commit.Tree.SHA, entries)
-	if err != nil {
-		return fmt.Errorf("failed to create tree: %w", err)
-	}
-
-	alertType := suggestion.Type
-	newCommit, _, err := client.Git.CreateCommit(ctx, cfg.RepoOwner, cfg.RepoName, &github.Commit{
-		Message: github.String(fmt.Sprintf("Add %s alert rule for %s", alertType, suggestion.Name)),
-		Tree:    tree,
-		Parents: []*github.Commit{commit},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create commit: %w", err)
-	}
-
-	ref.Object.SHA = newCommit.SHA
-	_, _, err = client.Git.UpdateRef(ctx, cfg.RepoOwner, cfg.RepoName, ref, false)
-	if err != nil {
-		return fmt.Errorf("failed to update reference: %w", err)
-	}
-
-	fmt.Printf("Added %s alert rule for %s to PR branch\n", alertType, suggestion.Name)
-	return nil
-}
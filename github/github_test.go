@@ -0,0 +1,35 @@
+package github
+
+import (
+	"tracepr/config"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/v53/github"
+)
+
+func TestFetchPRDetailsUsesCallerContext(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"number": 5, "head": {"ref": "feature", "sha": "head"}}`))
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := FetchPRDetails(ctx, client, config.Config{RepoOwner: "o", RepoName: "r", PRNumber: 5})
+	if err == nil {
+		t.Fatal("FetchPRDetails succeeded with a cancelled context")
+	}
+	if requests.Load() != 0 {
+		t.Errorf("made %d requests with a cancelled context", requests.Load())
+	}
+}
@@ -0,0 +1,127 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+)
+
+const (
+	// defaultPerPage is used when no page size is configured; 100 is the GitHub maximum
+	defaultPerPage = 100
+	// maxRateLimitWait bounds how long a listing will sleep for the rate limit to reset
+	maxRateLimitWait = 15 * time.Minute
+	// defaultSecondaryWait is used when a secondary rate limit response carries no Retry-After
+	defaultSecondaryWait = time.Minute
+	// maxRateLimitRetries is how many times a single page is retried after a rate limit error
+	maxRateLimitRetries = 3
+)
+
+// PageFunc fetches a single page of a GitHub list endpoint
+type PageFunc[T any] func(ctx context.Context, opts github.ListOptions) ([]T, *github.Response, error)
+
+// Paginate iterates over every item of a list endpoint, following the Link headers page by page.
+// When the rate limit is exhausted it waits for the reset (or Retry-After) before continuing.
+func Paginate[T any](ctx context.Context, perPage int, fetch PageFunc[T]) iter.Seq2[T, error] {
+	if perPage <= 0 || perPage > defaultPerPage {
+		perPage = defaultPerPage
+	}
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		opts := github.ListOptions{Page: 1, PerPage: perPage}
+		retries := 0
+		for {
+			items, resp, err := fetch(ctx, opts)
+			if err != nil {
+				wait, retry := rateLimitWait(err)
+				if !retry || retries >= maxRateLimitRetries {
+					yield(zero, err)
+					return
+				}
+				log.Printf("GitHub rate limit hit, waiting %s before retrying page %d", wait.Round(time.Second), opts.Page)
+				if err := sleep(ctx, wait); err != nil {
+					yield(zero, err)
+					return
+				}
+				retries++
+				continue
+			}
+			retries = 0
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if resp == nil || resp.NextPage == 0 {
+				return
+			}
+			opts.Page = resp.NextPage
+
+			// Wait for the reset up front instead of letting the next request fail
+			if resp.Rate.Limit > 0 && resp.Rate.Remaining == 0 {
+				wait := time.Until(resp.Rate.Reset.Time)
+				log.Printf("GitHub rate limit exhausted, waiting %s for reset", wait.Round(time.Second))
+				if err := sleep(ctx, wait); err != nil {
+					yield(zero, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// ListAll collects every item of a list endpoint into a slice
+func ListAll[T any](ctx context.Context, perPage int, fetch PageFunc[T]) ([]T, error) {
+	var all []T
+	for item, err := range Paginate(ctx, perPage, fetch) {
+		if err != nil {
+			return all, err
+		}
+		all = append(all, item)
+	}
+	return all, nil
+}
+
+// rateLimitWait reports how long to wait before retrying a request rejected by a rate limit
+func rateLimitWait(err error) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return time.Until(rateErr.Rate.Reset.Time) + time.Second, true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return defaultSecondaryWait, true
+	}
+
+	return 0, false
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return fmt.Errorf("rate limit resets in %s, which is longer than the %s this command will wait", wait.Round(time.Second), maxRateLimitWait)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -2,30 +2,43 @@ package github
 
 import (
 	"tracepr/config"
-	"bytes"
+	"tracepr/vcs"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/google/go-github/v53/github"
-	"golang.org/x/oauth2"
 )
 
-// CreatePRComments handles inline comments and summary posting
-func CreateObservabilityPRComments(suggestions []config.FileSuggestion, prDetails map[string]interface{}, configStruct config.Config, summary string) error {
-	log.Printf("Creating observability PR comments for PR #%d", configStruct.PRNumber)
-	ctx := context.Background()
-	client := github.NewClient(nil)
+// draftComment converts a line comment into the form accepted by the create review API
+func draftComment(c vcs.ReviewComment) *github.DraftReviewComment {
+	draft := &github.DraftReviewComment{
+		Path: github.String(c.Path),
+		Body: github.String(vcs.WithMarker(c.Body, vcs.MarkerSuggestion, c.Key)),
+		Line: github.Int(c.Line),
+		Side: github.String("RIGHT"),
+	}
+	if c.StartLine > 0 {
+		draft.StartLine = github.Int(c.StartLine)
+		draft.StartSide = github.String("RIGHT")
+	}
+	return draft
+}
 
-	if configStruct.GithubToken != "" {
-		log.Printf("Configuring GitHub client with authentication token")
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: configStruct.GithubToken})
-		tc := oauth2.NewClient(ctx, ts)
-		client = github.NewClient(tc)
+// reviewEvent requests changes when any suggestion is high severity and otherwise just comments
+func reviewEvent(suggestions []config.FileSuggestion) string {
+	if vcs.HasHighSeverity(suggestions) {
+		return "REQUEST_CHANGES"
 	}
+	return "COMMENT"
+}
+
+// CreateObservabilityPRComments posts the summary and every inline suggestion as a single PR review.
+// On re-runs the previous summary is edited in place, suggestions already posted at the same
+// file and line are skipped, and earlier suggestions that no longer apply are marked as outdated.
+func CreateObservabilityPRComments(ctx context.Context, client *github.Client, suggestions []config.FileSuggestion, prDetails map[string]interface{}, configStruct config.Config, summary string) error {
+	log.Printf("Creating observability PR review for PR #%d", configStruct.PRNumber)
 
 	// Fetch PR to get HEAD SHA
 	log.Printf("Fetching PR to get HEAD SHA")
@@ -41,243 +54,193 @@ func CreateObservabilityPRComments(suggestions []config.FileSuggestion, prDetail
 		return fmt.Errorf("could not get HEAD SHA from PR")
 	}
 
-	// Post the summary comment
-	log.Printf("Posting summary comment")
-	if err := PostSummaryComment(configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, summary, configStruct.GithubToken); err != nil {
+	// Find what previous runs already posted
+	log.Printf("Loading previous TracePR review comments")
+	existingComments, err := ListAll(ctx, configStruct.GithubPerPage, func(ctx context.Context, opts github.ListOptions) ([]*github.PullRequestComment, *github.Response, error) {
+		return client.PullRequests.ListComments(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, &github.PullRequestListCommentsOptions{ListOptions: opts})
+	})
+	if err != nil {
+		log.Printf("Error listing PR review comments: %v", err)
+		return fmt.Errorf("error listing PR review comments: %v", err)
+	}
+	posted := make([]vcs.Comment, 0, len(existingComments))
+	for _, comment := range existingComments {
+		posted = append(posted, vcs.Comment{ID: comment.GetID(), Author: comment.GetUser().GetLogin(), Body: comment.GetBody()})
+	}
+	self, err := botLogin(ctx, client, configStruct)
+	if err != nil {
 		return err
 	}
 
-	// Post inline comments
-	log.Printf("Processing %d inline suggestions", len(suggestions))
-	for _, suggestion := range suggestions {
-		log.Printf("Creating inline comment for file %s at line %s", suggestion.FileName, suggestion.LineNum)
-		commentBody := fmt.Sprintf("```suggestion\n%s\n```", suggestion.Content)
-
-		lineNum, err := strconv.Atoi(suggestion.LineNum)
-		if err != nil {
-			log.Printf("Invalid line number %s: %v", suggestion.LineNum, err)
-			return fmt.Errorf("invalid line number: %v", err)
-		}
-
-		payload := map[string]interface{}{
-			"commit_id": headSHA,
-			"path":      suggestion.FileName,
-			"line":      lineNum,
-			"body":      commentBody,
-		}
-
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			log.Printf("Error marshaling comment payload: %v", err)
-			return fmt.Errorf("error marshaling comment payload: %v", err)
-		}
-
-		url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d/comments", configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber)
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Error creating HTTP request: %v", err)
-			return fmt.Errorf("error creating HTTP request: %v", err)
-		}
-
-		req.Header.Set("Accept", "application/vnd.github.comfort-fade-preview+json")
-		req.Header.Set("Authorization", fmt.Sprintf("token %s", configStruct.GithubToken))
-		req.Header.Set("Content-Type", "application/json")
+	plan := vcs.PlanReview(suggestions, prDetails, summary, posted, self)
 
-		httpClient := &http.Client{}
-		resp, err := httpClient.Do(req)
+	// Mark suggestions from earlier runs that the model no longer makes
+	for _, comment := range plan.Stale {
+		_, key, _ := vcs.ParseMarker(comment.Body)
+		log.Printf("Marking stale suggestion %s as outdated", key)
+		_, _, err := client.PullRequests.EditComment(ctx, configStruct.RepoOwner, configStruct.RepoName, comment.ID, &github.PullRequestComment{
+			Body: github.String(vcs.OutdatedBody(comment.Body, key)),
+		})
 		if err != nil {
-			log.Printf("Error posting PR comment: %v", err)
-			return fmt.Errorf("error posting PR comment: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			log.Printf("GitHub API error (%d): %s", resp.StatusCode, string(jsonData))
-			return fmt.Errorf("GitHub API error (%d): %s", resp.StatusCode, string(jsonData))
+			log.Printf("Error marking suggestion %s as outdated: %v", key, err)
 		}
 	}
 
-	log.Printf("Successfully created all observability PR comments")
-	return nil
-}
-
-func CreateDashboardPRComments(suggestions []config.DashboardSuggestion, prDetails map[string]interface{}, configStruct config.Config, summary string) error {
-	log.Printf("Creating dashboard PR comments for PR #%d", configStruct.PRNumber)
-	ctx := context.Background()
-	client := github.NewClient(nil)
-
-	if configStruct.GithubToken != "" {
-		log.Printf("Configuring GitHub client with authentication token")
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: configStruct.GithubToken})
-		tc := oauth2.NewClient(ctx, ts)
-		client = github.NewClient(tc)
-	}
+	event := reviewEvent(suggestions)
+	lineComments := plan.LineComments
 
-	// Post the summary comment first
-	log.Printf("Posting summary comment")
-	if err := PostSummaryComment(configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, summary, configStruct.GithubToken); err != nil {
+	// Edit the summary of the previous TracePR review rather than repeating it
+	reviews, err := listOwnReviews(ctx, client, configStruct, self)
+	if err != nil {
 		return err
 	}
-
-	// Create a detailed comment for each dashboard suggestion
-	log.Printf("Processing %d dashboard suggestions", len(suggestions))
-	for _, suggestion := range suggestions {
-		log.Printf("Creating comment for dashboard suggestion: %s", suggestion.Name)
-		// Format a readable dashboard suggestion comment
-		commentBody := fmt.Sprintf("## Dashboard Suggestion: %s\n\n", suggestion.Name)
-		commentBody += fmt.Sprintf("**Type:** %s\n", suggestion.Type)
-		commentBody += fmt.Sprintf("**Priority:** %s\n\n", suggestion.Priority)
-
-		commentBody += "### Queries\n```json\n" + suggestion.Queries + "\n```\n\n"
-		commentBody += "### Panels\n```json\n" + suggestion.Panels + "\n```\n\n"
-		commentBody += "### Alerts\n```json\n" + suggestion.Alerts + "\n```\n\n"
-
-		// Add action buttons - these will be parsed by the GitHub action
-		commentBody += "<details>\n"
-		commentBody += "<summary>Click to create this dashboard</summary>\n\n"
-		commentBody += fmt.Sprintf("To create this dashboard, comment with:\n\n`tracepr dashboard --create  %s`\n\n", suggestion.Name)
-		commentBody += fmt.Sprintf("<!-- DASHBOARD_CREATE:%s:%s -->\n", suggestion.Type, suggestion.Name)
-		commentBody += "</details>\n"
-
-		// Post the comment
-		issueComment := &github.IssueComment{
-			Body: github.String(commentBody),
+	previousReview := findSummaryReview(reviews)
+	summaryBody := vcs.WithMarker(plan.Summary, vcs.MarkerSummary, "check")
+	body := summaryBody
+	if previousReview != nil {
+		log.Printf("Updating summary of previous TracePR review %d", previousReview.GetID())
+		if _, _, err := client.PullRequests.UpdateReview(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, previousReview.GetID(), summaryBody); err != nil {
+			log.Printf("Error updating previous review summary: %v", err)
+			return fmt.Errorf("error updating previous review summary: %v", err)
 		}
-
-		_, _, err := client.Issues.CreateComment(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, issueComment)
-		if err != nil {
-			log.Printf("Error posting dashboard comment: %v", err)
-			return fmt.Errorf("error posting dashboard comment: %v", err)
+	}
+	// Editing a review leaves its verdict in force, so earlier requests for changes are lifted
+	// once nothing high severity is left
+	if event != "REQUEST_CHANGES" {
+		dismissChangeRequests(ctx, client, configStruct, reviews)
+	}
+	if previousReview != nil {
+		// The previous review already carries the suggestions and any request for changes that
+		// still stands, so an empty follow-up review would only add noise
+		if len(lineComments) == 0 {
+			log.Printf("No new inline suggestions to post")
+			return nil
 		}
+		body = fmt.Sprintf("TracePR found %d new observability suggestions on %s.", len(lineComments), vcs.ShortSHA(headSHA))
 	}
 
-	// Add a comment for creating all dashboards at once
-	allDashboardsComment := "## Create All Dashboards\n\n"
-	allDashboardsComment += "To create all suggested dashboards, comment with:\n\n`tracepr dashboard --create-all`\n\n"
-
-	issueComment := &github.IssueComment{
-		Body: github.String(allDashboardsComment),
+	log.Printf("Submitting %s review with %d inline comments", event, len(lineComments))
+	err = submitReview(ctx, client, configStruct, headSHA, event, body, lineComments)
+	if err != nil && event != "COMMENT" {
+		// GitHub refuses REQUEST_CHANGES from the PR author's own token
+		log.Printf("Could not submit %s review, retrying as COMMENT: %v", event, err)
+		event = "COMMENT"
+		err = submitReview(ctx, client, configStruct, headSHA, event, body, lineComments)
+	}
+	if err != nil && len(lineComments) > 0 {
+		// A single rejected position fails the whole review, so fold everything into the body instead
+		log.Printf("Could not submit review with inline comments, retrying with all suggestions in the review body: %v", err)
+		body += vcs.FormatFileLevelComments(lineComments)
+		err = submitReview(ctx, client, configStruct, headSHA, event, body, nil)
 	}
-
-	_, _, err := client.Issues.CreateComment(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, issueComment)
 	if err != nil {
-		log.Printf("Error posting all dashboards comment: %v", err)
-		return fmt.Errorf("error posting all dashboards comment: %v", err)
+		log.Printf("Error submitting PR review: %v", err)
+		return fmt.Errorf("error submitting PR review: %v", err)
 	}
 
-	log.Printf("Successfully created all dashboard PR comments")
+	log.Printf("Successfully created observability PR review")
 	return nil
 }
 
-func CreateAlertsPRComments(suggestions []config.AlertSuggestion, prDetails map[string]interface{}, configStruct config.Config) error {
-	log.Printf("Creating alerts PR comments for PR #%d", configStruct.PRNumber)
-	ctx := context.Background()
-	client := github.NewClient(nil)
-
-	if configStruct.GithubToken != "" {
-		log.Printf("Configuring GitHub client with authentication token")
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: configStruct.GithubToken})
-		tc := oauth2.NewClient(ctx, ts)
-		client = github.NewClient(tc)
+// listOwnReviews returns the reviews self submitted on the PR. Reviews by anyone else are never
+// edited or dismissed, even when they quote a TracePR marker.
+func listOwnReviews(ctx context.Context, client *github.Client, configStruct config.Config, self string) ([]*github.PullRequestReview, error) {
+	reviews, err := ListAll(ctx, configStruct.GithubPerPage, func(ctx context.Context, opts github.ListOptions) ([]*github.PullRequestReview, *github.Response, error) {
+		return client.PullRequests.ListReviews(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, &opts)
+	})
+	if err != nil {
+		log.Printf("Error listing PR reviews: %v", err)
+		return nil, fmt.Errorf("error listing PR reviews: %v", err)
 	}
-
-	// Create a detailed comment for each alert suggestion
-	log.Printf("Processing %d alert suggestions", len(suggestions))
-	for _, suggestion := range suggestions {
-		log.Printf("Creating comment for alert suggestion: %s", suggestion.Name)
-		// Format a readable alert suggestion comment
-		commentBody := fmt.Sprintf("## Alert Suggestion: %s\n\n", suggestion.Name)
-		commentBody += fmt.Sprintf("**Type:** %s\n", suggestion.Type)
-		commentBody += fmt.Sprintf("**Priority:** %s\n\n", suggestion.Priority)
-
-		commentBody += "### Query\n```json\n" + suggestion.Query + "\n```\n\n"
-		commentBody += fmt.Sprintf("### Description\n%s\n\n", suggestion.Description)
-		commentBody += fmt.Sprintf("### Threshold\n%s\n\n", suggestion.Threshold)
-		commentBody += fmt.Sprintf("### Duration\n%s\n\n", suggestion.Duration)
-		commentBody += fmt.Sprintf("### Notification\n%s\n\n", suggestion.Notification)
-
-		if suggestion.RunbookLink != "" {
-			commentBody += fmt.Sprintf("### Runbook\n[Link to Runbook](%s)\n\n", suggestion.RunbookLink)
+	var own []*github.PullRequestReview
+	for _, review := range reviews {
+		if strings.EqualFold(review.GetUser().GetLogin(), self) {
+			own = append(own, review)
 		}
+	}
+	return own, nil
+}
 
-		// Add action buttons - these will be parsed by the GitHub action
-		commentBody += "<details>\n"
-		commentBody += "<summary>Click to create this alert</summary>\n\n"
-		commentBody += fmt.Sprintf("To create this alert, comment with:\n\n`tracepr alert --create %s`\n\n", suggestion.Name)
-		commentBody += fmt.Sprintf("<!-- ALERT_CREATE:%s:%s -->\n", suggestion.Type, suggestion.Name)
-		commentBody += "</details>\n"
-
-		// Post the comment
-		issueComment := &github.IssueComment{
-			Body: github.String(commentBody),
+// findSummaryReview returns the review whose body carries the TracePR summary marker, if any
+func findSummaryReview(reviews []*github.PullRequestReview) *github.PullRequestReview {
+	for _, review := range reviews {
+		if kind, key, ok := vcs.ParseMarker(review.GetBody()); ok && kind == vcs.MarkerSummary && key == "check" {
+			return review
 		}
+	}
+	return nil
+}
 
-		_, _, err := client.Issues.CreateComment(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, issueComment)
+// dismissChangeRequests dismisses the reviews that still request changes. Failures are only
+// logged, since branch protection may reserve dismissals for admins.
+func dismissChangeRequests(ctx context.Context, client *github.Client, configStruct config.Config, reviews []*github.PullRequestReview) {
+	for _, review := range reviews {
+		if review.GetState() != "CHANGES_REQUESTED" {
+			continue
+		}
+		log.Printf("Dismissing TracePR review %d that requested changes", review.GetID())
+		_, _, err := client.PullRequests.DismissReview(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, review.GetID(), &github.PullRequestReviewDismissalRequest{
+			Message: github.String("TracePR no longer finds high severity observability gaps."),
+		})
 		if err != nil {
-			log.Printf("Error posting alert comment: %v", err)
-			return fmt.Errorf("error posting alert comment: %v", err)
+			log.Printf("Error dismissing review %d: %v", review.GetID(), err)
 		}
 	}
+}
 
-	// Add a comment for creating all alerts at once
-	allAlertsComment := "## Create All Alerts\n\n"
-	allAlertsComment += "To create all suggested alerts, comment with:\n\n`tracepr alert --create-all`\n\n"
-
-	issueComment := &github.IssueComment{
-		Body: github.String(allAlertsComment),
-	}
-
-	_, _, err := client.Issues.CreateComment(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, issueComment)
-	if err != nil {
-		log.Printf("Error posting all alerts comment: %v", err)
-		return fmt.Errorf("error posting all alerts comment: %v", err)
+func submitReview(ctx context.Context, client *github.Client, configStruct config.Config, headSHA, event, body string, comments []vcs.ReviewComment) error {
+	drafts := make([]*github.DraftReviewComment, 0, len(comments))
+	for _, comment := range comments {
+		drafts = append(drafts, draftComment(comment))
 	}
 
-	log.Printf("Successfully created all alert PR comments")
-	return nil
+	_, _, err := client.PullRequests.CreateReview(ctx, configStruct.RepoOwner, configStruct.RepoName, configStruct.PRNumber, &github.PullRequestReviewRequest{
+		CommitID: github.String(headSHA),
+		Body:     github.String(body),
+		Event:    github.String(event),
+		Comments: drafts,
+	})
+	return err
 }
 
-// PostSummaryComment posts a summary comment to the PR's conversation
-func PostSummaryComment(owner, repo string, prNumber int, summary, token string) error {
-	if summary == "" {
-		log.Printf("No summary provided, skipping comment creation")
-		return nil // No summary to post
+// listIssueComments returns every conversation comment on the PR
+func listIssueComments(ctx context.Context, client *github.Client, cfg config.Config) ([]vcs.Comment, error) {
+	comments, err := ListAll(ctx, cfg.GithubPerPage, func(ctx context.Context, opts github.ListOptions) ([]*github.IssueComment, *github.Response, error) {
+		return client.Issues.ListComments(ctx, cfg.RepoOwner, cfg.RepoName, cfg.PRNumber, &github.IssueListCommentsOptions{ListOptions: opts})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing PR comments: %v", err)
 	}
 
-	log.Printf("Posting summary comment to PR #%d in %s/%s", prNumber, owner, repo)
-	summaryPayload := map[string]interface{}{
-		"body": summary,
+	result := make([]vcs.Comment, 0, len(comments))
+	for _, comment := range comments {
+		result = append(result, vcs.Comment{ID: comment.GetID(), Author: comment.GetUser().GetLogin(), Body: comment.GetBody()})
 	}
+	return result, nil
+}
 
-	summaryJSON, err := json.Marshal(summaryPayload)
+// SyncIssueComments makes the PR conversation match the desired comments, see vcs.SyncComments
+func SyncIssueComments(ctx context.Context, client *github.Client, cfg config.Config, desired []vcs.MarkedComment, staleKinds ...string) error {
+	existing, err := listIssueComments(ctx, client, cfg)
 	if err != nil {
-		log.Printf("Error marshaling summary payload: %v", err)
-		return fmt.Errorf("error marshaling summary payload: %v", err)
+		return err
 	}
-
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments", owner, repo, prNumber)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(summaryJSON))
+	self, err := botLogin(ctx, client, cfg)
 	if err != nil {
-		log.Printf("Error creating HTTP request for summary: %v", err)
-		return fmt.Errorf("error creating HTTP request for summary: %v", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Set("Content-Type", "application/json")
-
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error posting summary comment: %v", err)
-		return fmt.Errorf("error posting summary comment: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Printf("GitHub API error (%d) for summary comment", resp.StatusCode)
-		return fmt.Errorf("GitHub API error (%d) for summary comment", resp.StatusCode)
-	}
+	return vcs.SyncComments(existing, self, desired, func(body string) error {
+		_, _, err := client.Issues.CreateComment(ctx, cfg.RepoOwner, cfg.RepoName, cfg.PRNumber, &github.IssueComment{Body: github.String(body)})
+		return err
+	}, func(id int64, body string) error {
+		_, _, err := client.Issues.EditComment(ctx, cfg.RepoOwner, cfg.RepoName, id, &github.IssueComment{Body: github.String(body)})
+		return err
+	}, staleKinds...)
+}
 
-	log.Printf("Successfully posted summary comment")
-	return nil
+// ReactToComment adds a reaction such as "rocket" or "confused" to a PR conversation comment
+func ReactToComment(ctx context.Context, client *github.Client, cfg config.Config, commentID int64, reaction string) error {
+	_, _, err := client.Reactions.CreateIssueCommentReaction(ctx, cfg.RepoOwner, cfg.RepoName, commentID, reaction)
+	return err
 }
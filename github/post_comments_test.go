@@ -0,0 +1,140 @@
+package github
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// fakeReviews serves the PR endpoints CreateObservabilityPRComments uses and records the writes
+type fakeReviews struct {
+	mu      sync.Mutex
+	created   []string // bodies of new reviews
+	updated   []string // bodies sent to the existing summary review
+	dismissed []string // paths of dismissed reviews
+}
+
+func newFakeReviews(t *testing.T, posted []map[string]interface{}) (*fakeReviews, *github.Client) {
+	f := &fakeReviews{}
+	respond := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]string{"login": "tracepr"})
+	})
+	mux.HandleFunc("/repos/o/r/pulls/5", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]interface{}{"number": 5, "head": map[string]string{"sha": "head"}})
+	})
+	mux.HandleFunc("/repos/o/r/pulls/5/comments", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, posted)
+	})
+	mux.HandleFunc("/repos/o/r/pulls/5/reviews", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			f.mu.Lock()
+			f.created = append(f.created, string(body))
+			f.mu.Unlock()
+			respond(w, map[string]int{"id": 2})
+			return
+		}
+		respond(w, []map[string]interface{}{
+			// Editing another user's review is refused, and only TracePR's own is routed
+			{"id": 7, "state": "CHANGES_REQUESTED", "user": map[string]string{"login": "dev"}, "body": "> Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+			{"id": 1, "state": "CHANGES_REQUESTED", "user": map[string]string{"login": "tracepr"}, "body": "Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+		})
+	})
+	mux.HandleFunc("/repos/o/r/pulls/5/reviews/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasSuffix(r.URL.Path, "/dismissals") {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.dismissed = append(f.dismissed, r.URL.Path)
+		f.mu.Unlock()
+		respond(w, map[string]interface{}{"id": 1, "state": "DISMISSED"})
+	})
+	mux.HandleFunc("/repos/o/r/pulls/comments/", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]interface{}{})
+	})
+	mux.HandleFunc("/repos/o/r/pulls/5/reviews/1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.updated = append(f.updated, string(body))
+		f.mu.Unlock()
+		respond(w, map[string]int{"id": 1})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return f, client
+}
+
+func TestCreateObservabilityPRCommentsRerun(t *testing.T) {
+	cfg := config.Config{RepoOwner: "o", RepoName: "r", PRNumber: 5, ClaudeModel: "claude-3-5-sonnet"}
+	prDetails := map[string]interface{}{"files": []map[string]interface{}{
+		{"filename": "main.go", "patch": "@@ -1,2 +1,3 @@\n a\n+b\n c"},
+	}}
+	posted := []map[string]interface{}{
+		{"id": 12, "user": map[string]string{"login": "tracepr"}, "body": "```suggestion\nb2\n```\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:2")},
+		// A quoted marker from someone else doesn't count as posted
+		{"id": 13, "user": map[string]string{"login": "dev"}, "body": "> c2\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:3")},
+	}
+
+	// The previous review requested changes, which only still stands while something is high severity
+	tests := []struct {
+		name          string
+		suggestions   []config.FileSuggestion
+		wantCreated   int
+		wantDismissed bool
+	}{
+		{"nothing new", []config.FileSuggestion{{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "low"}}, 0, true},
+		// Requesting changes again must not submit an empty review either
+		{"nothing new at high severity", []config.FileSuggestion{{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "high"}}, 0, false},
+		{"one new suggestion", []config.FileSuggestion{
+			{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "low"},
+			{FileName: "main.go", LineNum: "3", Content: "c2", Severity: "low"},
+		}, 1, true},
+		{"fixed and approved", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, client := newFakeReviews(t, posted)
+			if err := CreateObservabilityPRComments(context.Background(), client, tt.suggestions, prDetails, cfg, "Gaps"); err != nil {
+				t.Fatalf("CreateObservabilityPRComments: %v", err)
+			}
+
+			if len(f.updated) != 1 || !strings.Contains(f.updated[0], "Gaps") {
+				t.Errorf("summary updates = %v, want the existing summary edited once", f.updated)
+			}
+			if len(f.created) != tt.wantCreated {
+				t.Fatalf("submitted %d reviews, want %d: %v", len(f.created), tt.wantCreated, f.created)
+			}
+			if tt.wantCreated > 0 && !strings.Contains(f.created[0], "1 new observability suggestions") {
+				t.Errorf("new review body = %s", f.created[0])
+			}
+			wantDismissed := []string(nil)
+			if tt.wantDismissed {
+				wantDismissed = []string{"/repos/o/r/pulls/5/reviews/1/dismissals"}
+			}
+			if !reflect.DeepEqual(f.dismissed, wantDismissed) {
+				t.Errorf("dismissed %v, want %v", f.dismissed, wantDismissed)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package gitlab
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// perPage is the page size for list requests; 100 is the GitLab maximum
+const perPage = 100
+
+// Client is a minimal GitLab REST (v4) client for the merge request endpoints TracePR uses
+type Client struct {
+	*vcs.APIClient
+	Project string // namespace/project path
+}
+
+// NewClient returns a client for the project RepoOwner/RepoName on cfg.GitLabBaseURL
+func NewClient(cfg config.Config) *Client {
+	return &Client{
+		APIClient: vcs.NewAPIClient("GitLab", cfg.GitLabBaseURL, func(req *http.Request) {
+			req.Header.Set("PRIVATE-TOKEN", cfg.GitLabToken)
+		}),
+		Project: cfg.RepoOwner + "/" + cfg.RepoName,
+	}
+}
+
+// projectPath returns the URL prefix for the project's endpoints
+func (c *Client) projectPath() string {
+	return "/projects/" + url.PathEscape(c.Project)
+}
+
+// listAll fetches every page of a GitLab list endpoint, following the X-Next-Page header
+func listAll[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("per_page", strconv.Itoa(perPage))
+
+	var all []T
+	page := "1"
+	for page != "" {
+		query.Set("page", page)
+		var items []T
+		resp, err := c.Do(ctx, http.MethodGet, path, query, nil, &items)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, items...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return all, nil
+}
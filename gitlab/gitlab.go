@@ -0,0 +1,176 @@
+package gitlab
+
+import (
+	"tracepr/config"
+	"tracepr/llm"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mergeRequest is the subset of the GitLab merge request resource TracePR reads
+type mergeRequest struct {
+	IID          int    `json:"iid"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	CreatedAt    string `json:"created_at"`
+	SourceBranch string `json:"source_branch"`
+	Author       struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	DiffRefs struct {
+		BaseSHA  string `json:"base_sha"`
+		StartSHA string `json:"start_sha"`
+		HeadSHA  string `json:"head_sha"`
+	} `json:"diff_refs"`
+}
+
+// mergeRequestDiff is one changed file of a merge request
+type mergeRequestDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	Diff        string `json:"diff"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+func (c *Client) mergeRequestPath(iid int) string {
+	return fmt.Sprintf("%s/merge_requests/%d", c.projectPath(), iid)
+}
+
+func (c *Client) getMergeRequest(ctx context.Context, iid int) (*mergeRequest, error) {
+	var mr mergeRequest
+	if _, err := c.Do(ctx, http.MethodGet, c.mergeRequestPath(iid), nil, nil, &mr); err != nil {
+		return nil, err
+	}
+	return &mr, nil
+}
+
+// FetchMRDetails builds the same prDetails map as github.FetchPRDetails for a GitLab merge request.
+// cfg.PRNumber is the merge request IID.
+func FetchMRDetails(ctx context.Context, client *Client, cfg config.Config) (config.Config, map[string]interface{}, error) {
+	log.Printf("Fetching MR details for !%d in %s", cfg.PRNumber, client.Project)
+	result := make(map[string]interface{})
+
+	mr, err := client.getMergeRequest(ctx, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching MR details: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching MR details: %v", err)
+	}
+
+	result["title"] = mr.Title
+	result["description"] = mr.Description
+	result["author"] = mr.Author.Username
+	result["created_at"] = mr.CreatedAt
+	cfg.PRBranch = mr.SourceBranch
+	cfg.HeadSHA = mr.DiffRefs.HeadSHA
+	log.Println("MR branch:", cfg.PRBranch)
+
+	log.Printf("Fetching commits for MR !%d", cfg.PRNumber)
+	commits, err := listAll[struct {
+		ID string `json:"id"`
+	}](ctx, client, client.mergeRequestPath(cfg.PRNumber)+"/commits", nil)
+	if err != nil {
+		log.Printf("Error fetching MR commits: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching MR commits: %v", err)
+	}
+
+	log.Printf("Fetching diffs for MR !%d", cfg.PRNumber)
+	diffs, err := listAll[mergeRequestDiff](ctx, client, client.mergeRequestPath(cfg.PRNumber)+"/diffs", nil)
+	if err != nil {
+		log.Printf("Error fetching MR diffs: %v", err)
+		return cfg, nil, fmt.Errorf("error fetching MR diffs: %v", err)
+	}
+
+	fileDetails := []map[string]interface{}{}
+	log.Printf("Processing %d files from MR", len(diffs))
+	for _, diff := range diffs {
+		additions, deletions := countChanges(diff.Diff)
+		fileDetail := map[string]interface{}{
+			"filename":  diff.NewPath,
+			"status":    diffStatus(diff),
+			"additions": additions,
+			"deletions": deletions,
+			"patch":     diff.Diff,
+		}
+		if diff.RenamedFile {
+			fileDetail["previous_filename"] = diff.OldPath
+		}
+		fileDetails = append(fileDetails, fileDetail)
+	}
+
+	llm.ApplyDiffBudget(result, fileDetails, cfg)
+	result["commits"] = len(commits)
+
+	log.Printf("Successfully fetched MR details with %d files and %d commits", len(result["files"].([]map[string]interface{})), len(commits))
+	return cfg, result, nil
+}
+
+// diffStatus maps GitLab's file flags onto the status names GitHub uses
+func diffStatus(diff mergeRequestDiff) string {
+	switch {
+	case diff.NewFile:
+		return "added"
+	case diff.DeletedFile:
+		return "removed"
+	case diff.RenamedFile:
+		return "renamed"
+	default:
+		return "modified"
+	}
+}
+
+// countChanges counts the added and removed lines of a hunk-only diff
+func countChanges(diff string) (additions, deletions int) {
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			additions++
+		case strings.HasPrefix(line, "-"):
+			deletions++
+		}
+	}
+	return additions, deletions
+}
+
+// CommitFiles writes and deletes files on the MR source branch in a single commit
+func CommitFiles(ctx context.Context, client *Client, cfg config.Config, changes []vcs.FileChange, message string) error {
+	changes = vcs.CollapseFileChanges(changes)
+	actions := make([]map[string]string, 0, len(changes))
+	for _, change := range changes {
+		if change.Delete {
+			actions = append(actions, map[string]string{"action": "delete", "file_path": change.Path})
+			continue
+		}
+
+		// The commits API needs to know whether the file is being created or replaced
+		action := "update"
+		query := url.Values{"ref": {cfg.PRBranch}}
+		resp, err := client.Do(ctx, http.MethodHead, client.projectPath()+"/repository/files/"+url.PathEscape(change.Path), query, nil, nil)
+		if err != nil {
+			if !vcs.IsNotFound(resp) {
+				return fmt.Errorf("failed to look up %s: %w", change.Path, err)
+			}
+			action = "create"
+		}
+		actions = append(actions, map[string]string{"action": action, "file_path": change.Path, "content": change.Content})
+	}
+
+	// GitLab applies the actions to the current branch head itself, so there is no race to retry
+	_, err := client.Do(ctx, http.MethodPost, client.projectPath()+"/repository/commits", nil, map[string]interface{}{
+		"branch":         cfg.PRBranch,
+		"commit_message": message,
+		"actions":        actions,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	log.Printf("Committed %d files to MR branch %s", len(changes), cfg.PRBranch)
+	return nil
+}
@@ -0,0 +1,283 @@
+package gitlab
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request is a call the fake GitLab received
+type request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+}
+
+// fakeGitLab answers the routed endpoints, keyed by "METHOD escaped-path", and 404s everything else
+type fakeGitLab struct {
+	t      *testing.T
+	routes map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	requests []request
+}
+
+func newFakeGitLab(t *testing.T) (*fakeGitLab, config.Config) {
+	f := &fakeGitLab{t: t, routes: make(map[string]http.HandlerFunc)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	cfg := config.Config{
+		GitLabBaseURL: server.URL + "/api/v4",
+		GitLabToken:   "secret",
+		RepoOwner:     "group",
+		RepoName:      "service",
+		PRNumber:      5,
+		ClaudeModel:   "claude-3-5-sonnet",
+	}
+	return f, cfg
+}
+
+func (f *fakeGitLab) handle(method, path string, handler http.HandlerFunc) {
+	f.routes[method+" /api/v4/projects/group%2Fservice"+path] = handler
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.mu.Lock()
+	f.requests = append(f.requests, request{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Body: string(body)})
+	f.mu.Unlock()
+
+	if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+		f.t.Errorf("%s %s was sent without the token", r.Method, r.URL.Path)
+	}
+	handler, ok := f.routes[r.Method+" "+r.URL.EscapedPath()]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+// sent returns the requests made with method to a path ending in suffix
+func (f *fakeGitLab) sent(method, suffix string) []request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matching []request
+	for _, req := range f.requests {
+		if req.Method == method && strings.HasSuffix(req.Path, suffix) {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func respondJSON(v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// paged serves one page per "page" query parameter and links them with X-Next-Page
+func paged(pages ...interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < len(pages) {
+			w.Header().Set("X-Next-Page", fmt.Sprint(page+1))
+		}
+		respondJSON(pages[page-1])(w, r)
+	}
+}
+
+func mergeRequestJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"iid":           5,
+		"title":         "Add checkout metrics",
+		"description":   "Counts checkouts",
+		"source_branch": "feature/metrics",
+		"author":        map[string]string{"username": "dev"},
+		"diff_refs":     map[string]string{"base_sha": "base", "start_sha": "start", "head_sha": "head"},
+	}
+}
+
+func TestFetchMRDetails(t *testing.T) {
+	f, cfg := newFakeGitLab(t)
+	f.handle("GET", "/merge_requests/5", respondJSON(mergeRequestJSON()))
+	f.handle("GET", "/merge_requests/5/commits", paged(
+		[]map[string]string{{"id": "c1"}, {"id": "c2"}},
+		[]map[string]string{{"id": "c3"}},
+	))
+	f.handle("GET", "/merge_requests/5/diffs", paged(
+		[]map[string]interface{}{
+			{"old_path": "main.go", "new_path": "main.go", "diff": "@@ -1,2 +1,3 @@\n a\n+b\n c\n"},
+			{"old_path": "old.go", "new_path": "new.go", "renamed_file": true, "diff": "@@ -1 +1 @@\n-x\n+y\n"},
+		},
+		[]map[string]interface{}{
+			{"old_path": "gone.go", "new_path": "gone.go", "deleted_file": true, "diff": "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		},
+	))
+
+	cfg, details, err := FetchMRDetails(context.Background(), NewClient(cfg), cfg)
+	if err != nil {
+		t.Fatalf("FetchMRDetails: %v", err)
+	}
+
+	if cfg.PRBranch != "feature/metrics" || cfg.HeadSHA != "head" {
+		t.Errorf("got branch %q and head %q, want feature/metrics and head", cfg.PRBranch, cfg.HeadSHA)
+	}
+	if details["title"] != "Add checkout metrics" || details["author"] != "dev" {
+		t.Errorf("unexpected title %v or author %v", details["title"], details["author"])
+	}
+	if details["commits"] != 3 {
+		t.Errorf("commits = %v, want 3 across both pages", details["commits"])
+	}
+
+	files := details["files"].([]map[string]interface{})
+	byName := make(map[string]map[string]interface{})
+	for _, file := range files {
+		byName[file["filename"].(string)] = file
+	}
+	if len(byName) != 3 {
+		t.Fatalf("got %d files, want 3 across both pages: %v", len(byName), files)
+	}
+	if file := byName["main.go"]; file["status"] != "modified" || file["additions"] != 1 || file["deletions"] != 0 {
+		t.Errorf("main.go = %v", file)
+	}
+	if file := byName["new.go"]; file["status"] != "renamed" || file["previous_filename"] != "old.go" {
+		t.Errorf("new.go = %v", file)
+	}
+	if file := byName["gone.go"]; file["status"] != "removed" || file["deletions"] != 2 {
+		t.Errorf("gone.go = %v", file)
+	}
+	for _, req := range f.sent("GET", "/diffs") {
+		if !strings.Contains(req.Query, "per_page=100") {
+			t.Errorf("diffs requested without per_page: %s", req.Query)
+		}
+	}
+}
+
+func TestCreateObservabilityMRComments(t *testing.T) {
+	f, cfg := newFakeGitLab(t)
+	f.handle("GET", "/merge_requests/5", respondJSON(mergeRequestJSON()))
+	f.routes["GET /api/v4/user"] = respondJSON(map[string]string{"username": "tracepr"})
+	bot := map[string]string{"username": "tracepr"}
+	dev := map[string]string{"username": "dev"}
+	f.handle("GET", "/merge_requests/5/notes", paged(
+		[]map[string]interface{}{
+			// A reviewer quoting TracePR's comments must not have their notes edited
+			{"id": 9, "author": dev, "body": "> Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+			{"id": 10, "author": bot, "body": "Old summary\n\n" + vcs.Marker(vcs.MarkerSummary, "check")},
+			{"id": 11, "author": bot, "type": "DiffNote", "body": "```suggestion\nold\n```\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:9")},
+		},
+		[]map[string]interface{}{
+			{"id": 12, "author": bot, "type": "DiffNote", "body": "```suggestion\nc2\n```\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:3")},
+			{"id": 13, "author": bot, "system": true, "body": "added 1 commit"},
+			{"id": 14, "author": dev, "type": "DiffNote", "body": "> old\n\n" + vcs.Marker(vcs.MarkerSuggestion, "main.go:8")},
+		},
+	))
+	ok := respondJSON(map[string]interface{}{})
+	f.handle("POST", "/merge_requests/5/discussions", ok)
+	f.handle("PUT", "/merge_requests/5/notes/10", ok)
+	f.handle("PUT", "/merge_requests/5/notes/11", ok)
+
+	prDetails := map[string]interface{}{"files": []map[string]interface{}{
+		{"filename": "main.go", "patch": "@@ -1,2 +1,3 @@\n a\n+b\n c"},
+	}}
+	suggestions := []config.FileSuggestion{
+		{FileName: "main.go", LineNum: "2", Content: "b2", Severity: "medium"},
+		{FileName: "main.go", LineNum: "3", Content: "c2", Severity: "low"},
+	}
+	if err := CreateObservabilityMRComments(context.Background(), NewClient(cfg), suggestions, prDetails, cfg, "Two gaps"); err != nil {
+		t.Fatalf("CreateObservabilityMRComments: %v", err)
+	}
+
+	// Only the suggestion that wasn't posted before gets a new discussion
+	discussions := f.sent("POST", "/discussions")
+	if len(discussions) != 1 {
+		t.Fatalf("posted %d discussions, want 1", len(discussions))
+	}
+	var discussion struct {
+		Body     string                 `json:"body"`
+		Position map[string]interface{} `json:"position"`
+	}
+	json.Unmarshal([]byte(discussions[0].Body), &discussion)
+	if discussion.Position["new_path"] != "main.go" || discussion.Position["new_line"] != float64(2) || discussion.Position["head_sha"] != "head" {
+		t.Errorf("unexpected discussion position %v", discussion.Position)
+	}
+	if _, ok := discussion.Position["old_line"]; ok {
+		t.Errorf("added line was given an old_line: %v", discussion.Position)
+	}
+	if !strings.Contains(discussion.Body, vcs.Marker(vcs.MarkerSuggestion, "main.go:2")) {
+		t.Errorf("discussion body lacks its marker: %q", discussion.Body)
+	}
+
+	// The stale suggestion is marked outdated and the summary is edited rather than posted again
+	if stale := f.sent("PUT", "/notes/11"); len(stale) != 1 || !strings.Contains(stale[0].Body, "Outdated") {
+		t.Errorf("stale suggestion edits = %v", stale)
+	}
+	if summary := f.sent("PUT", "/notes/10"); len(summary) != 1 || !strings.Contains(summary[0].Body, "Two gaps") {
+		t.Errorf("summary edits = %v", summary)
+	}
+	if created := f.sent("POST", "/notes"); len(created) != 0 {
+		t.Errorf("created %d notes, want the summary to be edited", len(created))
+	}
+	if edited := append(f.sent("PUT", "/notes/9"), f.sent("PUT", "/notes/14")...); len(edited) != 0 {
+		t.Errorf("edited another user's notes: %v", edited)
+	}
+}
+
+func TestProviderCommitFiles(t *testing.T) {
+	f, cfg := newFakeGitLab(t)
+	f.handle("GET", "/merge_requests/5", respondJSON(mergeRequestJSON()))
+	f.handle("HEAD", "/repository/files/rules%2Fa.yml", func(w http.ResponseWriter, r *http.Request) {
+		if ref := r.URL.Query().Get("ref"); ref != "feature/metrics" {
+			t.Errorf("looked up a.yml on %q, want the MR branch", ref)
+		}
+	})
+	f.handle("POST", "/repository/commits", respondJSON(map[string]string{"id": "new"}))
+
+	changes := []vcs.FileChange{
+		{Path: "rules/a.yml", Content: "first"},
+		{Path: "rules/b.yml", Content: "new rule"},
+		{Path: "rules/c.yml", Delete: true},
+		{Path: "rules/a.yml", Content: "second"},
+	}
+	// The provider looks up the MR branch itself when it hasn't been fetched
+	if err := NewProvider(cfg).CommitFiles(context.Background(), changes, "Add rules"); err != nil {
+		t.Fatalf("CommitFiles: %v", err)
+	}
+
+	commits := f.sent("POST", "/repository/commits")
+	if len(commits) != 1 {
+		t.Fatalf("created %d commits, want 1", len(commits))
+	}
+	var commit struct {
+		Branch  string              `json:"branch"`
+		Message string              `json:"commit_message"`
+		Actions []map[string]string `json:"actions"`
+	}
+	json.Unmarshal([]byte(commits[0].Body), &commit)
+	if commit.Branch != "feature/metrics" || commit.Message != "Add rules" {
+		t.Errorf("committed %q to %q", commit.Message, commit.Branch)
+	}
+	want := []map[string]string{
+		{"action": "update", "file_path": "rules/a.yml", "content": "second"},
+		{"action": "create", "file_path": "rules/b.yml", "content": "new rule"},
+		{"action": "delete", "file_path": "rules/c.yml"},
+	}
+	if fmt.Sprint(commit.Actions) != fmt.Sprint(want) {
+		t.Errorf("actions = %v, want %v", commit.Actions, want)
+	}
+}
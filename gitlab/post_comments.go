@@ -0,0 +1,181 @@
+package gitlab
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// note is a GitLab merge request note; inline review comments have type DiffNote
+type note struct {
+	ID     int64  `json:"id"`
+	Body   string `json:"body"`
+	Type   string `json:"type"`
+	System bool   `json:"system"`
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// botLogin returns the username TracePR posts as: the configured one or the owner of the token
+func botLogin(ctx context.Context, client *Client, cfg config.Config) (string, error) {
+	if cfg.BotLogin != "" {
+		return cfg.BotLogin, nil
+	}
+	var user struct {
+		Username string `json:"username"`
+	}
+	if _, err := client.Do(ctx, http.MethodGet, "/user", nil, nil, &user); err != nil {
+		log.Printf("Error fetching GitLab user: %v", err)
+		return "", fmt.Errorf("error fetching GitLab user: %v", err)
+	}
+	return user.Username, nil
+}
+
+// listNotes returns every note on the merge request, split into conversation comments and
+// inline diff comments
+func listNotes(ctx context.Context, client *Client, iid int) (comments, diffNotes []vcs.Comment, err error) {
+	notes, err := listAll[note](ctx, client, client.mergeRequestPath(iid)+"/notes", nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing MR notes: %v", err)
+	}
+
+	for _, n := range notes {
+		if n.System {
+			continue
+		}
+		comment := vcs.Comment{ID: n.ID, Author: n.Author.Username, Body: n.Body}
+		if n.Type == "DiffNote" {
+			diffNotes = append(diffNotes, comment)
+		} else {
+			comments = append(comments, comment)
+		}
+	}
+	return comments, diffNotes, nil
+}
+
+func createNote(ctx context.Context, client *Client, iid int, body string) error {
+	_, err := client.Do(ctx, http.MethodPost, client.mergeRequestPath(iid)+"/notes", nil, map[string]string{"body": body}, nil)
+	return err
+}
+
+func editNote(ctx context.Context, client *Client, iid int, id int64, body string) error {
+	path := fmt.Sprintf("%s/notes/%d", client.mergeRequestPath(iid), id)
+	_, err := client.Do(ctx, http.MethodPut, path, nil, map[string]string{"body": body}, nil)
+	return err
+}
+
+// createDiscussion starts an inline discussion on a line of the merge request diff
+func createDiscussion(ctx context.Context, client *Client, mr *mergeRequest, index *vcs.PatchIndex, oldPath string, comment vcs.ReviewComment) error {
+	position := map[string]interface{}{
+		"position_type": "text",
+		"base_sha":      mr.DiffRefs.BaseSHA,
+		"start_sha":     mr.DiffRefs.StartSHA,
+		"head_sha":      mr.DiffRefs.HeadSHA,
+		"old_path":      oldPath,
+		"new_path":      comment.Path,
+		"new_line":      comment.Line,
+	}
+	// Unchanged lines have to be addressed by their line on both sides
+	if index != nil {
+		if oldLine, ok := index.OldLine(comment.Line); ok {
+			position["old_line"] = oldLine
+		}
+	}
+
+	_, err := client.Do(ctx, http.MethodPost, client.mergeRequestPath(mr.IID)+"/discussions", nil, map[string]interface{}{
+		"body":     vcs.WithMarker(suggestionBody(comment), vcs.MarkerSuggestion, comment.Key),
+		"position": position,
+	}, nil)
+	return err
+}
+
+// suggestionBody converts a multi-line suggestion to GitLab's syntax, which anchors the
+// suggestion on its last line and states how many lines above it are replaced
+func suggestionBody(comment vcs.ReviewComment) string {
+	if comment.StartLine <= 0 || comment.StartLine >= comment.Line {
+		return comment.Body
+	}
+	return strings.Replace(comment.Body, "```suggestion\n", fmt.Sprintf("```suggestion:-%d+0\n", comment.Line-comment.StartLine), 1)
+}
+
+// CreateObservabilityMRComments posts every inline suggestion as a diff discussion and keeps a single
+// summary note up to date. Like the GitHub review, re-runs skip suggestions already posted at the
+// same file and line and mark earlier suggestions that no longer apply as outdated.
+func CreateObservabilityMRComments(ctx context.Context, client *Client, suggestions []config.FileSuggestion, prDetails map[string]interface{}, cfg config.Config, summary string) error {
+	log.Printf("Creating observability MR comments for MR !%d", cfg.PRNumber)
+
+	mr, err := client.getMergeRequest(ctx, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error fetching MR diff refs: %v", err)
+		return fmt.Errorf("error fetching MR diff refs: %v", err)
+	}
+
+	log.Printf("Loading previous TracePR MR comments")
+	comments, diffNotes, err := listNotes(ctx, client, cfg.PRNumber)
+	if err != nil {
+		log.Printf("Error listing MR notes: %v", err)
+		return err
+	}
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	plan := vcs.PlanReview(suggestions, prDetails, summary, diffNotes, self)
+
+	indexes := vcs.BuildPatchIndexes(prDetails)
+	oldPaths := make(map[string]string)
+	files, _ := prDetails["files"].([]map[string]interface{})
+	for _, file := range files {
+		filename, _ := file["filename"].(string)
+		oldPaths[filename] = filename
+		if previous, ok := file["previous_filename"].(string); ok && previous != "" {
+			oldPaths[filename] = previous
+		}
+	}
+
+	body := vcs.PostInlineComments(plan, func(comment vcs.ReviewComment) error {
+		return createDiscussion(ctx, client, mr, indexes[comment.Path], oldPaths[comment.Path], comment)
+	}, func(id int64, body string) error {
+		return editNote(ctx, client, cfg.PRNumber, id, body)
+	})
+	if vcs.HasHighSeverity(suggestions) {
+		body = "**TracePR found high severity observability gaps that should be fixed before merging.**\n\n" + body
+	}
+
+	err = vcs.SyncComments(comments, self, []vcs.MarkedComment{{Kind: vcs.MarkerSummary, Key: "check", Body: body}}, func(body string) error {
+		return createNote(ctx, client, cfg.PRNumber, body)
+	}, func(id int64, body string) error {
+		return editNote(ctx, client, cfg.PRNumber, id, body)
+	})
+	if err != nil {
+		log.Printf("Error posting MR summary: %v", err)
+		return fmt.Errorf("error posting MR summary: %v", err)
+	}
+
+	log.Printf("Successfully created observability MR comments")
+	return nil
+}
+
+// SyncNotes makes the MR conversation match the desired comments, see vcs.SyncComments
+func SyncNotes(ctx context.Context, client *Client, cfg config.Config, desired []vcs.MarkedComment, staleKinds ...string) error {
+	existing, _, err := listNotes(ctx, client, cfg.PRNumber)
+	if err != nil {
+		return err
+	}
+	self, err := botLogin(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	return vcs.SyncComments(existing, self, desired, func(body string) error {
+		return createNote(ctx, client, cfg.PRNumber, body)
+	}, func(id int64, body string) error {
+		return editNote(ctx, client, cfg.PRNumber, id, body)
+	}, staleKinds...)
+}
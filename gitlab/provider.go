@@ -0,0 +1,78 @@
+package gitlab
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Provider reviews GitLab merge requests
+type Provider struct {
+	cfg    config.Config
+	client *Client
+}
+
+// NewProvider returns a GitLab provider for the merge request in cfg
+func NewProvider(cfg config.Config) *Provider {
+	log.Printf("Initializing GitLab client for %s", cfg.GitLabBaseURL)
+	return &Provider{cfg: cfg, client: NewClient(cfg)}
+}
+
+func (p *Provider) Name() string {
+	return "gitlab"
+}
+
+func (p *Provider) FetchChangeDetails(ctx context.Context) (config.Config, map[string]interface{}, error) {
+	cfg, prDetails, err := FetchMRDetails(ctx, p.client, p.cfg)
+	if err != nil {
+		return cfg, nil, err
+	}
+	p.cfg = cfg
+	return cfg, prDetails, nil
+}
+
+func (p *Provider) PostReview(ctx context.Context, suggestions []config.FileSuggestion, prDetails map[string]interface{}, summary string) error {
+	if _, err := p.Identity(ctx); err != nil {
+		return err
+	}
+	return CreateObservabilityMRComments(ctx, p.client, suggestions, prDetails, p.cfg, summary)
+}
+
+func (p *Provider) SyncComments(ctx context.Context, desired []vcs.MarkedComment, staleKinds ...string) error {
+	if _, err := p.Identity(ctx); err != nil {
+		return err
+	}
+	return SyncNotes(ctx, p.client, p.cfg, desired, staleKinds...)
+}
+
+func (p *Provider) ListComments(ctx context.Context) ([]vcs.Comment, error) {
+	comments, _, err := listNotes(ctx, p.client, p.cfg.PRNumber)
+	return comments, err
+}
+
+// Identity returns the username TracePR posts as, looked up once per provider
+func (p *Provider) Identity(ctx context.Context) (string, error) {
+	if p.cfg.BotLogin == "" {
+		login, err := botLogin(ctx, p.client, p.cfg)
+		if err != nil {
+			return "", err
+		}
+		p.cfg.BotLogin = login
+	}
+	return p.cfg.BotLogin, nil
+}
+
+func (p *Provider) CommitFiles(ctx context.Context, changes []vcs.FileChange, message string) error {
+	if p.cfg.PRBranch == "" {
+		// The branch is only known once the MR has been fetched
+		log.Printf("Looking up source branch of MR !%d", p.cfg.PRNumber)
+		mr, err := p.client.getMergeRequest(ctx, p.cfg.PRNumber)
+		if err != nil {
+			return fmt.Errorf("error fetching MR source branch: %v", err)
+		}
+		p.cfg.PRBranch = mr.SourceBranch
+	}
+	return CommitFiles(ctx, p.client, p.cfg, changes, message)
+}
@@ -8,6 +8,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/spf13/cobra v1.9.1
 	github.com/spf13/viper v1.19.0
+	go.etcd.io/bbolt v1.3.10
 	golang.org/x/oauth2 v0.18.0
 )
 
@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"tracepr/config"
+	"tracepr/utils"
+	"log"
+	"strings"
+)
+
+const (
+	// maxDiffTokens caps the diff share of the prompt even for very large context windows
+	maxDiffTokens = 50000
+	// promptReserveTokens leaves room for the instructions, PR details and PRD around the diff
+	promptReserveTokens = 6000
+	// defaultContextWindow is assumed for models TracePR doesn't know about
+	defaultContextWindow = 8192
+)
+
+// contextWindows lists context sizes in tokens, matched by model name prefix (more specific prefixes first)
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"claude-", 200000},
+	{"gpt-4o", 128000},
+	{"gpt-4.1", 1000000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+}
+
+// ContextWindow returns the context size in tokens of the model selected by cfg
+func ContextWindow(cfg config.Config) int {
+	switch strings.ToLower(cfg.LLMProvider) {
+	case "ollama":
+		// Ollama truncates to its own num_ctx regardless of what the model supports
+		return defaultContextWindow
+	case "openai":
+		return lookupContextWindow(cfg.OpenAIModel)
+	default:
+		return lookupContextWindow(cfg.ClaudeModel)
+	}
+}
+
+func lookupContextWindow(model string) int {
+	model = strings.ToLower(model)
+	for _, window := range contextWindows {
+		if strings.HasPrefix(model, window.prefix) {
+			return window.tokens
+		}
+	}
+	return defaultContextWindow
+}
+
+// DiffTokenBudget returns how many tokens of diff may be sent to the model selected by cfg.
+// An explicit MaxDiffTokens overrides the budget derived from the model's context window.
+func DiffTokenBudget(cfg config.Config) int {
+	if cfg.MaxDiffTokens > 0 {
+		return cfg.MaxDiffTokens
+	}
+
+	budget := ContextWindow(cfg) - promptReserveTokens - maxOutputTokens
+	if budget > maxDiffTokens {
+		budget = maxDiffTokens
+	}
+	if budget < 1000 {
+		budget = 1000
+	}
+	return budget
+}
+
+// ApplyDiffBudget fits the changed files into the model's diff budget, split across up to
+// MaxChunks LLM calls, and records the "files", "file_chunks" and "skipped_files" entries of prDetails
+func ApplyDiffBudget(prDetails map[string]interface{}, files []map[string]interface{}, cfg config.Config) {
+	budget := DiffTokenBudget(cfg)
+	log.Printf("Fitting %d files into at most %d chunks of %d tokens", len(files), cfg.MaxChunks, budget)
+	kept, chunks, skipped := utils.ChunkFiles(files, budget, cfg.MaxDiffSize, cfg.MaxChunks)
+	for _, file := range skipped {
+		log.Printf("Not fully analyzing %s: %s", file.FileName, file.Reason)
+	}
+
+	prDetails["files"] = kept
+	prDetails["file_chunks"] = chunks
+	prDetails["skipped_files"] = skipped
+}
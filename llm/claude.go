@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"tracepr/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// ClaudeClient talks to the Anthropic Messages API
+type ClaudeClient struct {
+	APIKey     string
+	Model      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClaudeClient creates a Claude client from the model, URL and key in cfg
+func NewClaudeClient(cfg config.Config) *ClaudeClient {
+	return &ClaudeClient{
+		APIKey:     cfg.ClaudeAPIKey,
+		Model:      cfg.ClaudeModel,
+		BaseURL:    cfg.ClaudeBaseURL,
+		HTTPClient: &http.Client{},
+	}
+}
+
+func (c *ClaudeClient) Name() string {
+	return "claude/" + c.Model
+}
+
+func (c *ClaudeClient) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	claudeReq := config.ClaudeRequest{
+		Model:       c.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Messages:    req.Messages,
+		System:      req.System,
+	}
+
+	reqBody, err := json.Marshal(claudeReq)
+	if err != nil {
+		log.Printf("Error marshaling Claude request: %v", err)
+		return nil, fmt.Errorf("error marshaling Claude request: %v", err)
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		log.Printf("Error creating HTTP request: %v", err)
+		return nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", c.APIKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	// Execute request
+	log.Printf("Sending request to Claude API with model: %s", c.Model)
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		log.Printf("Error executing HTTP request: %v", err)
+		return nil, fmt.Errorf("error executing HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Received non-200 status code from Claude API: %d", resp.StatusCode)
+		return nil, fmt.Errorf("error from Claude API (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	log.Print("Successfully received response from Claude API")
+
+	// Parse Claude response
+	var claudeResp config.ClaudeResponse
+	if err := json.Unmarshal(body, &claudeResp); err != nil {
+		log.Printf("Error parsing Claude response: %v", err)
+		return nil, fmt.Errorf("error parsing Claude response: %v", err)
+	}
+
+	// Extract text from the array of content
+	var responseText string
+	for _, content := range claudeResp.Content {
+		if content.Type == "text" {
+			responseText += content.Text
+		}
+	}
+
+	return &CompletionResponse{
+		Text:         responseText,
+		InputTokens:  claudeResp.Usage.InputTokens,
+		OutputTokens: claudeResp.Usage.OutputTokens,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"tracepr/config"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Client is implemented by every LLM backend TracePR can talk to
+type Client interface {
+	// Complete sends a single completion request and returns the generated text
+	Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error)
+	// Name returns a human-readable identifier for the backend and model
+	Name() string
+}
+
+// CompletionRequest is the provider-agnostic request passed to a Client
+type CompletionRequest struct {
+	System      string
+	Messages    []config.Message
+	MaxTokens   int
+	Temperature float64
+}
+
+// CompletionResponse is the provider-agnostic response returned by a Client
+type CompletionResponse struct {
+	Text         string
+	InputTokens  int
+	OutputTokens int
+}
+
+// NewClient returns the LLM client selected by cfg.LLMProvider
+func NewClient(cfg config.Config) (Client, error) {
+	log.Printf("Initializing LLM client for provider: %s", cfg.LLMProvider)
+	switch strings.ToLower(cfg.LLMProvider) {
+	case "", "claude", "anthropic":
+		if cfg.ClaudeAPIKey == "" {
+			return nil, fmt.Errorf("claude API key is required for the claude provider")
+		}
+		return NewClaudeClient(cfg), nil
+	case "openai":
+		if cfg.OpenAIBaseURL == "" {
+			return nil, fmt.Errorf("openai base URL is required for the openai provider")
+		}
+		return NewOpenAIClient(cfg), nil
+	case "ollama":
+		if cfg.OllamaBaseURL == "" {
+			return nil, fmt.Errorf("ollama base URL is required for the ollama provider")
+		}
+		return NewOllamaClient(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported LLM provider: %s", cfg.LLMProvider)
+	}
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedClient returns its responses in order, failing with err once they run out
+type scriptedClient struct {
+	responses []string
+	err       error
+	calls     int
+}
+
+func (c *scriptedClient) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	c.calls++
+	if c.calls > len(c.responses) {
+		return nil, c.err
+	}
+	return &CompletionResponse{Text: c.responses[c.calls-1], InputTokens: 10, OutputTokens: 5}, nil
+}
+
+func (c *scriptedClient) Name() string {
+	return "scripted"
+}
+
+// partlyValid has one valid suggestion and one without a file
+const partlyValid = `{"verdict": "suggestions", "summary": "Two gaps", "suggestions": [
+	{"file": "main.go", "line": 3, "suggestion": "log.Println(err)"},
+	{"line": 4, "suggestion": "metrics.Inc()"}
+]}`
+
+func TestGetObservabilitySuggestionsKeepsBestResultWhenRepairCallFails(t *testing.T) {
+	unavailable := errors.New("503 overloaded")
+	client := &scriptedClient{responses: []string{partlyValid}, err: unavailable}
+
+	analysis, err := GetObservabilitySuggestions(context.Background(), client, "prompt", 2)
+	if !errors.Is(err, unavailable) {
+		t.Fatalf("err = %v, want the client error", err)
+	}
+	if strings.Contains(err.Error(), "error parsing suggestions") {
+		t.Errorf("client error was reported as a parse error: %v", err)
+	}
+	if analysis == nil || len(analysis.Suggestions) != 1 {
+		t.Fatalf("analysis = %+v, want the suggestion from the first attempt", analysis)
+	}
+	if len(analysis.Attempts) != 2 || analysis.Attempts[0].Accepted != 1 || analysis.Attempts[1].Error != unavailable.Error() {
+		t.Errorf("attempts = %+v, want the parsed attempt and the failed call", analysis.Attempts)
+	}
+}
+
+func TestGetObservabilitySuggestionsReturnsAttemptsWhenFirstCallFails(t *testing.T) {
+	unavailable := errors.New("connection refused")
+	analysis, err := GetObservabilitySuggestions(context.Background(), &scriptedClient{err: unavailable}, "prompt", 2)
+	if !errors.Is(err, unavailable) || strings.Contains(err.Error(), "error parsing suggestions") {
+		t.Fatalf("err = %v, want the unwrapped client error", err)
+	}
+	if analysis == nil || len(analysis.Attempts) != 1 {
+		t.Errorf("analysis = %+v, want the failed attempt", analysis)
+	}
+}
+
+func TestGetObservabilitySuggestionsWrapsParseErrors(t *testing.T) {
+	client := &scriptedClient{responses: []string{"not json", "still not json"}}
+	analysis, err := GetObservabilitySuggestions(context.Background(), client, "prompt", 1)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Attempts != 2 {
+		t.Fatalf("err = %v, want a ParseError after 2 attempts", err)
+	}
+	if !strings.HasPrefix(err.Error(), "error parsing suggestions") {
+		t.Errorf("parse error is not labelled: %v", err)
+	}
+	if analysis == nil || len(analysis.Attempts) != 2 {
+		t.Errorf("analysis = %+v, want both attempts", analysis)
+	}
+}
@@ -0,0 +1,188 @@
+package llm
+
+import (
+	"tracepr/config"
+	"tracepr/utils"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// SplitPRDetails returns one copy of prDetails per file chunk, each listing only that chunk's
+// files. PRs that fit into a single prompt come back unchanged.
+func SplitPRDetails(prDetails map[string]interface{}) []map[string]interface{} {
+	chunks, _ := prDetails["file_chunks"].([][]map[string]interface{})
+	if len(chunks) <= 1 {
+		return []map[string]interface{}{prDetails}
+	}
+
+	parts := make([]map[string]interface{}, len(chunks))
+	for i, files := range chunks {
+		part := make(map[string]interface{}, len(prDetails)+2)
+		for key, value := range prDetails {
+			part[key] = value
+		}
+		part["files"] = files
+		part["chunk_index"] = i + 1
+		part["chunk_count"] = len(chunks)
+		parts[i] = part
+	}
+	return parts
+}
+
+// AnalyzeObservability runs the observability prompt over every chunk of the PR and merges the results
+func AnalyzeObservability(ctx context.Context, client Client, prDetails map[string]interface{}, prdContent string, cfg config.Config) (*Analysis[config.FileSuggestion], error) {
+	return mapReduce(ctx, client, prDetails, cfg.LLMConcurrency, func(ctx context.Context, part map[string]interface{}) (*Analysis[config.FileSuggestion], error) {
+		return GetObservabilitySuggestions(ctx, client, BuildObservabilityPrompt(part, prdContent), cfg.MaxRepairAttempts)
+	}, func(s config.FileSuggestion) string {
+		return s.FileName + ":" + s.LineNum
+	})
+}
+
+// AnalyzeDashboards runs the dashboard prompt over every chunk of the PR and merges the results
+func AnalyzeDashboards(ctx context.Context, client Client, prDetails map[string]interface{}, prdContent string, cfg config.Config) (*Analysis[config.DashboardSuggestion], error) {
+	return mapReduce(ctx, client, prDetails, cfg.LLMConcurrency, func(ctx context.Context, part map[string]interface{}) (*Analysis[config.DashboardSuggestion], error) {
+		return GetDashboardSuggestions(ctx, client, BuildDashboardPrompt(part, prdContent), cfg.MaxRepairAttempts)
+	}, func(s config.DashboardSuggestion) string {
+		return strings.ToLower(s.Type) + ":" + utils.NormalizeFileName(s.Name)
+	})
+}
+
+// AnalyzeAlerts runs the alerts prompt over every chunk of the PR and merges the results
+func AnalyzeAlerts(ctx context.Context, client Client, prDetails map[string]interface{}, prdContent string, cfg config.Config) (*Analysis[config.AlertSuggestion], error) {
+	return mapReduce(ctx, client, prDetails, cfg.LLMConcurrency, func(ctx context.Context, part map[string]interface{}) (*Analysis[config.AlertSuggestion], error) {
+		return GetAlertSuggestions(ctx, client, BuildAlertsPrompt(part, prdContent), cfg.MaxRepairAttempts)
+	}, func(s config.AlertSuggestion) string {
+		return strings.ToLower(s.Type) + ":" + utils.NormalizeFileName(s.Name)
+	})
+}
+
+// mapReduce analyzes each chunk of the PR with at most concurrency calls in flight, then merges
+// the suggestions (dropping duplicates by key) and combines the chunk summaries into one.
+// Chunks that fail are left out and their files listed in the summary. It fails when no chunk
+// produced suggestions and any chunk failed, since approving would hide the unanalyzed files.
+func mapReduce[T any](ctx context.Context, client Client, prDetails map[string]interface{}, concurrency int, analyze func(context.Context, map[string]interface{}) (*Analysis[T], error), key func(T) string) (*Analysis[T], error) {
+	parts := SplitPRDetails(prDetails)
+	if len(parts) == 1 {
+		return analyze(ctx, parts[0])
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	log.Printf("Analyzing PR in %d chunks with up to %d in parallel", len(parts), concurrency)
+	results := make([]*Analysis[T], len(parts))
+	errs := make([]error, len(parts))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part map[string]interface{}) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			log.Printf("Analyzing chunk %d/%d", i+1, len(parts))
+			results[i], errs[i] = analyze(ctx, part)
+		}(i, part)
+	}
+	wg.Wait()
+
+	merged := &Analysis[T]{}
+	seen := make(map[string]bool)
+	var summaries, unanalyzed []string
+	var lastErr error
+	failed := 0
+	for i, result := range results {
+		if result != nil {
+			for _, attempt := range result.Attempts {
+				attempt.Chunk = i + 1
+				merged.Attempts = append(merged.Attempts, attempt)
+			}
+		}
+		if errs[i] != nil {
+			log.Printf("Chunk %d/%d failed: %v", i+1, len(parts), errs[i])
+			lastErr = errs[i]
+			failed++
+			unanalyzed = append(unanalyzed, chunkFileNames(parts[i])...)
+			continue
+		}
+
+		for _, suggestion := range result.Suggestions {
+			k := key(suggestion)
+			if seen[k] {
+				log.Printf("Dropping duplicate suggestion %s from chunk %d", k, i+1)
+				continue
+			}
+			seen[k] = true
+			merged.Suggestions = append(merged.Suggestions, suggestion)
+		}
+		if result.Summary != "" {
+			summaries = append(summaries, result.Summary)
+		}
+		merged.ResponseText += fmt.Sprintf("--- chunk %d/%d ---\n%s\n", i+1, len(parts), result.ResponseText)
+	}
+
+	merged.Summary = combineSummaries(ctx, client, summaries)
+	if failed > 0 {
+		merged.Summary = strings.TrimSpace(merged.Summary + fmt.Sprintf("\n\n_%d of %d parts of this PR could not be analyzed, so these files were not reviewed: %s._",
+			failed, len(parts), strings.Join(unanalyzed, ", ")))
+	}
+
+	// The unanalyzed parts may need changes too, so only approve when every part was analyzed
+	switch {
+	case len(merged.Suggestions) > 0:
+		merged.Verdict = utils.VerdictSuggestions
+	case failed > 0:
+		merged.Verdict = utils.VerdictError
+		return merged, fmt.Errorf("%d of %d chunks failed, last error: %v", failed, len(parts), lastErr)
+	default:
+		merged.Verdict = utils.VerdictApprove
+	}
+
+	log.Printf("Merged %d suggestions from %d chunks", len(merged.Suggestions), len(parts)-failed)
+	return merged, nil
+}
+
+// chunkFileNames returns the files of a chunk formatted for the summary
+func chunkFileNames(part map[string]interface{}) []string {
+	files, _ := part["files"].([]map[string]interface{})
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if filename, _ := file["filename"].(string); filename != "" {
+			names = append(names, "`"+filename+"`")
+		}
+	}
+	return names
+}
+
+// combineSummaries asks the LLM to merge the per-chunk summaries into one, falling back to
+// listing them when that call fails
+func combineSummaries(ctx context.Context, client Client, summaries []string) string {
+	if len(summaries) <= 1 {
+		return strings.Join(summaries, "")
+	}
+
+	var b strings.Builder
+	b.WriteString("The following summaries each describe a different part of the same pull request. ")
+	b.WriteString("Combine them into a single concise summary of the whole PR's observability gaps and suggestions. ")
+	b.WriteString("Respond with only the combined summary in Markdown.\n\n")
+	for i, summary := range summaries {
+		b.WriteString(fmt.Sprintf("## Part %d\n\n%s\n\n", i+1, summary))
+	}
+
+	resp, err := client.Complete(ctx, CompletionRequest{
+		System:      observabilitySystemPrompt,
+		Messages:    []config.Message{{Role: "user", Content: b.String()}},
+		MaxTokens:   1024,
+		Temperature: 0.3,
+	})
+	if err == nil && strings.TrimSpace(resp.Text) != "" {
+		return strings.TrimSpace(resp.Text)
+	}
+
+	log.Printf("Could not combine chunk summaries, listing them instead: %v", err)
+	return strings.Join(summaries, "\n\n")
+}
@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"tracepr/utils"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// chunkedPR is a PR split into two chunks of one file each
+func chunkedPR() map[string]interface{} {
+	return map[string]interface{}{"file_chunks": [][]map[string]interface{}{
+		{{"filename": "api.go"}},
+		{{"filename": "worker.go"}},
+	}}
+}
+
+// analyzeChunks returns a chunk analyzer that answers with results, keyed by the chunk's file
+func analyzeChunks(results map[string]*Analysis[string]) func(context.Context, map[string]interface{}) (*Analysis[string], error) {
+	return func(ctx context.Context, part map[string]interface{}) (*Analysis[string], error) {
+		file := part["files"].([]map[string]interface{})[0]["filename"].(string)
+		if result := results[file]; result != nil {
+			return result, nil
+		}
+		return &Analysis[string]{Attempts: []Attempt{{Number: 1, Error: "timeout"}}}, errors.New("timeout")
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	approve := &Analysis[string]{Verdict: utils.VerdictApprove, Summary: "Looks fine"}
+	suggest := &Analysis[string]{Verdict: utils.VerdictSuggestions, Suggestions: []string{"log the retry"}, Summary: "Missing logs"}
+
+	tests := []struct {
+		name        string
+		results     map[string]*Analysis[string]
+		wantVerdict string
+		wantErr     bool
+		wantMissing bool // whether the summary lists worker.go as unanalyzed
+	}{
+		{"every chunk approves", map[string]*Analysis[string]{"api.go": approve, "worker.go": approve}, utils.VerdictApprove, false, false},
+		{"approval with a failed chunk", map[string]*Analysis[string]{"api.go": approve}, utils.VerdictError, true, true},
+		{"suggestions with a failed chunk", map[string]*Analysis[string]{"api.go": suggest}, utils.VerdictSuggestions, false, true},
+		{"every chunk fails", map[string]*Analysis[string]{}, utils.VerdictError, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The summaries are listed rather than combined when the combining call fails
+			client := &scriptedClient{err: errors.New("unavailable")}
+			merged, err := mapReduce(context.Background(), client, chunkedPR(), 2, analyzeChunks(tt.results), func(s string) string { return s })
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if merged.Verdict != tt.wantVerdict {
+				t.Errorf("Verdict = %q, want %q", merged.Verdict, tt.wantVerdict)
+			}
+			if missing := strings.Contains(merged.Summary, "`worker.go`"); missing != tt.wantMissing {
+				t.Errorf("summary lists worker.go as unanalyzed: %v, want %v\n%s", missing, tt.wantMissing, merged.Summary)
+			}
+			if _, ok := tt.results["worker.go"]; !ok && len(merged.Attempts) == 0 {
+				t.Error("failed chunk's attempts were dropped")
+			}
+		})
+	}
+}
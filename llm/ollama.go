@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"tracepr/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// OllamaClient talks to a local Ollama server's /api/chat endpoint so diffs never leave the host
+type OllamaClient struct {
+	Model      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+type ollamaRequest struct {
+	Model    string           `json:"model"`
+	Messages []config.Message `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Options  map[string]any   `json:"options,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message         config.Message `json:"message"`
+	PromptEvalCount int            `json:"prompt_eval_count"`
+	EvalCount       int            `json:"eval_count"`
+}
+
+// NewOllamaClient creates an Ollama client from the model and URL in cfg
+func NewOllamaClient(cfg config.Config) *OllamaClient {
+	return &OllamaClient{
+		Model:      cfg.OllamaModel,
+		BaseURL:    cfg.OllamaBaseURL,
+		HTTPClient: &http.Client{},
+	}
+}
+
+func (c *OllamaClient) Name() string {
+	return "ollama/" + c.Model
+}
+
+func (c *OllamaClient) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	messages := []config.Message{}
+	if req.System != "" {
+		messages = append(messages, config.Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages, req.Messages...)
+
+	reqBody, err := json.Marshal(ollamaRequest{
+		Model:    c.Model,
+		Messages: messages,
+		Stream:   false,
+		Options: map[string]any{
+			"temperature": req.Temperature,
+			"num_predict": req.MaxTokens,
+		},
+	})
+	if err != nil {
+		log.Printf("Error marshaling Ollama request: %v", err)
+		return nil, fmt.Errorf("error marshaling Ollama request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		log.Printf("Error creating HTTP request: %v", err)
+		return nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	log.Printf("Sending request to Ollama with model: %s", c.Model)
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		log.Printf("Error executing HTTP request: %v", err)
+		return nil, fmt.Errorf("error executing HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Received non-200 status code from Ollama: %d", resp.StatusCode)
+		return nil, fmt.Errorf("error from Ollama (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var ollamaResp ollamaResponse
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		log.Printf("Error parsing Ollama response: %v", err)
+		return nil, fmt.Errorf("error parsing Ollama response: %v", err)
+	}
+
+	log.Print("Successfully received response from Ollama")
+	return &CompletionResponse{
+		Text:         ollamaResp.Message.Content,
+		InputTokens:  ollamaResp.PromptEvalCount,
+		OutputTokens: ollamaResp.EvalCount,
+	}, nil
+}
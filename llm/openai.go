@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"tracepr/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// OpenAIClient talks to any endpoint that speaks the OpenAI chat-completions wire format,
+// including OpenAI itself, Azure-style gateways and llama.cpp's server
+type OpenAIClient struct {
+	APIKey     string
+	Model      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+type openAIRequest struct {
+	Model       string           `json:"model"`
+	Messages    []config.Message `json:"messages"`
+	MaxTokens   int              `json:"max_tokens,omitempty"`
+	Temperature float64          `json:"temperature"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message config.Message `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// NewOpenAIClient creates an OpenAI-compatible client from the model, URL and key in cfg
+func NewOpenAIClient(cfg config.Config) *OpenAIClient {
+	return &OpenAIClient{
+		APIKey:     cfg.OpenAIAPIKey,
+		Model:      cfg.OpenAIModel,
+		BaseURL:    cfg.OpenAIBaseURL,
+		HTTPClient: &http.Client{},
+	}
+}
+
+func (c *OpenAIClient) Name() string {
+	return "openai/" + c.Model
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	// The system prompt travels as the first message in the chat-completions format
+	messages := []config.Message{}
+	if req.System != "" {
+		messages = append(messages, config.Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages, req.Messages...)
+
+	reqBody, err := json.Marshal(openAIRequest{
+		Model:       c.Model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	})
+	if err != nil {
+		log.Printf("Error marshaling OpenAI request: %v", err)
+		return nil, fmt.Errorf("error marshaling OpenAI request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		log.Printf("Error creating HTTP request: %v", err)
+		return nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	}
+
+	log.Printf("Sending request to OpenAI-compatible API with model: %s", c.Model)
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		log.Printf("Error executing HTTP request: %v", err)
+		return nil, fmt.Errorf("error executing HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Received non-200 status code from OpenAI-compatible API: %d", resp.StatusCode)
+		return nil, fmt.Errorf("error from OpenAI-compatible API (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var openAIResp openAIResponse
+	if err := json.Unmarshal(body, &openAIResp); err != nil {
+		log.Printf("Error parsing OpenAI response: %v", err)
+		return nil, fmt.Errorf("error parsing OpenAI response: %v", err)
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return nil, fmt.Errorf("OpenAI-compatible API returned no choices")
+	}
+
+	log.Print("Successfully received response from OpenAI-compatible API")
+	return &CompletionResponse{
+		Text:         openAIResp.Choices[0].Message.Content,
+		InputTokens:  openAIResp.Usage.PromptTokens,
+		OutputTokens: openAIResp.Usage.CompletionTokens,
+	}, nil
+}
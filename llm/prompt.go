@@ -1,6 +1,8 @@
 package llm
 
 import (
+	"tracepr/config"
+	"tracepr/utils"
 	"fmt"
 	"log"
 	"strings"
@@ -41,6 +43,7 @@ func BuildObservabilityPrompt(prDetails map[string]interface{}, prdContent strin
 		b.WriteString(patch)
 		b.WriteString("\n```\n\n")
 	}
+	writeSkippedFiles(&b, prDetails)
 
 	// Add PRD if provided
 	if prdContent != "" {
@@ -78,20 +81,33 @@ func BuildObservabilityPrompt(prDetails map[string]interface{}, prdContent strin
 	b.WriteString("4. Always check if OpenTelemetry or logging packages are already imported before suggesting their use\n")
 	b.WriteString("5. If imports are needed, only suggest them if the import section is visible in the diff\n\n")
 
+	b.WriteString("Follow Go best practices and match the existing code style. Only suggest changes related to observability instrumentation.\n\n")
+
 	log.Print("Adding suggestion format instructions")
-	b.WriteString("Format each suggestion as follows:\n")
-	b.WriteString("```\n")
-	b.WriteString("FILE: filename.go\n")
-	b.WriteString("LINE: 42\n")
-	b.WriteString("SUGGESTION:\n")
-	b.WriteString("```diff\n")
-	b.WriteString("// Add after line 42\n")
-	b.WriteString("+ span := otel.StartSpan(ctx, \"functionName\")\n")
-	b.WriteString("+ defer span.End()\n")
-	b.WriteString("```\n")
-
-	b.WriteString("Follow Go best practices and match the existing code style. Only suggest changes related to observability instrumentation.")
-	b.WriteString("IMPORTANT: Also, provide a summary paragraph of all the suggested changes starting with SUMMARY:, along with the reason for each change and sort them by priority (High, Medium, Low).\n\n")
+	b.WriteString("## Output Format\n\n")
+	b.WriteString("Respond with ONLY a single JSON object (no prose before or after it) matching this schema:\n\n")
+	b.WriteString("```json\n")
+	b.WriteString("{\n")
+	b.WriteString("  \"verdict\": \"suggestions\",\n")
+	b.WriteString("  \"suggestions\": [\n")
+	b.WriteString("    {\n")
+	b.WriteString("      \"file\": \"path/to/filename.go\",\n")
+	b.WriteString("      \"line\": 42,\n")
+	b.WriteString("      \"suggestion\": \"ctx, span := otel.Tracer(\\\"service\\\").Start(ctx, \\\"functionName\\\")\\ndefer span.End()\",\n")
+	b.WriteString("      \"severity\": \"medium\"\n")
+	b.WriteString("    }\n")
+	b.WriteString("  ],\n")
+	b.WriteString("  \"summary\": \"Prioritized (High, Medium, Low) summary of all suggested changes with the reason for each\"\n")
+	b.WriteString("}\n")
+	b.WriteString("```\n\n")
+	b.WriteString("Field requirements:\n")
+	b.WriteString("- verdict (string, required): \"approve\" when nothing needs to change (with an empty suggestions array), \"suggestions\" when the suggestions array is non-empty, or \"error\" if you cannot analyze the changes (explain why in summary)\n")
+	b.WriteString("- file (string, required): path of a file from the diff above\n")
+	b.WriteString("- line (integer, required): line number in the new version of the file that the suggestion replaces; it must be an added (+) or context line inside a diff hunk above\n")
+	b.WriteString("- start_line (integer, optional): first line of the range when the suggestion replaces several consecutive lines ending at line, within the same hunk\n")
+	b.WriteString("- suggestion (string, required): the complete replacement code for that line or range, including original lines that should be kept, without diff markers\n")
+	b.WriteString("- severity (string, required): high for missing instrumentation on critical or error paths, medium for important gaps, low for nice-to-haves\n")
+	b.WriteString("- summary (string, required): a summary paragraph of all the suggested changes\n\n")
 
 	log.Print("Completed building observability prompt")
 	return b.String()
@@ -134,6 +150,7 @@ func BuildDashboardPrompt(prDetails map[string]interface{}, prdContent string) s
 		b.WriteString(patch)
 		b.WriteString("\n```\n\n")
 	}
+	writeSkippedFiles(&b, prDetails)
 
 	// Add PRD if provided
 	if prdContent != "" {
@@ -167,47 +184,54 @@ func BuildDashboardPrompt(prDetails map[string]interface{}, prdContent string) s
 
 	// API-specific format
 	log.Print("Adding dashboard format instructions")
-	b.WriteString("Format EACH dashboard suggestion in EXACTLY this format for parsing:\n\n")
-
-	b.WriteString("DASHBOARD: [Dashboard name]\n")
-	b.WriteString("TYPE: [grafana or datadog or amplitude]\n")
-	b.WriteString("PRIORITY: [High, Medium, or Low]\n")
-	b.WriteString("QUERIES:\n")
-	b.WriteString("```json\n")
-	b.WriteString("[\n")
-	b.WriteString("  {\n")
-	b.WriteString("    \"refId\": \"A\",\n")
-	b.WriteString("    \"datasource\": \"Prometheus\",\n")
-	b.WriteString("    \"expr\": \"sum(rate(span_count{service_name=\\\"service_name\\\"}[5m])) by (operation)\",\n")
-	b.WriteString("    \"legendFormat\": \"{{operation}}\",\n")
-	b.WriteString("    \"interval\": \"30s\"\n")
-	b.WriteString("  }\n")
-	b.WriteString("]\n")
-	b.WriteString("```\n")
-
-	b.WriteString("PANELS:\n")
+	b.WriteString("## Output Format\n\n")
+	b.WriteString("Respond with ONLY a single JSON object (no prose before or after it) matching this schema:\n\n")
 	b.WriteString("```json\n")
-	b.WriteString("[\n")
-	b.WriteString("  {\n")
-	b.WriteString("    \"title\": \"Request Rate\",\n")
-	b.WriteString("    \"type\": \"timeseries\",\n")
-	b.WriteString("    \"gridPos\": { \"h\": 8, \"w\": 12, \"x\": 0, \"y\": 0 },\n")
-	b.WriteString("    \"targets\": [\"A\"]\n")
-	b.WriteString("  }\n")
-	b.WriteString("]\n")
-	b.WriteString("```\n")
-
-	b.WriteString("ALERTS:\n")
-	b.WriteString("```json\n")
-	b.WriteString("[\n")
-	b.WriteString("  {\n")
-	b.WriteString("    \"name\": \"High Error Rate\",\n")
-	b.WriteString("    \"expr\": \"sum(rate(span_count{status_code=\\\"ERROR\\\"}[5m])) / sum(rate(span_count[5m])) > 0.05\",\n")
-	b.WriteString("    \"for\": \"5m\",\n")
-	b.WriteString("    \"severity\": \"warning\"\n")
-	b.WriteString("  }\n")
-	b.WriteString("]\n")
+	b.WriteString("{\n")
+	b.WriteString("  \"verdict\": \"suggestions\",\n")
+	b.WriteString("  \"suggestions\": [\n")
+	b.WriteString("    {\n")
+	b.WriteString("      \"name\": \"Dashboard name\",\n")
+	b.WriteString("      \"type\": \"grafana\",\n")
+	b.WriteString("      \"priority\": \"High\",\n")
+	b.WriteString("      \"queries\": [\n")
+	b.WriteString("        {\n")
+	b.WriteString("          \"refId\": \"A\",\n")
+	b.WriteString("          \"datasource\": \"Prometheus\",\n")
+	b.WriteString("          \"expr\": \"sum(rate(span_count{service_name=\\\"service_name\\\"}[5m])) by (operation)\",\n")
+	b.WriteString("          \"legendFormat\": \"{{operation}}\",\n")
+	b.WriteString("          \"interval\": \"30s\"\n")
+	b.WriteString("        }\n")
+	b.WriteString("      ],\n")
+	b.WriteString("      \"panels\": [\n")
+	b.WriteString("        {\n")
+	b.WriteString("          \"title\": \"Request Rate\",\n")
+	b.WriteString("          \"type\": \"timeseries\",\n")
+	b.WriteString("          \"gridPos\": { \"h\": 8, \"w\": 12, \"x\": 0, \"y\": 0 },\n")
+	b.WriteString("          \"targets\": [\"A\"]\n")
+	b.WriteString("        }\n")
+	b.WriteString("      ],\n")
+	b.WriteString("      \"alerts\": [\n")
+	b.WriteString("        {\n")
+	b.WriteString("          \"name\": \"High Error Rate\",\n")
+	b.WriteString("          \"expr\": \"sum(rate(span_count{status_code=\\\"ERROR\\\"}[5m])) / sum(rate(span_count[5m])) > 0.05\",\n")
+	b.WriteString("          \"for\": \"5m\",\n")
+	b.WriteString("          \"severity\": \"warning\"\n")
+	b.WriteString("        }\n")
+	b.WriteString("      ]\n")
+	b.WriteString("    }\n")
+	b.WriteString("  ],\n")
+	b.WriteString("  \"summary\": \"Prioritized summary of all suggested dashboards with business justification and expected value\"\n")
+	b.WriteString("}\n")
 	b.WriteString("```\n\n")
+	b.WriteString("Field requirements:\n")
+	b.WriteString("- verdict (string, required): \"approve\" when nothing needs to change (with an empty suggestions array), \"suggestions\" when the suggestions array is non-empty, or \"error\" if you cannot analyze the changes (explain why in summary)\n")
+	b.WriteString("- name (string, required)\n")
+	b.WriteString("- type (string, required): one of grafana, datadog, amplitude\n")
+	b.WriteString("- priority (string, required): one of High, Medium, Low\n")
+	b.WriteString("- queries (array, required) and panels (array, required); panels reference queries by refId\n")
+	b.WriteString("- alerts (array, optional)\n")
+	b.WriteString("- summary (string, required)\n\n")
 
 	log.Print("Adding dashboard guidelines")
 	b.WriteString("IMPORTANT GUIDELINES:\n")
@@ -216,18 +240,9 @@ func BuildDashboardPrompt(prDetails map[string]interface{}, prdContent string) s
 	b.WriteString("3. For Grafana, use valid Prometheus or Loki queries based on the instrumentation\n")
 	b.WriteString("4. For Datadog, use valid Datadog queries based on the instrumentation\n")
 	b.WriteString("5. For Amplitude, use valid event names and properties from the code\n")
-	b.WriteString("6. Provide dashboard configuration in EXACTLY the format specified above\n")
+	b.WriteString("6. Provide dashboard configuration as JSON matching EXACTLY the schema specified above\n")
 	b.WriteString("7. Include at least the minimum required fields for API creation\n\n")
 
-	b.WriteString("## Identified Telemetry\n")
-	b.WriteString("Before providing dashboard suggestions, list all identified spans, metrics, logs, and events with their attributes.\n\n")
-
-	b.WriteString("## Dashboard Suggestions\n")
-	b.WriteString("List each dashboard suggestion in the specified format.\n\n")
-
-	b.WriteString("SUMMARY:\n")
-	b.WriteString("After your detailed analysis, provide a prioritized summary of all suggested dashboards with business justification and expected value.\n\n")
-
 	log.Print("Completed building dashboard prompt")
 	return b.String()
 }
@@ -239,7 +254,7 @@ func BuildAlertsPrompt(prDetails map[string]interface{}, prdContent string) stri
 	b.WriteString("# Observability Alerts Analysis\n\n")
 	b.WriteString("As an AI observability assistant, analyze the following PR and PRD to suggest alerts for:\n")
 	b.WriteString("1. OpenTelemetry-based metrics and trace alerts\n")
-	b.WriteString("2. Log-based alerts for error patterns\n")
+	b.WriteString("2. Error patterns, alerting on the metrics that count them\n")
 
 	// Add PR details
 	log.Print("Adding PR details to prompt")
@@ -268,6 +283,7 @@ func BuildAlertsPrompt(prDetails map[string]interface{}, prdContent string) stri
 		b.WriteString(patch)
 		b.WriteString("\n```\n\n")
 	}
+	writeSkippedFiles(&b, prDetails)
 
 	// Add PRD if provided
 	if prdContent != "" {
@@ -289,46 +305,75 @@ func BuildAlertsPrompt(prDetails map[string]interface{}, prdContent string) stri
 	b.WriteString("   - Unusual traffic patterns\n")
 	b.WriteString("   - Dependency failures\n\n")
 
-	b.WriteString("2. Log-based Alerts:\n")
+	b.WriteString("2. Error Pattern Alerts (on metrics counting the errors, not on log queries):\n")
 	b.WriteString("   - Critical error patterns\n")
 	b.WriteString("   - Authentication failures\n")
 	b.WriteString("   - Data integrity issues\n\n")
 
 	// API-specific format for parsing
 	log.Print("Adding alert format instructions")
-	b.WriteString("Format EACH alert suggestion in EXACTLY this format for parsing:\n\n")
-
-	b.WriteString("ALERT: [Alert name]\n")
-	b.WriteString("TYPE: [metric or log]\n")
-	b.WriteString("PRIORITY: [P0, P1, or P2]\n")
-	b.WriteString("QUERY:\n")
-	b.WriteString("```\n")
-	b.WriteString("sum(rate(span_count{status_code=\"ERROR\"}[5m])) / sum(rate(span_count[5m])) > 0.05\n")
-	b.WriteString("```\n")
-	b.WriteString("DESCRIPTION: [Brief description of what the alert means]\n")
-	b.WriteString("THRESHOLD: [Numerical threshold or condition]\n")
-	b.WriteString("DURATION: [How long condition must be true, e.g. 5m]\n")
-	b.WriteString("NOTIFICATION: [Where alert should be sent, e.g. slack-sre-channel]\n")
-	b.WriteString("RUNBOOK_LINK: [Link to runbook or troubleshooting guide]\n\n")
+	b.WriteString("## Output Format\n\n")
+	b.WriteString("Respond with ONLY a single JSON object (no prose before or after it) matching this schema:\n\n")
+	b.WriteString("```json\n")
+	b.WriteString("{\n")
+	b.WriteString("  \"verdict\": \"suggestions\",\n")
+	b.WriteString("  \"suggestions\": [\n")
+	b.WriteString("    {\n")
+	b.WriteString("      \"name\": \"Alert name\",\n")
+	b.WriteString("      \"type\": \"metric\",\n")
+	b.WriteString("      \"priority\": \"P1\",\n")
+	b.WriteString("      \"query\": \"sum(rate(span_count{status_code=\\\"ERROR\\\"}[5m])) / sum(rate(span_count[5m])) > 0.05\",\n")
+	b.WriteString("      \"description\": \"Brief description of what the alert means\",\n")
+	b.WriteString("      \"threshold\": \"Numerical threshold or condition\",\n")
+	b.WriteString("      \"duration\": \"5m\",\n")
+	b.WriteString("      \"notification\": \"slack-sre-channel\",\n")
+	b.WriteString("      \"runbook_link\": \"Link to runbook or troubleshooting guide\"\n")
+	b.WriteString("    }\n")
+	b.WriteString("  ],\n")
+	b.WriteString("  \"summary\": \"Prioritized summary of all suggested alerts with business justification and expected value\"\n")
+	b.WriteString("}\n")
+	b.WriteString("```\n\n")
+	b.WriteString("Field requirements:\n")
+	b.WriteString("- verdict (string, required): \"approve\" when nothing needs to change (with an empty suggestions array), \"suggestions\" when the suggestions array is non-empty, or \"error\" if you cannot analyze the changes (explain why in summary)\n")
+	b.WriteString("- name, query and description (strings, required)\n")
+	b.WriteString(fmt.Sprintf("- type (string, required): one of %s; %s alerts become Prometheus rules with a PromQL query and datadog alerts become Datadog monitors\n",
+		strings.Join(utils.AlertTypes, ", "), strings.Join(utils.PrometheusAlertTypes, " and ")))
+	b.WriteString("- priority (string, required): one of P0, P1, P2\n")
+	b.WriteString("- threshold, duration, notification and runbook_link (strings, optional)\n")
+	b.WriteString("- summary (string, required)\n\n")
 
 	log.Print("Adding alert guidelines")
 	b.WriteString("IMPORTANT GUIDELINES:\n")
 	b.WriteString("1. Only suggest alerts based on telemetry data present in the code\n")
 	b.WriteString("2. Focus on actionable alerts, avoid noise\n")
-	b.WriteString("3. Use valid PromQL, LogQL, or other appropriate query languages\n")
+	b.WriteString("3. Use valid PromQL, or Datadog monitor queries for datadog alerts\n")
 	b.WriteString("4. Prioritize alerts: P0=critical, P1=warning, P2=info\n")
-	b.WriteString("5. Provide alert configuration in EXACTLY the format specified above\n")
+	b.WriteString("5. Provide alert configuration as JSON matching EXACTLY the schema specified above\n")
 	b.WriteString("6. Include all required fields\n\n")
 
-	b.WriteString("## Identified Telemetry\n")
-	b.WriteString("Before providing alert suggestions, list all identified spans, metrics, logs, and events with their attributes.\n\n")
+	log.Print("Completed building alerts prompt")
+	return b.String()
+}
 
-	b.WriteString("## Alert Suggestions\n")
-	b.WriteString("List each alert suggestion in the specified format.\n\n")
+// writeSkippedFiles tells the model when it only sees part of the PR, either because the diff
+// was split across several requests or because files were left out or truncated to fit the
+// diff budget, so it doesn't assume the diff above is the whole change
+func writeSkippedFiles(b *strings.Builder, prDetails map[string]interface{}) {
+	if count, _ := prDetails["chunk_count"].(int); count > 1 {
+		index, _ := prDetails["chunk_index"].(int)
+		b.WriteString(fmt.Sprintf("Note: this PR is too large for one request, so it is analyzed in %d parts and this is part %d. ", count, index))
+		b.WriteString("The remaining files are analyzed separately; only make suggestions for the files shown above.\n\n")
+	}
 
-	b.WriteString("SUMMARY:\n")
-	b.WriteString("After your detailed analysis, provide a prioritized summary of all suggested alerts with business justification and expected value.\n\n")
+	skipped, _ := prDetails["skipped_files"].([]config.SkippedFile)
+	if len(skipped) == 0 {
+		return
+	}
 
-	log.Print("Completed building alerts prompt")
-	return b.String()
+	b.WriteString(fmt.Sprintf("## Files Not Fully Included (%d files)\n\n", len(skipped)))
+	b.WriteString("These files changed in the PR but were omitted or truncated to fit the size budget. Do not suggest changes to lines you cannot see.\n\n")
+	for _, file := range skipped {
+		b.WriteString(fmt.Sprintf("- %s (%s)\n", file.FileName, file.Reason))
+	}
+	b.WriteString("\n")
 }
@@ -0,0 +1,20 @@
+package llm
+
+import (
+	"tracepr/utils"
+	"strings"
+	"testing"
+)
+
+func TestBuildAlertsPromptListsAcceptedTypes(t *testing.T) {
+	prompt := BuildAlertsPrompt(map[string]interface{}{"title": "Add checkout", "files": []map[string]interface{}{}}, "")
+
+	// The model must only be offered the types the validator accepts and the alerts command creates
+	want := "- type (string, required): one of " + strings.Join(utils.AlertTypes, ", ") + ";"
+	if !strings.Contains(prompt, want) {
+		t.Errorf("prompt does not list the accepted types as %q", want)
+	}
+	if strings.Contains(prompt, "LogQL") {
+		t.Error("prompt still asks for log queries, which no alert type accepts")
+	}
+}
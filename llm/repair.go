@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"tracepr/config"
+	"tracepr/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// maxOutputTokens is the response budget for analysis requests
+const maxOutputTokens = 4000
+
+// Attempt records the outcome of a single LLM call within the repair loop
+type Attempt struct {
+	Chunk        int    `json:"chunk,omitempty"` // 1-based diff chunk when a large PR is analyzed in parts, 0 otherwise
+	Number       int    `json:"number"`
+	Accepted     int    `json:"accepted"`
+	Error        string `json:"error,omitempty"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+}
+
+// Analysis is the parsed result of an analysis prompt along with every attempt it took to get there
+type Analysis[T any] struct {
+	Verdict      string
+	Suggestions  []T
+	Summary      string
+	ResponseText string
+	Attempts     []Attempt
+}
+
+// ParseError is returned when the model's response still failed to parse after every repair attempt
+type ParseError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("response still invalid after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseFunc turns raw model output into suggestions and a summary
+type parseFunc[T any] func(responseText string) ([]T, string, error)
+
+// completeWithRepair sends the prompt and, whenever the response fails to parse or some
+// suggestions are rejected, feeds the errors back to the model and asks for a corrected
+// response. It gives up after maxRepairs follow-up requests and returns the best result seen.
+// When a call itself fails, the best result so far is returned along with that error.
+func completeWithRepair[T any](ctx context.Context, client Client, prompt string, maxRepairs int, parse parseFunc[T]) (*Analysis[T], error) {
+	messages := []config.Message{{Role: "user", Content: prompt}}
+	var best *Analysis[T]
+	var attempts []Attempt
+	var lastErr error
+
+	for n := 1; n <= maxRepairs+1; n++ {
+		resp, err := client.Complete(ctx, CompletionRequest{
+			System:      observabilitySystemPrompt,
+			Messages:    messages,
+			MaxTokens:   maxOutputTokens,
+			Temperature: 0.3,
+		})
+		if err != nil {
+			attempts = append(attempts, Attempt{Number: n, Error: err.Error()})
+			if best == nil {
+				return &Analysis[T]{Attempts: attempts}, err
+			}
+			best.Attempts = attempts
+			return best, err
+		}
+
+		suggestions, summary, parseErr := parse(resp.Text)
+		attempt := Attempt{Number: n, Accepted: len(suggestions), InputTokens: resp.InputTokens, OutputTokens: resp.OutputTokens}
+		if parseErr != nil {
+			attempt.Error = parseErr.Error()
+		}
+		attempts = append(attempts, attempt)
+		log.Printf("INFO: LLM attempt %d/%d: accepted %d suggestions", n, maxRepairs+1, len(suggestions))
+
+		if best == nil || len(suggestions) > len(best.Suggestions) || parseErr == nil {
+			best = &Analysis[T]{Suggestions: suggestions, Summary: summary, ResponseText: resp.Text}
+			best.Verdict, _ = utils.ParseLLMVerdict(resp.Text)
+		}
+		if parseErr == nil {
+			best.Attempts = attempts
+			return best, nil
+		}
+
+		log.Printf("WARN: LLM attempt %d failed validation: %v", n, parseErr)
+		lastErr = parseErr
+		messages = append(messages,
+			config.Message{Role: "assistant", Content: resp.Text},
+			config.Message{Role: "user", Content: buildRepairPrompt(parseErr)},
+		)
+	}
+
+	best.Attempts = attempts
+
+	// Keep whatever survived validation rather than discarding the whole run
+	var validationErr *utils.ValidationError
+	if len(best.Suggestions) > 0 && errors.As(lastErr, &validationErr) {
+		log.Printf("WARN: Using %d valid suggestions after %d attempts", len(best.Suggestions), len(attempts))
+		return best, nil
+	}
+	return best, &ParseError{Attempts: len(attempts), Err: lastErr}
+}
+
+func buildRepairPrompt(parseErr error) string {
+	return fmt.Sprintf("Your previous response could not be processed: %v\n\n"+
+		"Fix these problems and respond again with ONLY the corrected JSON object matching the schema from the original instructions. "+
+		"Include every suggestion, not just the corrected ones, and do not add any prose before or after the JSON.", parseErr)
+}
@@ -4,6 +4,7 @@ import (
 	"tracepr/config"
 	"tracepr/github"
 	"tracepr/llm"
+	"tracepr/provider"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -166,7 +167,7 @@ func handleSearchRepo(query string) (map[string]any, error) {
 	cfg := config.LoadConfig()
 
 	// Generate repo embeddings for context
-	repoURL := fmt.Sprintf("https://github.com/%s/%s", cfg.RepoOwner, cfg.RepoName)
+	repoURL := github.RepoURL(cfg)
 	embeddings, err := llm.GenerateCodeEmbeddingsFromGitHub(cfg, repoURL)
 	if err != nil {
 		return nil, fmt.Errorf("error generating code embeddings: %v", err)
@@ -198,9 +199,12 @@ func handleGetPRDetails(prNumber int) (map[string]any, error) {
 	cfg.PRNumber = prNumber
 
 	ctx := context.Background()
-	githubClient := github.InitializeGithubClient(cfg, ctx)
+	p, err := provider.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	cfg, prDetails, err := github.FetchPRDetails(githubClient, cfg)
+	cfg, prDetails, err := p.FetchChangeDetails(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching PR details: %v", err)
 	}
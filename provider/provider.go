@@ -0,0 +1,32 @@
+package provider
+
+import (
+	"tracepr/bitbucket"
+	"tracepr/config"
+	"tracepr/gitea"
+	"tracepr/github"
+	"tracepr/gitlab"
+	"tracepr/vcs"
+	"context"
+	"fmt"
+)
+
+// New returns the source control provider selected by cfg.SCMProvider
+func New(ctx context.Context, cfg config.Config) (vcs.Provider, error) {
+	switch cfg.SCMProvider {
+	case "", "github":
+		p, err := github.NewProvider(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "gitlab":
+		return gitlab.NewProvider(cfg), nil
+	case "gitea":
+		return gitea.NewProvider(cfg), nil
+	case "bitbucket":
+		return bitbucket.NewProvider(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported source control provider %q", cfg.SCMProvider)
+	}
+}
@@ -0,0 +1,558 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Job statuses
+const (
+	StatusPending    = "pending"
+	StatusRunning    = "running"
+	StatusDone       = "done"
+	StatusFailed     = "failed"
+	StatusSuperseded = "superseded"
+)
+
+const (
+	// maxBackoff caps the delay between retries of a failing job
+	maxBackoff = 30 * time.Minute
+	// retention is how long finished jobs are kept for the status endpoint
+	retention = 7 * 24 * time.Hour
+	// pollInterval is how often idle workers look for jobs whose backoff has expired
+	pollInterval = 5 * time.Second
+)
+
+var (
+	jobsBucket = []byte("jobs")
+	// statusBucket indexes jobs by status, with keys "<status>/<id>", so workers only scan pending jobs
+	statusBucket = []byte("jobs_by_status")
+	// keyBucket indexes jobs by Key, with keys "<key>\x00<id>", so enqueueing only looks at jobs for the same work
+	keyBucket = []byte("jobs_by_key")
+)
+
+// Job is a unit of queued work. Jobs with the same Key describe the same piece of work (e.g. checking
+// one PR), and a job for a newer Revision (e.g. head SHA) supersedes older ones that haven't finished.
+type Job struct {
+	ID          uint64          `json:"id"`
+	Key         string          `json:"key"`
+	Revision    string          `json:"revision"`
+	Group       string          `json:"group"` // jobs in a group share a concurrency limit, e.g. one repository
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	NotBefore   time.Time       `json:"not_before"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// LastAttempt reports whether a failure of the current attempt is final
+func (j Job) LastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// Options configures the workers of a queue
+type Options struct {
+	Workers     int           // jobs run at once across all groups
+	PerGroup    int           // jobs run at once within one group
+	MaxAttempts int           // attempts before a job is marked failed
+	Backoff     time.Duration // delay before the first retry, doubled for every further attempt
+}
+
+// Handler runs a job. Returning an error retries the job with backoff unless it is Permanent.
+type Handler func(ctx context.Context, job Job) error
+
+// permanentError marks failures that retrying won't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job fails immediately instead of being retried
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Queue is a persistent job queue stored in a bbolt file
+type Queue struct {
+	db   *bolt.DB
+	opts Options
+	wake chan struct{}
+
+	mu      sync.Mutex
+	running map[string]int                // running jobs per group
+	cancels map[uint64]context.CancelFunc // cancels running jobs that get superseded
+}
+
+// Open opens or creates the queue file at path. Jobs left running by a previous process are
+// requeued and finished jobs past the retention period are removed.
+func Open(path string, opts Options) (*Queue, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.PerGroup < 1 {
+		opts.PerGroup = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening job queue %s: %v", path, err)
+	}
+
+	q := &Queue{
+		db:      db,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+		running: make(map[string]int),
+		cancels: make(map[uint64]context.CancelFunc),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx); err != nil {
+			return err
+		}
+
+		interrupted, err := jobsWithStatus(tx, StatusRunning)
+		if err != nil {
+			return err
+		}
+		for _, job := range interrupted {
+			log.Printf("Requeueing job %d interrupted by a restart", job.ID)
+			job.Status = StatusPending
+			if err := put(tx, job); err != nil {
+				return err
+			}
+		}
+
+		for _, status := range []string{StatusDone, StatusFailed, StatusSuperseded} {
+			finished, err := jobsWithStatus(tx, status)
+			if err != nil {
+				return err
+			}
+			for _, job := range finished {
+				if time.Since(job.UpdatedAt) > retention {
+					if err := remove(tx, job); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error recovering job queue: %v", err)
+	}
+	return q, nil
+}
+
+// Close closes the queue file
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Enqueue adds a job unless one for the same key and revision is already queued, running or done,
+// in which case that job is returned. Unfinished jobs for the same key and another revision are
+// superseded, and cancelled if they are already running.
+func (q *Queue) Enqueue(job Job) (Job, bool, error) {
+	now := time.Now()
+	job.Status = StatusPending
+	job.Attempts = 0
+	job.MaxAttempts = q.opts.MaxAttempts
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.NotBefore = now
+
+	var existing *Job
+	var superseded []uint64
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		others, err := jobsWithKey(tx, job.Key)
+		if err != nil {
+			return err
+		}
+		var outdated []Job
+		for _, other := range others {
+			if other.Revision == job.Revision && other.Status != StatusFailed && other.Status != StatusSuperseded {
+				existing = &other
+				return nil
+			}
+			if other.Status == StatusPending || other.Status == StatusRunning {
+				outdated = append(outdated, other)
+			}
+		}
+
+		for _, other := range outdated {
+			superseded = append(superseded, other.ID)
+			other.Status = StatusSuperseded
+			other.UpdatedAt = now
+			if err := put(tx, other); err != nil {
+				return err
+			}
+		}
+
+		id, err := tx.Bucket(jobsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		job.ID = id
+		return put(tx, job)
+	})
+	if err != nil {
+		return job, false, fmt.Errorf("error enqueueing job: %v", err)
+	}
+	if existing != nil {
+		return *existing, false, nil
+	}
+
+	q.mu.Lock()
+	for _, id := range superseded {
+		log.Printf("Job %d is superseded by job %d", id, job.ID)
+		if cancel, ok := q.cancels[id]; ok {
+			cancel()
+		}
+	}
+	q.mu.Unlock()
+
+	q.notify()
+	return job, true, nil
+}
+
+// Get returns the job with the given ID
+func (q *Queue) Get(id uint64) (Job, bool, error) {
+	var job Job
+	found := false
+	err := q.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &job)
+	})
+	return job, found, err
+}
+
+// List returns up to limit jobs, newest first, optionally only those with the given status
+func (q *Queue) List(status string, limit int) ([]Job, error) {
+	var jobs []Job
+	err := q.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(jobsBucket).Cursor()
+		for k, v := cursor.Last(); k != nil && len(jobs) < limit; k, v = cursor.Prev() {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if status == "" || job.Status == status {
+				jobs = append(jobs, job)
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// Run processes jobs with handler until ctx is cancelled
+func (q *Queue) Run(ctx context.Context, handler Handler) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, handler Handler) {
+	for {
+		job, ok, retryAt, err := q.claim()
+		if err != nil {
+			log.Printf("Error claiming job: %v", err)
+		}
+		if !ok {
+			wait := pollInterval
+			if !retryAt.IsZero() && time.Until(retryAt) < wait {
+				wait = time.Until(retryAt)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-q.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		q.process(ctx, handler, job)
+		// Another job of the same group may have been waiting on this one
+		q.notify()
+	}
+}
+
+// claim marks the oldest runnable job as running, skipping groups at their concurrency limit.
+// When nothing is runnable it returns when the next job waiting out its backoff becomes due.
+func (q *Queue) claim() (Job, bool, time.Time, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var claimed *Job
+	var retryAt time.Time
+	now := time.Now()
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		pending, err := jobsWithStatus(tx, StatusPending)
+		if err != nil {
+			return err
+		}
+		for _, job := range pending {
+			if q.running[job.Group] >= q.opts.PerGroup {
+				continue
+			}
+			if job.NotBefore.After(now) {
+				if retryAt.IsZero() || job.NotBefore.Before(retryAt) {
+					retryAt = job.NotBefore
+				}
+				continue
+			}
+			job.Status = StatusRunning
+			job.Attempts++
+			job.UpdatedAt = now
+			claimed = &job
+			return put(tx, job)
+		}
+		return nil
+	})
+	if err != nil || claimed == nil {
+		return Job{}, false, retryAt, err
+	}
+
+	q.running[claimed.Group]++
+	return *claimed, true, time.Time{}, nil
+}
+
+func (q *Queue) process(ctx context.Context, handler Handler, job Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	q.mu.Lock()
+	q.cancels[job.ID] = cancel
+	q.mu.Unlock()
+
+	log.Printf("Running job %d (%s, attempt %d/%d)", job.ID, job.Key, job.Attempts, job.MaxAttempts)
+	err := handler(jobCtx, job)
+	cancel()
+
+	q.mu.Lock()
+	delete(q.cancels, job.ID)
+	q.running[job.Group]--
+	q.mu.Unlock()
+
+	if err := q.finish(job.ID, err); err != nil {
+		log.Printf("Error recording result of job %d: %v", job.ID, err)
+	}
+}
+
+// finish records the outcome of an attempt, scheduling a retry with backoff when it failed
+func (q *Queue) finish(id uint64, jobErr error) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		job, found, err := getJob(tx, id)
+		if err != nil || !found {
+			return err
+		}
+		job.UpdatedAt = time.Now()
+
+		var permanent permanentError
+		switch {
+		case job.Status == StatusSuperseded:
+			log.Printf("Job %d was superseded while running", job.ID)
+		case jobErr == nil:
+			log.Printf("Job %d done", job.ID)
+			job.Status = StatusDone
+			job.LastError = ""
+		case errors.As(jobErr, &permanent) || job.LastAttempt():
+			log.Printf("Job %d failed: %v", job.ID, jobErr)
+			job.Status = StatusFailed
+			job.LastError = jobErr.Error()
+		default:
+			delay := backoff(q.opts.Backoff, job.Attempts)
+			log.Printf("Job %d failed, retrying in %s: %v", job.ID, delay, jobErr)
+			job.Status = StatusPending
+			job.LastError = jobErr.Error()
+			job.NotBefore = job.UpdatedAt.Add(delay)
+		}
+		return put(tx, job)
+	})
+}
+
+// backoff doubles the base delay for every attempt already made
+func backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// createBuckets creates the jobs bucket and its indexes, building the indexes from the stored jobs
+// when they are missing, e.g. in a queue file written before they existed
+func createBuckets(tx *bolt.Tx) error {
+	indexed := tx.Bucket(statusBucket) != nil && tx.Bucket(keyBucket) != nil
+	for _, name := range [][]byte{jobsBucket, statusBucket, keyBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	if indexed {
+		return nil
+	}
+
+	// bbolt doesn't allow changing buckets while iterating them, so collect the jobs first
+	var jobs []Job
+	err := tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+		job, err := decodeJob(k, v)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err := index(tx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobsWithStatus returns the jobs with status, oldest first
+func jobsWithStatus(tx *bolt.Tx, status string) ([]Job, error) {
+	return indexedJobs(tx, statusBucket, []byte(status+"/"))
+}
+
+// jobsWithKey returns the jobs with key, oldest first
+func jobsWithKey(tx *bolt.Tx, key string) ([]Job, error) {
+	return indexedJobs(tx, keyBucket, []byte(key+"\x00"))
+}
+
+// indexedJobs reads the jobs whose index entries start with prefix. The index keys end with the
+// job ID, so they are collected before anything is written.
+func indexedJobs(tx *bolt.Tx, indexBucket, prefix []byte) ([]Job, error) {
+	var ids []uint64
+	cursor := tx.Bucket(indexBucket).Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		ids = append(ids, binary.BigEndian.Uint64(k[len(k)-8:]))
+	}
+
+	jobs := make([]Job, 0, len(ids))
+	for _, id := range ids {
+		job, found, err := getJob(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func getJob(tx *bolt.Tx, id uint64) (Job, bool, error) {
+	key := itob(id)
+	data := tx.Bucket(jobsBucket).Get(key)
+	if data == nil {
+		return Job{}, false, nil
+	}
+	job, err := decodeJob(key, data)
+	return job, err == nil, err
+}
+
+func decodeJob(k, v []byte) (Job, error) {
+	var job Job
+	if err := json.Unmarshal(v, &job); err != nil {
+		return job, fmt.Errorf("error decoding job %d: %v", binary.BigEndian.Uint64(k), err)
+	}
+	return job, nil
+}
+
+// put stores job and keeps the indexes in step with its status
+func put(tx *bolt.Tx, job Job) error {
+	old, found, err := getJob(tx, job.ID)
+	if err != nil {
+		return err
+	}
+	if found && old.Status != job.Status {
+		if err := tx.Bucket(statusBucket).Delete(statusKey(old.Status, old.ID)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(jobsBucket).Put(itob(job.ID), data); err != nil {
+		return err
+	}
+	return index(tx, job)
+}
+
+func index(tx *bolt.Tx, job Job) error {
+	if err := tx.Bucket(statusBucket).Put(statusKey(job.Status, job.ID), []byte{}); err != nil {
+		return err
+	}
+	return tx.Bucket(keyBucket).Put(jobKey(job.Key, job.ID), []byte{})
+}
+
+// remove deletes job and its index entries
+func remove(tx *bolt.Tx, job Job) error {
+	if err := tx.Bucket(statusBucket).Delete(statusKey(job.Status, job.ID)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(keyBucket).Delete(jobKey(job.Key, job.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket(jobsBucket).Delete(itob(job.ID))
+}
+
+func statusKey(status string, id uint64) []byte {
+	return append([]byte(status+"/"), itob(id)...)
+}
+
+func jobKey(key string, id uint64) []byte {
+	return append([]byte(key+"\x00"), itob(id)...)
+}
+
+// itob encodes IDs big-endian so keys sort in insertion order
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
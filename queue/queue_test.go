@@ -0,0 +1,339 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestQueue(t *testing.T, path string, opts Options) *Queue {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "jobs.db")
+	}
+	q, err := Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// runQueue runs the workers until the test ends
+func runQueue(t *testing.T, q *Queue, handler Handler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, handler)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitForStatus polls until the job reaches status
+func waitForStatus(t *testing.T, q *Queue, id uint64, status string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, found, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%d): %v", id, err)
+		}
+		if found && job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d has status %q, want %q", id, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func enqueue(t *testing.T, q *Queue, job Job) Job {
+	t.Helper()
+	queued, created, err := q.Enqueue(job)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if !created {
+		t.Fatalf("Enqueue(%s@%s) returned existing job %d", job.Key, job.Revision, queued.ID)
+	}
+	return queued
+}
+
+func TestRetriesWithBackoffUntilSuccess(t *testing.T) {
+	q := openTestQueue(t, "", Options{MaxAttempts: 3, Backoff: 20 * time.Millisecond})
+
+	var mu sync.Mutex
+	var attemptTimes []time.Time
+	runQueue(t, q, func(ctx context.Context, job Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attemptTimes = append(attemptTimes, time.Now())
+		if job.Attempts < 3 {
+			return errors.New("LLM unavailable")
+		}
+		return nil
+	})
+
+	job := enqueue(t, q, Job{Key: "o/r#1", Revision: "a", Group: "o/r"})
+	job = waitForStatus(t, q, job.ID, StatusDone)
+
+	if job.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", job.Attempts)
+	}
+	if job.LastError != "" {
+		t.Errorf("LastError = %q, want it cleared after success", job.LastError)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// The second retry waits twice as long as the first
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if got := attemptTimes[i+1].Sub(attemptTimes[i]); got < want {
+			t.Errorf("retry %d ran after %s, want at least %s", i+1, got, want)
+		}
+	}
+}
+
+func TestFailsAfterMaxAttempts(t *testing.T) {
+	q := openTestQueue(t, "", Options{MaxAttempts: 2, Backoff: time.Millisecond})
+	runQueue(t, q, func(ctx context.Context, job Job) error {
+		return errors.New("still broken")
+	})
+
+	job := enqueue(t, q, Job{Key: "o/r#1", Revision: "a"})
+	job = waitForStatus(t, q, job.ID, StatusFailed)
+	if job.Attempts != 2 || job.LastError != "still broken" {
+		t.Errorf("got attempts %d and error %q, want 2 and %q", job.Attempts, job.LastError, "still broken")
+	}
+}
+
+func TestPermanentErrorsAreNotRetried(t *testing.T) {
+	q := openTestQueue(t, "", Options{MaxAttempts: 5, Backoff: time.Millisecond})
+	runQueue(t, q, func(ctx context.Context, job Job) error {
+		return Permanent(errors.New("bad payload"))
+	})
+
+	job := enqueue(t, q, Job{Key: "o/r#1", Revision: "a"})
+	job = waitForStatus(t, q, job.ID, StatusFailed)
+	if job.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", job.Attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{30, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(time.Second, tt.attempts); got != tt.want {
+			t.Errorf("backoff(1s, %d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueDeduplicatesRevision(t *testing.T) {
+	q := openTestQueue(t, "", Options{})
+	first := enqueue(t, q, Job{Key: "o/r#1", Revision: "a"})
+
+	again, created, err := q.Enqueue(Job{Key: "o/r#1", Revision: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || again.ID != first.ID {
+		t.Errorf("Enqueue of the same revision created job %d, want existing job %d", again.ID, first.ID)
+	}
+
+	// Another PR with the same revision is separate work
+	enqueue(t, q, Job{Key: "o/r#10", Revision: "a"})
+}
+
+func TestEnqueueSupersedesPendingJobs(t *testing.T) {
+	q := openTestQueue(t, "", Options{})
+	old := enqueue(t, q, Job{Key: "o/r#1", Revision: "a"})
+	other := enqueue(t, q, Job{Key: "o/r#2", Revision: "a"})
+	enqueue(t, q, Job{Key: "o/r#1", Revision: "b"})
+
+	if job, _, _ := q.Get(old.ID); job.Status != StatusSuperseded {
+		t.Errorf("old revision has status %q, want %q", job.Status, StatusSuperseded)
+	}
+	if job, _, _ := q.Get(other.ID); job.Status != StatusPending {
+		t.Errorf("job for another PR has status %q, want %q", job.Status, StatusPending)
+	}
+	pending, err := q.List(StatusPending, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("got %d pending jobs, want 2", len(pending))
+	}
+}
+
+func TestEnqueueCancelsSupersededRunningJob(t *testing.T) {
+	q := openTestQueue(t, "", Options{Workers: 2, PerGroup: 2})
+	started := make(chan uint64, 2)
+	runQueue(t, q, func(ctx context.Context, job Job) error {
+		started <- job.ID
+		if job.Revision == "a" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	old := enqueue(t, q, Job{Key: "o/r#1", Revision: "a", Group: "o/r"})
+	if id := <-started; id != old.ID {
+		t.Fatalf("started job %d, want %d", id, old.ID)
+	}
+	newer := enqueue(t, q, Job{Key: "o/r#1", Revision: "b", Group: "o/r"})
+
+	job := waitForStatus(t, q, old.ID, StatusSuperseded)
+	if job.LastError != "" {
+		t.Errorf("superseded job recorded error %q", job.LastError)
+	}
+	waitForStatus(t, q, newer.ID, StatusDone)
+}
+
+func TestPerGroupLimit(t *testing.T) {
+	q := openTestQueue(t, "", Options{Workers: 3, PerGroup: 1})
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	release := make(chan struct{})
+	runQueue(t, q, func(ctx context.Context, job Job) error {
+		mu.Lock()
+		running[job.Group]++
+		if running[job.Group] > maxRunning[job.Group] {
+			maxRunning[job.Group] = running[job.Group]
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running[job.Group]--
+		mu.Unlock()
+		return nil
+	})
+
+	var jobs []Job
+	for _, key := range []string{"a#1", "a#2", "a#3", "b#1"} {
+		jobs = append(jobs, enqueue(t, q, Job{Key: key, Revision: "x", Group: key[:1]}))
+	}
+
+	// Both groups get a job running, but group a never gets a second one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		both := running["a"] == 1 && running["b"] == 1
+		mu.Unlock()
+		if both {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("jobs of both groups never ran at the same time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+
+	for _, job := range jobs {
+		waitForStatus(t, q, job.ID, StatusDone)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning["a"] != 1 {
+		t.Errorf("group a ran %d jobs at once, want 1", maxRunning["a"])
+	}
+}
+
+func TestOpenRequeuesRunningAndPrunesFinishedJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	q, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted := enqueue(t, q, Job{Key: "o/r#1", Revision: "a"})
+	if _, ok, _, err := q.claim(); !ok || err != nil {
+		t.Fatalf("claim: %v, %v", ok, err)
+	}
+	stale := enqueue(t, q, Job{Key: "o/r#2", Revision: "a"})
+	recent := enqueue(t, q, Job{Key: "o/r#3", Revision: "a"})
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		stale.Status, stale.UpdatedAt = StatusDone, time.Now().Add(-retention-time.Hour)
+		recent.Status, recent.UpdatedAt = StatusDone, time.Now()
+		if err := put(tx, stale); err != nil {
+			return err
+		}
+		return put(tx, recent)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q = openTestQueue(t, path, Options{})
+	if job, _, _ := q.Get(interrupted.ID); job.Status != StatusPending {
+		t.Errorf("interrupted job has status %q, want %q", job.Status, StatusPending)
+	}
+	if _, found, _ := q.Get(stale.ID); found {
+		t.Error("job finished before the retention period was not removed")
+	}
+	if _, found, _ := q.Get(recent.ID); !found {
+		t.Error("recently finished job was removed")
+	}
+	if running, _ := q.List(StatusRunning, 10); len(running) != 0 {
+		t.Errorf("got %d running jobs after reopening, want 0", len(running))
+	}
+}
+
+func TestOpenIndexesExistingJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+
+	// A queue file written before the indexes existed only has the jobs bucket
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(jobsBucket)
+		if err != nil {
+			return err
+		}
+		data, _ := json.Marshal(Job{ID: 1, Key: "o/r#1", Revision: "a", Status: StatusPending, MaxAttempts: 1})
+		if err := bucket.Put(itob(1), data); err != nil {
+			return err
+		}
+		return bucket.SetSequence(1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	q := openTestQueue(t, path, Options{})
+	job, ok, _, err := q.claim()
+	if err != nil || !ok || job.ID != 1 {
+		t.Fatalf("claim = %d, %v, %v, want job 1", job.ID, ok, err)
+	}
+	if _, created, _ := q.Enqueue(Job{Key: "o/r#1", Revision: "a"}); created {
+		t.Error("Enqueue didn't find the existing job through the key index")
+	}
+}
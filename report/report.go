@@ -0,0 +1,181 @@
+package report
+
+import (
+	"tracepr/config"
+	"tracepr/llm"
+	"tracepr/utils"
+	"tracepr/vcs"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Report is the machine-readable result of a check, dashboard or alerts run
+type Report struct {
+	Command      string                       `json:"command"`
+	Provider     string                       `json:"provider,omitempty"`
+	Repository   string                       `json:"repository,omitempty"`
+	PRNumber     int                          `json:"pr_number,omitempty"`
+	HeadSHA      string                       `json:"head_sha,omitempty"`
+	Title        string                       `json:"title,omitempty"`
+	Verdict      string                       `json:"verdict,omitempty"`
+	Summary      string                       `json:"summary,omitempty"`
+	Suggestions  []config.FileSuggestion      `json:"suggestions,omitempty"`
+	Dashboards   []config.DashboardSuggestion `json:"dashboards,omitempty"`
+	Alerts       []config.AlertSuggestion     `json:"alerts,omitempty"`
+	SkippedFiles []config.SkippedFile         `json:"skipped_files,omitempty"`
+	Usage        Usage                        `json:"usage"`
+	Attempts     []llm.Attempt                `json:"attempts,omitempty"`
+	Resources    []Resource                   `json:"resources,omitempty"`
+	Error        string                       `json:"error,omitempty"`
+	GeneratedAt  time.Time                    `json:"generated_at"`
+}
+
+// Usage totals the LLM calls a run made
+type Usage struct {
+	LLMCalls     int `json:"llm_calls"`
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Resource is a dashboard or alert the run tried to create
+type Resource struct {
+	Kind    string `json:"kind"` // "dashboard" or "alert"
+	Type    string `json:"type"` // e.g. grafana, prometheus
+	Name    string `json:"name"`
+	Created bool   `json:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+// New starts a report for command. Local checks have no provider or PR.
+func New(command string, cfg config.Config) *Report {
+	r := &Report{Command: command, GeneratedAt: time.Now().UTC()}
+	if !cfg.LocalMode {
+		r.Provider = cfg.SCMProvider
+		r.Repository = cfg.RepoOwner + "/" + cfg.RepoName
+		r.PRNumber = cfg.PRNumber
+	}
+	return r
+}
+
+// SetChange records the change that was analyzed once its details are fetched
+func (r *Report) SetChange(cfg config.Config, prDetails map[string]interface{}) {
+	r.HeadSHA = cfg.HeadSHA
+	r.Title, _ = prDetails["title"].(string)
+	r.SkippedFiles, _ = prDetails["skipped_files"].([]config.SkippedFile)
+}
+
+// AddAttempts records the LLM calls of an analysis and adds them to the usage totals
+func (r *Report) AddAttempts(attempts []llm.Attempt) {
+	r.Attempts = append(r.Attempts, attempts...)
+	for _, attempt := range attempts {
+		r.Usage.LLMCalls++
+		r.Usage.InputTokens += attempt.InputTokens
+		r.Usage.OutputTokens += attempt.OutputTokens
+	}
+}
+
+// AddResource records the outcome of creating a dashboard or alert
+func (r *Report) AddResource(kind, resourceType, name string, err error) {
+	resource := Resource{Kind: kind, Type: resourceType, Name: name, Created: err == nil}
+	if err != nil {
+		resource.Error = err.Error()
+	}
+	r.Resources = append(r.Resources, resource)
+}
+
+// Write renders the report in format and writes it to path, or to stdout when path is empty
+func (r *Report) Write(format, path string) error {
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(r, "", "  ")
+	case "markdown":
+		data = []byte(r.Markdown())
+	case "sarif":
+		data, err = r.SARIF()
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error rendering %s report: %v", format, err)
+	}
+
+	if path == "" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report to %s: %v", path, err)
+	}
+	log.Printf("Wrote %s report to %s", format, path)
+	return nil
+}
+
+// Markdown renders the report for people, e.g. a CI job summary
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	if r.Title != "" {
+		b.WriteString(fmt.Sprintf("# TracePR %s: %s\n\n", r.Command, r.Title))
+	} else {
+		b.WriteString(fmt.Sprintf("# TracePR %s\n\n", r.Command))
+	}
+	if r.Repository != "" {
+		b.WriteString(fmt.Sprintf("%s #%d", r.Repository, r.PRNumber))
+		if r.HeadSHA != "" {
+			b.WriteString(" at " + vcs.ShortSHA(r.HeadSHA))
+		}
+		b.WriteString("\n\n")
+	}
+
+	if r.Error != "" {
+		b.WriteString(fmt.Sprintf("**Failed:** %s\n\n", r.Error))
+	}
+
+	if r.Verdict == utils.VerdictApprove {
+		b.WriteString(vcs.BuildApproveSummary(r.Summary) + "\n\n")
+	} else if r.Summary != "" {
+		b.WriteString(r.Summary + "\n\n")
+	}
+
+	for _, suggestion := range r.Suggestions {
+		lines := suggestion.LineNum
+		if suggestion.StartLineNum != "" {
+			lines = suggestion.StartLineNum + "-" + suggestion.LineNum
+		}
+		b.WriteString(fmt.Sprintf("## %s:%s (%s)\n\n", suggestion.FileName, lines, suggestion.Severity))
+		b.WriteString("```\n" + suggestion.Content + "\n```\n\n")
+	}
+
+	for _, dashboard := range r.Dashboards {
+		b.WriteString(fmt.Sprintf("## Dashboard: %s\n\n**Type:** %s\n**Priority:** %s\n\n", dashboard.Name, dashboard.Type, dashboard.Priority))
+	}
+	for _, alert := range r.Alerts {
+		b.WriteString(fmt.Sprintf("## Alert: %s\n\n**Type:** %s\n**Priority:** %s\n\n%s\n\n", alert.Name, alert.Type, alert.Priority, alert.Description))
+	}
+
+	if len(r.Resources) > 0 {
+		b.WriteString("## Created resources\n\n| Kind | Type | Name | Result |\n|---|---|---|---|\n")
+		for _, resource := range r.Resources {
+			result := "created"
+			if !resource.Created {
+				result = "failed: " + resource.Error
+			}
+			b.WriteString(fmt.Sprintf("| %s | %s | %s | %s |\n", resource.Kind, resource.Type, resource.Name, result))
+		}
+		b.WriteString("\n")
+	}
+
+	if skipped := vcs.FormatSkippedFiles(map[string]interface{}{"skipped_files": r.SkippedFiles}); skipped != "" {
+		b.WriteString(strings.TrimSpace(skipped) + "\n\n")
+	}
+
+	if r.Usage.LLMCalls > 0 {
+		b.WriteString(fmt.Sprintf("_LLM usage: %d calls, %d input tokens, %d output tokens._\n", r.Usage.LLMCalls, r.Usage.InputTokens, r.Usage.OutputTokens))
+	}
+	return b.String()
+}
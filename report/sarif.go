@@ -0,0 +1,192 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	// sarifRuleID is the single rule every observability suggestion is reported under
+	sarifRuleID = "tracepr/observability-gap"
+)
+
+// The subset of SARIF 2.1.0 that GitHub code scanning reads
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool              `json:"tool"`
+	Results    []sarifResult          `json:"results"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	Help             sarifMessage `json:"help"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+// SARIF renders the observability suggestions as SARIF results for code scanning. SARIF results
+// need a source location, so dashboards, alerts and the rest of the report go into the run's
+// properties instead.
+func (r *Report) SARIF() ([]byte, error) {
+	results := []sarifResult{}
+	for _, suggestion := range r.Suggestions {
+		line, err := strconv.Atoi(suggestion.LineNum)
+		if err != nil {
+			continue
+		}
+		region := sarifRegion{StartLine: line}
+		if start, err := strconv.Atoi(suggestion.StartLineNum); err == nil && start > 0 && start < line {
+			region = sarifRegion{StartLine: start, EndLine: line}
+		}
+		location := sarifArtifactLocation{URI: suggestion.FileName}
+
+		results = append(results, sarifResult{
+			RuleID:  sarifRuleID,
+			Level:   sarifLevel(suggestion.Severity),
+			Message: sarifMessage{Text: fmt.Sprintf("Observability suggestion (%s severity). Suggested change:\n\n%s", suggestion.Severity, suggestion.Content)},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: location,
+				Region:           region,
+			}}},
+			Fixes: []sarifFix{{
+				Description: sarifMessage{Text: "Apply the suggested instrumentation"},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: location,
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   region,
+						InsertedContent: sarifMessage{Text: suggestion.Content + "\n"},
+					}},
+				}},
+			}},
+		})
+	}
+
+	properties := map[string]interface{}{
+		"command": r.Command,
+		"usage":   r.Usage,
+	}
+	if r.Repository != "" {
+		properties["repository"] = r.Repository
+		properties["prNumber"] = r.PRNumber
+	}
+	if r.HeadSHA != "" {
+		properties["headSha"] = r.HeadSHA
+	}
+	if r.Verdict != "" {
+		properties["verdict"] = r.Verdict
+	}
+	if r.Summary != "" {
+		properties["summary"] = r.Summary
+	}
+	if len(r.Dashboards) > 0 {
+		properties["dashboards"] = r.Dashboards
+	}
+	if len(r.Alerts) > 0 {
+		properties["alerts"] = r.Alerts
+	}
+	if len(r.SkippedFiles) > 0 {
+		properties["skippedFiles"] = r.SkippedFiles
+	}
+	if len(r.Resources) > 0 {
+		properties["resources"] = r.Resources
+	}
+	if r.Error != "" {
+		properties["error"] = r.Error
+	}
+
+	return json.MarshalIndent(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "TracePR",
+				InformationURI: "https://github.com/SkySingh04/TracePR",
+				Rules: []sarifRule{{
+					ID:               sarifRuleID,
+					Name:             "ObservabilityGap",
+					ShortDescription: sarifMessage{Text: "Missing or inadequate observability instrumentation"},
+					Help:             sarifMessage{Text: "Add the suggested logging, metrics, tracing or event tracking so the change can be monitored in production."},
+				}},
+			}},
+			Results:    results,
+			Properties: properties,
+		}},
+	}, "", "  ")
+}
+
+// sarifLevel maps suggestion severities onto SARIF result levels
+func sarifLevel(severity string) string {
+	switch severity {
+	case "high":
+		return "error"
+	case "low":
+		return "note"
+	default:
+		return "warning"
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"tracepr/config"
+	"tracepr/vcs"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of suggestions kept in the store
+const (
+	KindDashboards  = "dashboard"
+	KindAlerts      = "alert"
+	KindSuggestions = "review"
+)
+
+// Key identifies the PR revision suggestions were generated for
+type Key struct {
+	Repo     string // owner/name
+	PRNumber int
+	HeadSHA  string
+}
+
+// KeyFor returns the key of the PR described by cfg after its details were fetched
+func KeyFor(cfg config.Config) Key {
+	return Key{Repo: cfg.RepoOwner + "/" + cfg.RepoName, PRNumber: cfg.PRNumber, HeadSHA: cfg.HeadSHA}
+}
+
+// record is the file written for one kind of suggestions of one PR revision
+type record[T any] struct {
+	Repo        string    `json:"repo"`
+	PRNumber    int       `json:"pr_number"`
+	HeadSHA     string    `json:"head_sha"`
+	CreatedAt   time.Time `json:"created_at"`
+	Suggestions []T       `json:"suggestions"`
+}
+
+// prDir is the directory holding every saved revision of a PR. The repository is escaped into a
+// single path element so GitLab subgroups can't nest or escape the store.
+func prDir(dir string, key Key) string {
+	return filepath.Join(dir, url.PathEscape(key.Repo), strconv.Itoa(key.PRNumber))
+}
+
+func recordPath(dir string, key Key, kind string) string {
+	return filepath.Join(prDir(dir, key), key.HeadSHA+"."+kind+".json")
+}
+
+// Save writes the suggestions generated for a PR revision, replacing earlier ones of the same kind
+func Save[T any](dir string, key Key, kind string, suggestions []T) error {
+	if key.HeadSHA == "" {
+		return fmt.Errorf("no head SHA to save %s suggestions under", kind)
+	}
+
+	data, err := json.MarshalIndent(record[T]{
+		Repo:        key.Repo,
+		PRNumber:    key.PRNumber,
+		HeadSHA:     key.HeadSHA,
+		CreatedAt:   time.Now().UTC(),
+		Suggestions: suggestions,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s suggestions: %v", kind, err)
+	}
+
+	if err := os.MkdirAll(prDir(dir, key), 0755); err != nil {
+		return fmt.Errorf("error creating suggestion store: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial record
+	path := recordPath(dir, key, kind)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s suggestions: %v", kind, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing %s suggestions: %v", kind, err)
+	}
+
+	log.Printf("Saved %d %s suggestions to %s", len(suggestions), kind, path)
+	return nil
+}
+
+// Load reads the suggestions saved for the PR revision in key. If none were generated for that
+// head SHA, for example because commits were pushed since, the most recently saved revision of
+// the PR is used. found is false when nothing was saved for the PR at all.
+func Load[T any](dir string, key Key, kind string) (suggestions []T, found bool, err error) {
+	path := recordPath(dir, key, kind)
+	if key.HeadSHA == "" || !fileExists(path) {
+		path, err = latestRecord(dir, key, kind)
+		if err != nil || path == "" {
+			return nil, false, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading saved %s suggestions: %v", kind, err)
+	}
+	var saved record[T]
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, false, fmt.Errorf("error parsing saved %s suggestions in %s: %v", kind, path, err)
+	}
+
+	if key.HeadSHA != "" && saved.HeadSHA != key.HeadSHA {
+		log.Printf("No %s suggestions saved for %s, using the ones generated for %s", kind, vcs.ShortSHA(key.HeadSHA), vcs.ShortSHA(saved.HeadSHA))
+	}
+	log.Printf("Loaded %d %s suggestions from %s", len(saved.Suggestions), kind, path)
+	return saved.Suggestions, true, nil
+}
+
+// latestRecord returns the most recently written record of the given kind for the PR, or ""
+func latestRecord(dir string, key Key, kind string) (string, error) {
+	entries, err := os.ReadDir(prDir(dir, key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading suggestion store: %v", err)
+	}
+
+	latest := ""
+	var latestTime time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), "."+kind+".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest = filepath.Join(prDir(dir, key), entry.Name())
+			latestTime = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"tracepr/config"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// fileHeaderTokens approximates the prompt overhead of a file's heading and diff fence
+const fileHeaderTokens = 20
+
+// File relevance tiers, highest first
+const (
+	RelevanceSource    = 3
+	RelevanceConfig    = 2
+	RelevanceTest      = 1
+	RelevanceGenerated = 0
+)
+
+var lockFiles = map[string]bool{
+	"go.sum":            true,
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"Cargo.lock":        true,
+	"Gemfile.lock":      true,
+	"poetry.lock":       true,
+	"composer.lock":     true,
+	"Pipfile.lock":      true,
+}
+
+var sourceExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".rb": true, ".rs": true, ".cs": true, ".php": true,
+	".scala": true, ".swift": true, ".c": true, ".cc": true, ".cpp": true, ".h": true,
+}
+
+// EstimateTokens approximates the number of LLM tokens in text (roughly four characters per token)
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// FileRelevance ranks how useful a changed file is for observability analysis.
+// Source code ranks above config and docs, which rank above tests; vendored,
+// generated and lock files rank last.
+func FileRelevance(filename, patch string) int {
+	base := path.Base(filename)
+	ext := path.Ext(base)
+
+	switch {
+	case lockFiles[base],
+		strings.HasPrefix(filename, "vendor/"), strings.Contains(filename, "/vendor/"),
+		strings.Contains(filename, "node_modules/"), strings.HasPrefix(filename, "third_party/"),
+		strings.HasSuffix(base, ".pb.go"), strings.HasSuffix(base, "_gen.go"), strings.HasSuffix(base, ".gen.go"),
+		strings.HasPrefix(base, "zz_generated"), strings.Contains(base, ".min."),
+		strings.Contains(patch, "Code generated") && strings.Contains(patch, "DO NOT EDIT"):
+		return RelevanceGenerated
+	case strings.HasSuffix(base, "_test.go"), strings.Contains(base, ".test."), strings.Contains(base, ".spec."),
+		strings.HasPrefix(base, "test_"), strings.Contains(filename, "/testdata/"), strings.HasPrefix(filename, "testdata/"):
+		return RelevanceTest
+	case sourceExtensions[ext]:
+		return RelevanceSource
+	default:
+		return RelevanceConfig
+	}
+}
+
+// SplitHunks splits a unified diff patch into its "@@" hunks
+func SplitHunks(patch string) []string {
+	var hunks []string
+	var current strings.Builder
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") && current.Len() > 0 {
+			hunks = append(hunks, strings.TrimSuffix(current.String(), "\n"))
+			current.Reset()
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if current.Len() > 0 {
+		hunks = append(hunks, strings.TrimSuffix(current.String(), "\n"))
+	}
+	return hunks
+}
+
+// BudgetFiles picks the files (and hunks) that fit into a single diff budget, most relevant first.
+// See ChunkFiles for how files are selected and truncated.
+func BudgetFiles(files []map[string]interface{}, maxTokens, maxBytes int) ([]map[string]interface{}, []config.SkippedFile) {
+	kept, _, skipped := ChunkFiles(files, maxTokens, maxBytes, 1)
+	return kept, skipped
+}
+
+// ChunkFiles packs the changed files, most relevant first, into at most maxChunks chunks of
+// chunkTokens each so large PRs can be analyzed across several LLM calls. Files that don't fit
+// whole are truncated at hunk boundaries. chunkTokens and maxBytes (a cap across all chunks) are
+// ignored when zero. Kept files, and the files within each chunk, retain their original order;
+// every file that was dropped or truncated is reported so the prompt and PR summary can say what
+// wasn't analyzed.
+func ChunkFiles(files []map[string]interface{}, chunkTokens, maxBytes, maxChunks int) ([]map[string]interface{}, [][]map[string]interface{}, []config.SkippedFile) {
+	if maxChunks < 1 {
+		maxChunks = 1
+	}
+
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	relevance := make([]int, len(files))
+	for i, file := range files {
+		filename, _ := file["filename"].(string)
+		patch, _ := file["patch"].(string)
+		relevance[i] = FileRelevance(filename, patch)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return relevance[order[a]] > relevance[order[b]]
+	})
+
+	var chunkUsed []int
+	usedBytes := 0
+	cost := func(patch string) int {
+		return EstimateTokens(patch) + fileHeaderTokens
+	}
+	fits := func(chunk int, patch string) bool {
+		if maxBytes > 0 && usedBytes+len(patch) > maxBytes {
+			return false
+		}
+		used := 0
+		if chunk < len(chunkUsed) {
+			used = chunkUsed[chunk]
+		}
+		return chunkTokens <= 0 || used+cost(patch) <= chunkTokens
+	}
+
+	assigned := make([]int, len(files))
+	reasons := make([]string, len(files))
+	for _, i := range order {
+		assigned[i] = -1
+		file := files[i]
+		patch, _ := file["patch"].(string)
+
+		// First fit across the open chunks, opening a new one while allowed
+		target := -1
+		for chunk := 0; chunk < maxChunks && chunk <= len(chunkUsed); chunk++ {
+			if fits(chunk, patch) {
+				target = chunk
+				break
+			}
+		}
+
+		if target < 0 {
+			// Keep as many whole hunks as fit into the chunk with the most room
+			target = roomiestChunk(chunkUsed, maxChunks)
+			hunks := SplitHunks(patch)
+			var selected []string
+			for _, hunk := range hunks {
+				if fits(target, strings.Join(append(selected, hunk), "\n")) {
+					selected = append(selected, hunk)
+				}
+			}
+			if len(selected) == 0 {
+				reasons[i] = "exceeds the diff budget"
+				continue
+			}
+			reasons[i] = fmt.Sprintf("truncated to %d of %d hunks", len(selected), len(hunks))
+			patch = strings.Join(selected, "\n")
+			file["patch"] = patch
+			file["truncated"] = true
+		}
+
+		if target == len(chunkUsed) {
+			chunkUsed = append(chunkUsed, 0)
+		}
+		chunkUsed[target] += cost(patch)
+		usedBytes += len(patch)
+		assigned[i] = target
+	}
+
+	var kept []map[string]interface{}
+	chunks := make([][]map[string]interface{}, len(chunkUsed))
+	var skipped []config.SkippedFile
+	for i, file := range files {
+		if assigned[i] >= 0 {
+			kept = append(kept, file)
+			chunks[assigned[i]] = append(chunks[assigned[i]], file)
+		}
+		if reasons[i] != "" {
+			filename, _ := file["filename"].(string)
+			skipped = append(skipped, config.SkippedFile{FileName: filename, Reason: reasons[i]})
+		}
+	}
+	return kept, chunks, skipped
+}
+
+// roomiestChunk returns the chunk with the most unused budget, preferring a new chunk while one can be opened
+func roomiestChunk(chunkUsed []int, maxChunks int) int {
+	if len(chunkUsed) < maxChunks {
+		return len(chunkUsed)
+	}
+	best := 0
+	for chunk, used := range chunkUsed {
+		if used < chunkUsed[best] {
+			best = chunk
+		}
+	}
+	return best
+}
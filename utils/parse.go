@@ -2,134 +2,335 @@ package utils
 
 import (
 	"tracepr/config"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
-// ParseLLMSuggestions extracts file-based suggestions from Claude's response
+// RejectedItem records a single suggestion that failed schema validation
+type RejectedItem struct {
+	Index  int
+	Name   string
+	Reason string
+}
+
+// ValidationError is returned when some suggestions in an otherwise well-formed response were rejected
+type ValidationError struct {
+	Kind     string
+	Accepted int
+	Rejected []RejectedItem
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Rejected))
+	for _, item := range e.Rejected {
+		label := fmt.Sprintf("#%d", item.Index+1)
+		if item.Name != "" {
+			label += fmt.Sprintf(" (%s)", item.Name)
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", label, item.Reason))
+	}
+	return fmt.Sprintf("rejected %d of %d %s suggestions: %s",
+		len(e.Rejected), len(e.Rejected)+e.Accepted, e.Kind, strings.Join(reasons, "; "))
+}
+
+// Verdicts the model must choose from in every analysis response
+const (
+	VerdictApprove     = "approve"
+	VerdictSuggestions = "suggestions"
+	VerdictError       = "error"
+)
+
+// Values accepted for the enumerated suggestion fields, compared case-insensitively
+var (
+	severities     = []string{"high", "medium", "low"}
+	dashboardTypes = []string{"grafana", "datadog", "amplitude"}
+)
+
+var (
+	// PrometheusAlertTypes are the alert types created as Prometheus rules
+	PrometheusAlertTypes = []string{"metric", "prometheus"}
+	// AlertTypes are the alert types TracePR can create; the prompt, the validator and the
+	// alerts command all use this list
+	AlertTypes = append(slices.Clone(PrometheusAlertTypes), "datadog")
+)
+
+// llmResponseEnvelope is the top-level JSON object every analysis prompt asks the model to return
+type llmResponseEnvelope struct {
+	Verdict     string            `json:"verdict"`
+	Suggestions []json.RawMessage `json:"suggestions"`
+	Summary     string            `json:"summary"`
+}
+
+type fileSuggestionJSON struct {
+	File       string      `json:"file"`
+	StartLine  json.Number `json:"start_line,omitempty"`
+	Line       json.Number `json:"line"`
+	Suggestion string      `json:"suggestion"`
+	Severity   string      `json:"severity,omitempty"`
+}
+
+type dashboardSuggestionJSON struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Priority string          `json:"priority"`
+	Queries  json.RawMessage `json:"queries"`
+	Panels   json.RawMessage `json:"panels"`
+	Alerts   json.RawMessage `json:"alerts"`
+}
+
+type alertSuggestionJSON struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Priority     string `json:"priority"`
+	Query        string `json:"query"`
+	Description  string `json:"description"`
+	Threshold    string `json:"threshold"`
+	Duration     string `json:"duration"`
+	Notification string `json:"notification"`
+	RunbookLink  string `json:"runbook_link"`
+}
+
+// parseEnvelope extracts and decodes the JSON object from the model's response
+func parseEnvelope(llmResponse string) (*llmResponseEnvelope, error) {
+	jsonText := strings.TrimSpace(llmResponse)
+	if !json.Valid([]byte(jsonText)) {
+		jsonText = ExtractJSONFromText(llmResponse)
+	}
+	if jsonText == "" {
+		return nil, fmt.Errorf("no JSON object found in response")
+	}
+
+	var envelope llmResponseEnvelope
+	if err := json.Unmarshal([]byte(jsonText), &envelope); err != nil {
+		return nil, fmt.Errorf("response is not valid JSON: %v", err)
+	}
+	envelope.Verdict = strings.ToLower(strings.TrimSpace(envelope.Verdict))
+	return &envelope, nil
+}
+
+// checkVerdict makes sure the verdict is present and consistent with the suggestions list
+func checkVerdict(envelope *llmResponseEnvelope) error {
+	switch envelope.Verdict {
+	case VerdictApprove:
+		if len(envelope.Suggestions) > 0 {
+			return fmt.Errorf("verdict is %q but %d suggestions were returned", VerdictApprove, len(envelope.Suggestions))
+		}
+	case VerdictSuggestions:
+		if len(envelope.Suggestions) == 0 {
+			return fmt.Errorf("verdict is %q but the suggestions array is empty", VerdictSuggestions)
+		}
+	case VerdictError:
+		return fmt.Errorf("model reported it could not complete the analysis: %s", envelope.Summary)
+	case "":
+		return fmt.Errorf("missing verdict, expected one of %s, %s or %s", VerdictApprove, VerdictSuggestions, VerdictError)
+	default:
+		return fmt.Errorf("unknown verdict %q, expected one of %s, %s or %s", envelope.Verdict, VerdictApprove, VerdictSuggestions, VerdictError)
+	}
+	return nil
+}
+
+// ParseLLMVerdict returns the verdict field of the model's JSON response
+func ParseLLMVerdict(llmResponse string) (string, error) {
+	envelope, err := parseEnvelope(llmResponse)
+	if err != nil {
+		return "", err
+	}
+	if err := checkVerdict(envelope); err != nil {
+		return "", err
+	}
+	return envelope.Verdict, nil
+}
+
+// ParseLLMSuggestionsForObservability extracts file-based suggestions from the model's JSON response
 func ParseLLMSuggestionsForObservability(llmResponse string) ([]config.FileSuggestion, error) {
 	suggestions := []config.FileSuggestion{}
 
-	// Check if response is LGTM
-	if strings.Contains(llmResponse, "LGTM") {
-		return suggestions, nil // Return empty suggestions for LGTM case
+	envelope, err := parseEnvelope(llmResponse)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVerdict(envelope); err != nil {
+		return nil, err
 	}
 
-	// Find all suggestion blocks
-	suggestionPattern := regexp.MustCompile(`FILE: (.+?)\nLINE: (\d+)\nSUGGESTION:\n` +
-		"```diff\n" + `((?s:.+?))` + "```")
-	matches := suggestionPattern.FindAllStringSubmatch(llmResponse, -1)
-
-	for _, match := range matches {
-		if len(match) != 4 {
+	var rejected []RejectedItem
+	for i, raw := range envelope.Suggestions {
+		var item fileSuggestionJSON
+		if err := json.Unmarshal(raw, &item); err != nil {
+			rejected = append(rejected, RejectedItem{Index: i, Reason: fmt.Sprintf("malformed object: %v", err)})
 			continue
 		}
 
-		fileName := match[1]
-		lineNum := match[2]
-		diffContent := match[3]
+		reason := ""
+		lineNum, lineErr := strconv.Atoi(item.Line.String())
+		startLine, startErr := 0, error(nil)
+		if item.StartLine != "" {
+			startLine, startErr = strconv.Atoi(item.StartLine.String())
+		}
+		switch {
+		case strings.TrimSpace(item.File) == "":
+			reason = "missing file"
+		case lineErr != nil || lineNum <= 0:
+			reason = fmt.Sprintf("line must be a positive integer, got %q", item.Line.String())
+		case startErr != nil || startLine < 0 || startLine > lineNum:
+			reason = fmt.Sprintf("start_line must be a positive integer no greater than line, got %q", item.StartLine.String())
+		case strings.TrimSpace(item.Suggestion) == "":
+			reason = "missing suggestion"
+		case item.Severity != "" && !oneOf(item.Severity, severities...):
+			reason = fmt.Sprintf("severity must be %s, got %q", choices(severities), item.Severity)
+		}
+		if reason != "" {
+			rejected = append(rejected, RejectedItem{Index: i, Name: item.File, Reason: reason})
+			continue
+		}
 
-		// Parse the diff content to get actual change
 		suggestion := config.FileSuggestion{
-			FileName: fileName,
-			LineNum:  lineNum,
-			Content:  ExtractActualContent(diffContent),
+			FileName: strings.TrimSpace(item.File),
+			LineNum:  strconv.Itoa(lineNum),
+			Content:  normalizeSuggestionContent(item.Suggestion),
+			Severity: strings.ToLower(strings.TrimSpace(item.Severity)),
+		}
+		if startLine > 0 && startLine < lineNum {
+			suggestion.StartLineNum = strconv.Itoa(startLine)
 		}
-
 		suggestions = append(suggestions, suggestion)
 	}
 
+	if len(rejected) > 0 {
+		return suggestions, &ValidationError{Kind: "observability", Accepted: len(suggestions), Rejected: rejected}
+	}
 	return suggestions, nil
 }
 
-// ParseLLMSuggestionsForDashboards extracts dashboard suggestions from Claude's response
+// ParseLLMSuggestionsForDashboards extracts dashboard suggestions from the model's JSON response
 func ParseLLMSuggestionsForDashboards(llmResponse string) ([]config.DashboardSuggestion, error) {
 	suggestions := []config.DashboardSuggestion{}
 
-	// Check if response is LGTM
-	if strings.Contains(llmResponse, "LGTM") {
-		return suggestions, nil
+	envelope, err := parseEnvelope(llmResponse)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVerdict(envelope); err != nil {
+		return nil, err
 	}
 
-	// Find all dashboard suggestion blocks
-	dashboardPattern := regexp.MustCompile(`DASHBOARD: (.+?)\nTYPE: (.+?)\nPRIORITY: (.+?)\nQUERIES:\n` +
-		"```json\n" + `((?s:.+?))` + "```\n" +
-		`PANELS:\n` + "```json\n" + `((?s:.+?))` + "```\n" +
-		`ALERTS:\n` + "```json\n" + `((?s:.+?))` + "```")
-
-	matches := dashboardPattern.FindAllStringSubmatch(llmResponse, -1)
-
-	for _, match := range matches {
-		if len(match) != 7 {
+	var rejected []RejectedItem
+	for i, raw := range envelope.Suggestions {
+		var item dashboardSuggestionJSON
+		if err := json.Unmarshal(raw, &item); err != nil {
+			rejected = append(rejected, RejectedItem{Index: i, Reason: fmt.Sprintf("malformed object: %v", err)})
 			continue
 		}
 
-		name := match[1]
-		dashboardType := match[2] // "grafana" or "amplitude"
-		priority := match[3]
-		queries := match[4]
-		panels := match[5]
-		alerts := match[6]
+		reason := ""
+		switch {
+		case strings.TrimSpace(item.Name) == "":
+			reason = "missing name"
+		case !oneOf(item.Type, dashboardTypes...):
+			reason = fmt.Sprintf("type must be %s, got %q", choices(dashboardTypes), item.Type)
+		case !oneOf(item.Priority, "high", "medium", "low"):
+			reason = fmt.Sprintf("priority must be High, Medium or Low, got %q", item.Priority)
+		case !isJSONArray(item.Queries):
+			reason = "queries must be a JSON array"
+		case !isJSONArray(item.Panels):
+			reason = "panels must be a JSON array"
+		case len(item.Alerts) > 0 && !isJSONArray(item.Alerts):
+			reason = "alerts must be a JSON array"
+		}
+		if reason != "" {
+			rejected = append(rejected, RejectedItem{Index: i, Name: item.Name, Reason: reason})
+			continue
+		}
 
-		suggestion := config.DashboardSuggestion{
-			Name:     name,
-			Type:     dashboardType,
-			Priority: priority,
-			Queries:  queries,
-			Panels:   panels,
-			Alerts:   alerts,
+		alerts := "[]"
+		if len(item.Alerts) > 0 {
+			alerts = indentJSON(item.Alerts)
 		}
 
-		suggestions = append(suggestions, suggestion)
+		suggestions = append(suggestions, config.DashboardSuggestion{
+			Name:     strings.TrimSpace(item.Name),
+			Type:     strings.ToLower(item.Type),
+			Priority: item.Priority,
+			Queries:  indentJSON(item.Queries),
+			Panels:   indentJSON(item.Panels),
+			Alerts:   alerts,
+		})
 	}
 
+	if len(rejected) > 0 {
+		return suggestions, &ValidationError{Kind: "dashboard", Accepted: len(suggestions), Rejected: rejected}
+	}
 	return suggestions, nil
 }
 
+// ParseLLMSuggestionsForAlerts extracts alert suggestions from the model's JSON response
 func ParseLLMSuggestionsForAlerts(llmResponse string) ([]config.AlertSuggestion, error) {
 	suggestions := []config.AlertSuggestion{}
 
-	// Check if response is LGTM
-	if strings.Contains(llmResponse, "LGTM") {
-		return suggestions, nil
+	envelope, err := parseEnvelope(llmResponse)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVerdict(envelope); err != nil {
+		return nil, err
 	}
 
-	// Find all alert suggestion blocks based on the format in BuildAlertsPrompt
-	alertPattern := regexp.MustCompile(`ALERT: (.+?)\nTYPE: (.+?)\nPRIORITY: (.+?)\nQUERY:\n` +
-		"```\n" + `((?s:.+?))` + "```\n" +
-		`DESCRIPTION: (.+?)\n` +
-		`THRESHOLD: (.+?)\n` +
-		`DURATION: (.+?)\n` +
-		`NOTIFICATION: (.+?)\n` +
-		`RUNBOOK_LINK: (.+?)(?:\n\n|\n?$)`)
-
-	matches := alertPattern.FindAllStringSubmatch(llmResponse, -1)
-
-	for _, match := range matches {
-		if len(match) != 10 {
+	var rejected []RejectedItem
+	for i, raw := range envelope.Suggestions {
+		var item alertSuggestionJSON
+		if err := json.Unmarshal(raw, &item); err != nil {
+			rejected = append(rejected, RejectedItem{Index: i, Reason: fmt.Sprintf("malformed object: %v", err)})
 			continue
 		}
 
-		suggestion := config.AlertSuggestion{
-			Name:         match[1],
-			Type:         match[2],
-			Priority:     match[3],
-			Query:        strings.TrimSpace(match[4]),
-			Description:  match[5],
-			Threshold:    match[6],
-			Duration:     match[7],
-			Notification: match[8],
-			RunbookLink:  match[9],
+		reason := ""
+		switch {
+		case strings.TrimSpace(item.Name) == "":
+			reason = "missing name"
+		case !oneOf(item.Type, AlertTypes...):
+			reason = fmt.Sprintf("type must be %s, got %q", choices(AlertTypes), item.Type)
+		case !oneOf(item.Priority, "p0", "p1", "p2"):
+			reason = fmt.Sprintf("priority must be P0, P1 or P2, got %q", item.Priority)
+		case strings.TrimSpace(item.Query) == "":
+			reason = "missing query"
+		case strings.TrimSpace(item.Description) == "":
+			reason = "missing description"
+		}
+		if reason != "" {
+			rejected = append(rejected, RejectedItem{Index: i, Name: item.Name, Reason: reason})
+			continue
 		}
 
-		suggestions = append(suggestions, suggestion)
+		suggestions = append(suggestions, config.AlertSuggestion{
+			Name:         strings.TrimSpace(item.Name),
+			Type:         strings.ToLower(item.Type),
+			Priority:     item.Priority,
+			Query:        strings.TrimSpace(item.Query),
+			Description:  item.Description,
+			Threshold:    item.Threshold,
+			Duration:     item.Duration,
+			Notification: item.Notification,
+			RunbookLink:  item.RunbookLink,
+		})
 	}
 
+	if len(rejected) > 0 {
+		return suggestions, &ValidationError{Kind: "alert", Accepted: len(suggestions), Rejected: rejected}
+	}
 	return suggestions, nil
 }
 
+// ParseLLMSummary returns the summary field of the model's JSON response, falling back
+// to a free-text "SUMMARY:" section for models that ignore the output contract
 func ParseLLMSummary(llmResponse string) (string, error) {
+	if envelope, err := parseEnvelope(llmResponse); err == nil && strings.TrimSpace(envelope.Summary) != "" {
+		return strings.TrimSpace(envelope.Summary), nil
+	}
+
 	// Match everything from "SUMMARY:" to either the next section marker or end of text
 	summaryPattern := regexp.MustCompile(`(?s)SUMMARY:\s*(.*?)(?:\n\n##|\n\nFILE:|$)`)
 
@@ -147,3 +348,49 @@ func ParseLLMSummary(llmResponse string) (string, error) {
 	// Return the captured content and trim any trailing whitespace
 	return strings.TrimSpace(matches[1]), nil
 }
+
+// normalizeSuggestionContent strips diff markers if the model returned a diff instead of plain code
+func normalizeSuggestionContent(content string) string {
+	lines := strings.Split(strings.Trim(content, "\n"), "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "+") {
+			return strings.Trim(content, "\n")
+		}
+	}
+	return ExtractActualContent(content)
+}
+
+func oneOf(value string, allowed ...string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// choices lists the allowed values for an error message, e.g. "a, b or c"
+func choices(allowed []string) string {
+	if len(allowed) < 2 {
+		return strings.Join(allowed, "")
+	}
+	return strings.Join(allowed[:len(allowed)-1], ", ") + " or " + allowed[len(allowed)-1]
+}
+
+func isJSONArray(raw json.RawMessage) bool {
+	var arr []any
+	return len(raw) > 0 && json.Unmarshal(raw, &arr) == nil
+}
+
+func indentJSON(raw json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(raw)
+	}
+	return string(b)
+}
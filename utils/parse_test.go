@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func alertResponse(alertType string) string {
+	return fmt.Sprintf(`{"verdict": "suggestions", "summary": "s", "suggestions": [
+		{"name": "High Error Rate", "type": %q, "priority": "P1", "query": "rate(errors[5m]) > 0.1", "description": "Errors are up"}
+	]}`, alertType)
+}
+
+func TestParseLLMSuggestionsForAlertsTypes(t *testing.T) {
+	for _, alertType := range AlertTypes {
+		if _, err := ParseLLMSuggestionsForAlerts(alertResponse(strings.ToUpper(alertType))); err != nil {
+			t.Errorf("type %q was rejected: %v", alertType, err)
+		}
+	}
+
+	// Log alerts can't be created, so they are rejected up front
+	for _, unsupported := range []string{"pagerduty", "log"} {
+		if _, err := ParseLLMSuggestionsForAlerts(alertResponse(unsupported)); err == nil {
+			t.Errorf("type %s was accepted", unsupported)
+		}
+	}
+
+	_, err := ParseLLMSuggestionsForAlerts(alertResponse("pagerduty"))
+	// The repair prompt quotes this message, so it must name every accepted type
+	for _, alertType := range AlertTypes {
+		if !strings.Contains(err.Error(), alertType) {
+			t.Errorf("error %q does not mention accepted type %q", err, alertType)
+		}
+	}
+}
+
+func TestChoices(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		want    string
+	}{
+		{[]string{"metric"}, "metric"},
+		{[]string{"metric", "log"}, "metric or log"},
+		{[]string{"metric", "log", "prometheus", "datadog"}, "metric, log, prometheus or datadog"},
+	}
+	for _, tt := range tests {
+		if got := choices(tt.allowed); got != tt.want {
+			t.Errorf("choices(%v) = %q, want %q", tt.allowed, got, tt.want)
+		}
+	}
+}
@@ -46,12 +46,20 @@ func ExtractJSONFromText(text string) string {
 	// Try finding JSON between { and }
 	startIdx = strings.Index(text, "{")
 	if startIdx != -1 {
-		// Find the matching closing brace
+		// Find the matching closing brace, ignoring braces inside string literals
 		braceCount := 1
+		inString := false
 		for i := startIdx + 1; i < len(text); i++ {
-			if text[i] == '{' {
+			switch {
+			case inString && text[i] == '\\':
+				i++ // Skip the escaped character
+			case text[i] == '"':
+				inString = !inString
+			case inString:
+				continue
+			case text[i] == '{':
 				braceCount++
-			} else if text[i] == '}' {
+			case text[i] == '}':
 				braceCount--
 				if braceCount == 0 {
 					return text[startIdx : i+1]
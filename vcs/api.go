@@ -0,0 +1,143 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRetryAfter bounds how long a request waits when the host rate limits it
+	maxRetryAfter = 2 * time.Minute
+	// maxRateLimitRetries is how often a rate limited request is retried
+	maxRateLimitRetries = 3
+)
+
+// APIClient sends requests to the REST API of a source control host that has no Go SDK in
+// this repo. Rate limited requests are retried after the host's Retry-After delay.
+type APIClient struct {
+	Name       string // host name used in errors, e.g. "GitLab"
+	BaseURL    string // API root, e.g. https://gitlab.com/api/v4
+	HTTPClient *http.Client
+	Authorize  func(req *http.Request) // adds the host's auth header
+}
+
+// NewAPIClient returns a client for the API at baseURL
+func NewAPIClient(name, baseURL string, authorize func(req *http.Request)) *APIClient {
+	return &APIClient{
+		Name:       name,
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		Authorize:  authorize,
+	}
+}
+
+// Do sends body as JSON and decodes a JSON response into out when it is non-nil.
+// path is relative to BaseURL unless it is already an absolute URL.
+func (c *APIClient) Do(ctx context.Context, method, path string, query url.Values, body interface{}, out interface{}) (*http.Response, error) {
+	var payload []byte
+	contentType := ""
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling request: %v", err)
+		}
+		contentType = "application/json"
+	}
+
+	resp, respBody, err := c.DoRaw(ctx, method, path, query, contentType, payload)
+	if err != nil {
+		return resp, err
+	}
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp, fmt.Errorf("error parsing %s response: %v", c.Name, err)
+		}
+	}
+	return resp, nil
+}
+
+// DoRaw sends payload with the given content type and returns the raw response body
+func (c *APIClient) DoRaw(ctx context.Context, method, path string, query url.Values, contentType string, payload []byte) (*http.Response, []byte, error) {
+	endpoint := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		endpoint = c.BaseURL + path
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating HTTP request: %v", err)
+		}
+		if c.Authorize != nil {
+			c.Authorize(req)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error making request to %s API: %v", c.Name, err)
+		}
+
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitRetries {
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			delay := time.Duration(wait) * time.Second
+			if delay <= 0 {
+				delay = 10 * time.Second
+			}
+			if delay > maxRetryAfter {
+				return resp, respBody, fmt.Errorf("%s rate limit resets in %s", c.Name, delay)
+			}
+			log.Printf("%s rate limit hit, waiting %s", c.Name, delay)
+			select {
+			case <-ctx.Done():
+				return resp, respBody, ctx.Err()
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return resp, respBody, fmt.Errorf("%s API error (%d) for %s %s: %s", c.Name, resp.StatusCode, method, path, string(respBody))
+		}
+		return resp, respBody, nil
+	}
+}
+
+// IsNotFound reports whether a failed request was answered with 404
+func IsNotFound(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusNotFound
+}
+
+// NextLink returns the rel="next" URL of an RFC 8288 Link header, or "" on the last page
+func NextLink(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,308 @@
+package vcs
+
+import (
+	"tracepr/config"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Comment is a conversation comment on a pull or merge request
+type Comment struct {
+	ID     int64
+	Author string // login of the user who wrote it, or the account UUID on Bitbucket
+	Body   string
+}
+
+// OwnComments returns the comments written by self, the user TracePR posts as. Anyone can
+// copy a TracePR marker into their own comment, so only these are trusted or edited.
+func OwnComments(comments []Comment, self string) []Comment {
+	var own []Comment
+	for _, comment := range comments {
+		if self != "" && strings.EqualFold(comment.Author, self) {
+			own = append(own, comment)
+		}
+	}
+	return own
+}
+
+// ParseDashboardSuggestions recovers the dashboard suggestions TracePR posted as comments,
+// ignoring ones marked as outdated and any written by someone other than self
+func ParseDashboardSuggestions(comments []Comment, self string) []config.DashboardSuggestion {
+	var allSuggestions []config.DashboardSuggestion
+
+	// Process each comment to find dashboard suggestions
+	for _, comment := range OwnComments(comments, self) {
+		body := comment.Body
+		kind, _, ok := ParseMarker(body)
+		if ok && kind == MarkerOutdated {
+			continue
+		}
+
+		// Prefer the structured copy, which survives edits to the visible text
+		var saved config.DashboardSuggestion
+		if ok && kind == MarkerDashboard && ParseData(body, &saved) && saved.Name != "" {
+			allSuggestions = append(allSuggestions, saved)
+			continue
+		}
+
+		// Comments from older versions only have the markdown
+		if strings.Contains(body, "Dashboard Suggestion") {
+			suggestion := parseDashboardSuggestionFromComment(body)
+			if suggestion != nil {
+				allSuggestions = append(allSuggestions, *suggestion)
+			}
+		}
+	}
+
+	return allSuggestions
+}
+
+// ParseAlertSuggestions recovers the alert suggestions TracePR posted as comments,
+// ignoring ones marked as outdated and any written by someone other than self
+func ParseAlertSuggestions(comments []Comment, self string) []config.AlertSuggestion {
+	var allSuggestions []config.AlertSuggestion
+
+	// Process each comment to find alert suggestions
+	for _, comment := range OwnComments(comments, self) {
+		body := comment.Body
+		kind, _, ok := ParseMarker(body)
+		if ok && kind == MarkerOutdated {
+			continue
+		}
+
+		// Prefer the structured copy, which survives edits to the visible text
+		var saved config.AlertSuggestion
+		if ok && kind == MarkerAlert && ParseData(body, &saved) && saved.Name != "" {
+			allSuggestions = append(allSuggestions, saved)
+			continue
+		}
+
+		// Comments from older versions only have the markdown
+		if strings.Contains(body, "Alert Suggestion") {
+			suggestion := parseAlertSuggestionFromComment(body)
+			if suggestion != nil {
+				allSuggestions = append(allSuggestions, *suggestion)
+			}
+		}
+	}
+
+	return allSuggestions
+}
+
+// DashboardComments renders the summary, one comment per dashboard suggestion and the
+// "Create All" comment TracePR keeps on the pull or merge request
+func DashboardComments(suggestions []config.DashboardSuggestion, prDetails map[string]interface{}, summary string) []MarkedComment {
+	var desired []MarkedComment
+
+	// Post the summary comment first
+	if summary != "" {
+		desired = append(desired, MarkedComment{Kind: MarkerSummary, Key: "dashboard", Body: summary + FormatSkippedFiles(prDetails)})
+	}
+
+	// Create a detailed comment for each dashboard suggestion
+	log.Printf("Processing %d dashboard suggestions", len(suggestions))
+	for _, suggestion := range suggestions {
+		log.Printf("Creating comment for dashboard suggestion: %s", suggestion.Name)
+		// Format a readable dashboard suggestion comment
+		commentBody := fmt.Sprintf("## Dashboard Suggestion: %s\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("**Type:** %s\n", suggestion.Type)
+		commentBody += fmt.Sprintf("**Priority:** %s\n\n", suggestion.Priority)
+
+		commentBody += "### Queries\n```json\n" + suggestion.Queries + "\n```\n\n"
+		commentBody += "### Panels\n```json\n" + suggestion.Panels + "\n```\n\n"
+		commentBody += "### Alerts\n```json\n" + suggestion.Alerts + "\n```\n\n"
+
+		// Add action buttons - these will be parsed by the GitHub action
+		commentBody += "<details>\n"
+		commentBody += "<summary>Click to create this dashboard</summary>\n\n"
+		commentBody += fmt.Sprintf("To create this dashboard, comment with:\n\n`tracepr dashboard --create  %s`\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("or, where the `tracepr serve` webhook is installed:\n\n`/tracepr dashboard create %s`\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("<!-- DASHBOARD_CREATE:%s:%s -->\n", suggestion.Type, suggestion.Name)
+		commentBody += "</details>\n"
+		commentBody = WithData(commentBody, suggestion)
+
+		desired = append(desired, MarkedComment{Kind: MarkerDashboard, Key: suggestion.Type + ":" + suggestion.Name, Body: commentBody})
+	}
+
+	// Add a comment for creating all dashboards at once
+	allDashboardsComment := "## Create All Dashboards\n\n"
+	allDashboardsComment += "To create all suggested dashboards, comment with:\n\n`tracepr dashboard --create-all`\n\n"
+	desired = append(desired, MarkedComment{Kind: MarkerCreateAll, Key: "dashboards", Body: allDashboardsComment})
+
+	return desired
+}
+
+// AlertComments renders one comment per alert suggestion and the "Create All" comment
+// TracePR keeps on the pull or merge request
+func AlertComments(suggestions []config.AlertSuggestion) []MarkedComment {
+	var desired []MarkedComment
+
+	// Create a detailed comment for each alert suggestion
+	log.Printf("Processing %d alert suggestions", len(suggestions))
+	for _, suggestion := range suggestions {
+		log.Printf("Creating comment for alert suggestion: %s", suggestion.Name)
+		// Format a readable alert suggestion comment
+		commentBody := fmt.Sprintf("## Alert Suggestion: %s\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("**Type:** %s\n", suggestion.Type)
+		commentBody += fmt.Sprintf("**Priority:** %s\n\n", suggestion.Priority)
+
+		commentBody += "### Query\n```json\n" + suggestion.Query + "\n```\n\n"
+		commentBody += fmt.Sprintf("### Description\n%s\n\n", suggestion.Description)
+		commentBody += fmt.Sprintf("### Threshold\n%s\n\n", suggestion.Threshold)
+		commentBody += fmt.Sprintf("### Duration\n%s\n\n", suggestion.Duration)
+		commentBody += fmt.Sprintf("### Notification\n%s\n\n", suggestion.Notification)
+
+		if suggestion.RunbookLink != "" {
+			commentBody += fmt.Sprintf("### Runbook\n[Link to Runbook](%s)\n\n", suggestion.RunbookLink)
+		}
+
+		// Add action buttons - these will be parsed by the GitHub action
+		commentBody += "<details>\n"
+		commentBody += "<summary>Click to create this alert</summary>\n\n"
+		commentBody += fmt.Sprintf("To create this alert, comment with:\n\n`tracepr alert --create %s`\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("or, where the `tracepr serve` webhook is installed:\n\n`/tracepr alert create %s`\n\n", suggestion.Name)
+		commentBody += fmt.Sprintf("<!-- ALERT_CREATE:%s:%s -->\n", suggestion.Type, suggestion.Name)
+		commentBody += "</details>\n"
+		commentBody = WithData(commentBody, suggestion)
+
+		desired = append(desired, MarkedComment{Kind: MarkerAlert, Key: suggestion.Type + ":" + suggestion.Name, Body: commentBody})
+	}
+
+	// Add a comment for creating all alerts at once
+	allAlertsComment := "## Create All Alerts\n\n"
+	allAlertsComment += "To create all suggested alerts, comment with:\n\n`tracepr alert --create-all`\n\n"
+	desired = append(desired, MarkedComment{Kind: MarkerCreateAll, Key: "alerts", Body: allAlertsComment})
+
+	return desired
+}
+
+func parseDashboardSuggestionFromComment(commentBody string) *config.DashboardSuggestion {
+	// Extract name from the title line
+	nameMatch := regexp.MustCompile(`## Dashboard Suggestion: (.+)`).FindStringSubmatch(commentBody)
+	if len(nameMatch) < 2 {
+		return nil
+	}
+	name := strings.TrimSpace(nameMatch[1])
+
+	// Extract type
+	typeMatch := regexp.MustCompile(`\*\*Type:\*\* (.+)`).FindStringSubmatch(commentBody)
+	if len(typeMatch) < 2 {
+		return nil
+	}
+	dashboardType := strings.TrimSpace(typeMatch[1])
+
+	// Extract priority
+	priorityMatch := regexp.MustCompile(`\*\*Priority:\*\* (.+)`).FindStringSubmatch(commentBody)
+	priority := "medium" // Default
+	if len(priorityMatch) >= 2 {
+		priority = strings.TrimSpace(priorityMatch[1])
+	}
+
+	// Extract queries
+	queriesMatch := regexp.MustCompile(`### Queries\n` + "```json\n" + `([\s\S]*?)\n` + "```").FindStringSubmatch(commentBody)
+	queries := ""
+	if len(queriesMatch) >= 2 {
+		queries = queriesMatch[1]
+	}
+
+	// Extract panels
+	panelsMatch := regexp.MustCompile(`### Panels\n` + "```json\n" + `([\s\S]*?)\n` + "```").FindStringSubmatch(commentBody)
+	panels := ""
+	if len(panelsMatch) >= 2 {
+		panels = panelsMatch[1]
+	}
+
+	// Extract alerts
+	alertsMatch := regexp.MustCompile(`### Alerts\n` + "```json\n" + `([\s\S]*?)\n` + "```").FindStringSubmatch(commentBody)
+	alerts := ""
+	if len(alertsMatch) >= 2 {
+		alerts = alertsMatch[1]
+	}
+
+	return &config.DashboardSuggestion{
+		Name:     name,
+		Type:     dashboardType,
+		Priority: priority,
+		Queries:  queries,
+		Panels:   panels,
+		Alerts:   alerts,
+	}
+}
+
+func parseAlertSuggestionFromComment(commentBody string) *config.AlertSuggestion {
+	// Extract name from the title line
+	nameMatch := regexp.MustCompile(`Alert Suggestion: (.+)`).FindStringSubmatch(commentBody)
+	if len(nameMatch) < 2 {
+		return nil
+	}
+	name := strings.TrimSpace(nameMatch[1])
+
+	// Extract type and priority
+	typeMatch := regexp.MustCompile(`\*\*Type:\*\* ([^\s]+)`).FindStringSubmatch(commentBody)
+	if len(typeMatch) < 2 {
+		return nil
+	}
+	alertType := strings.TrimSpace(typeMatch[1])
+
+	priorityMatch := regexp.MustCompile(`\*\*Priority:\*\* ([^\s]+)`).FindStringSubmatch(commentBody)
+	if len(priorityMatch) < 2 {
+		return nil
+	}
+	priority := strings.TrimSpace(priorityMatch[1])
+
+	// Extract query
+	queryMatch := regexp.MustCompile(`Query\n\n` + "```\n" + `([\s\S]*?)\n` + "```").FindStringSubmatch(commentBody)
+	query := ""
+	if len(queryMatch) >= 2 {
+		query = strings.TrimSpace(queryMatch[1])
+	}
+
+	// Extract description
+	descMatch := regexp.MustCompile(`Description\n([^\n]+)`).FindStringSubmatch(commentBody)
+	description := ""
+	if len(descMatch) >= 2 {
+		description = strings.TrimSpace(descMatch[1])
+	}
+
+	// Extract threshold
+	thresholdMatch := regexp.MustCompile(`Threshold\n([^\n]+)`).FindStringSubmatch(commentBody)
+	threshold := ""
+	if len(thresholdMatch) >= 2 {
+		threshold = strings.TrimSpace(thresholdMatch[1])
+	}
+
+	// Extract duration
+	durationMatch := regexp.MustCompile(`Duration\n([^\n]+)`).FindStringSubmatch(commentBody)
+	duration := ""
+	if len(durationMatch) >= 2 {
+		duration = strings.TrimSpace(durationMatch[1])
+	}
+
+	// Extract notification
+	notificationMatch := regexp.MustCompile(`Notification\n([^\n]+)`).FindStringSubmatch(commentBody)
+	notification := ""
+	if len(notificationMatch) >= 2 {
+		notification = strings.TrimSpace(notificationMatch[1])
+	}
+
+	// Extract runbook
+	runbookMatch := regexp.MustCompile(`Runbook\n([^\n]+)`).FindStringSubmatch(commentBody)
+	runbook := ""
+	if len(runbookMatch) >= 2 {
+		runbook = strings.TrimSpace(runbookMatch[1])
+	}
+
+	return &config.AlertSuggestion{
+		Name:         name,
+		Type:         alertType,
+		Priority:     priority,
+		Query:        query,
+		Description:  description,
+		Threshold:    threshold,
+		Duration:     duration,
+		Notification: notification,
+		RunbookLink:  runbook,
+	}
+}
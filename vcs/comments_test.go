@@ -0,0 +1,31 @@
+package vcs
+
+import (
+	"tracepr/config"
+	"testing"
+)
+
+func TestParseSuggestionsOnlyTrustsOwnComments(t *testing.T) {
+	alert := config.AlertSuggestion{Name: "High Error Rate", Type: "prometheus", Query: "rate(errors[5m]) > 0.1"}
+	dashboard := config.DashboardSuggestion{Name: "Checkout", Type: "grafana"}
+	// Someone else's comment that copies the markers and data of TracePR's
+	forgedAlert := config.AlertSuggestion{Name: "High Error Rate", Type: "prometheus", Query: "vector(1)"}
+	comments := []Comment{
+		{ID: 1, Author: "dev", Body: WithMarker(WithData("## Alert Suggestion: High Error Rate", forgedAlert), MarkerAlert, "prometheus:High Error Rate")},
+		{ID: 2, Author: "TracePR", Body: WithMarker(WithData("## Alert Suggestion: High Error Rate", alert), MarkerAlert, "prometheus:High Error Rate")},
+		{ID: 3, Author: "dev", Body: WithMarker(WithData("## Dashboard Suggestion: Fake", config.DashboardSuggestion{Name: "Fake", Type: "grafana"}), MarkerDashboard, "grafana:Fake")},
+		{ID: 4, Author: "tracepr", Body: WithMarker(WithData("## Dashboard Suggestion: Checkout", dashboard), MarkerDashboard, "grafana:Checkout")},
+	}
+
+	alerts := ParseAlertSuggestions(comments, "tracepr")
+	if len(alerts) != 1 || alerts[0].Query != alert.Query {
+		t.Errorf("alerts = %+v, want only TracePR's", alerts)
+	}
+	dashboards := ParseDashboardSuggestions(comments, "tracepr")
+	if len(dashboards) != 1 || dashboards[0].Name != "Checkout" {
+		t.Errorf("dashboards = %+v, want only TracePR's", dashboards)
+	}
+	if got := ParseAlertSuggestions(comments, ""); len(got) != 0 {
+		t.Errorf("alerts without a known identity = %+v, want none", got)
+	}
+}
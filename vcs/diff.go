@@ -0,0 +1,181 @@
+package vcs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSnapDistance is how far (in lines) a suggestion may be moved to land on a line the host will accept
+const maxSnapDistance = 5
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// DiffHunk is a single "@@" section of a unified diff with the RIGHT-side lines it covers
+type DiffHunk struct {
+	OldStart   int
+	OldLines   int
+	NewStart   int
+	NewLines   int
+	RightLines []int       // new-file line numbers of context and added lines, in order
+	LeftLines  map[int]int // old-file line number of each context line, keyed by new-file line
+}
+
+// PatchIndex records which RIGHT-side lines of a file can receive review comments
+type PatchIndex struct {
+	Hunks []DiffHunk
+	Text  map[int]string // content of every RIGHT-side line in the diff, keyed by new-file line
+}
+
+// ParsePatch parses the unified diff hunks of a single file, as in a PR file's patch field
+func ParsePatch(patch string) (*PatchIndex, error) {
+	index := &PatchIndex{Text: make(map[int]string)}
+	var current *DiffHunk
+	oldLine, newLine := 0, 0
+
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("invalid hunk header: %q", line)
+			}
+			index.Hunks = append(index.Hunks, DiffHunk{
+				OldStart:  atoiDefault(match[1], 0),
+				OldLines:  atoiDefault(match[2], 1),
+				NewStart:  atoiDefault(match[3], 0),
+				NewLines:  atoiDefault(match[4], 1),
+				LeftLines: make(map[int]int),
+			})
+			current = &index.Hunks[len(index.Hunks)-1]
+			oldLine, newLine = current.OldStart, current.NewStart
+			continue
+		}
+		if current == nil {
+			// Lines before the first hunk header (e.g. file headers) are not commentable
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			current.RightLines = append(current.RightLines, newLine)
+			index.Text[newLine] = line[1:]
+			newLine++
+		case strings.HasPrefix(line, " "):
+			current.RightLines = append(current.RightLines, newLine)
+			current.LeftLines[newLine] = oldLine
+			index.Text[newLine] = line[1:]
+			oldLine++
+			newLine++
+		case strings.HasPrefix(line, "-"):
+			// Deletions only exist on the LEFT side
+			oldLine++
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline" markers are on neither side
+		case line == "":
+			// An empty context line whose leading space was stripped
+			if newLine < current.NewStart+current.NewLines {
+				current.RightLines = append(current.RightLines, newLine)
+				current.LeftLines[newLine] = oldLine
+				index.Text[newLine] = ""
+				oldLine++
+				newLine++
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// Contains reports whether line is a RIGHT-side line present in the diff
+func (p *PatchIndex) Contains(line int) bool {
+	return p.hunkFor(line) >= 0
+}
+
+// SameHunk reports whether both lines are in the diff and belong to the same hunk,
+// which GitHub requires for multi-line comments
+func (p *PatchIndex) SameHunk(a, b int) bool {
+	h := p.hunkFor(a)
+	return h >= 0 && h == p.hunkFor(b)
+}
+
+// Nearest returns the commentable line closest to line, if one is within maxDistance
+func (p *PatchIndex) Nearest(line, maxDistance int) (int, bool) {
+	best, bestDistance := 0, maxDistance+1
+	for _, hunk := range p.Hunks {
+		for _, candidate := range hunk.RightLines {
+			distance := candidate - line
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance < bestDistance {
+				best, bestDistance = candidate, distance
+			}
+		}
+	}
+	return best, bestDistance <= maxDistance
+}
+
+// OldLine returns the LEFT-side line of an unchanged context line, which some hosts
+// (e.g. GitLab) require alongside the new line when commenting on it. Added lines have none.
+func (p *PatchIndex) OldLine(line int) (int, bool) {
+	if h := p.hunkFor(line); h >= 0 {
+		old, ok := p.Hunks[h].LeftLines[line]
+		return old, ok
+	}
+	return 0, false
+}
+
+// Lines returns the new-file content of lines start to end, which must all be in the diff
+func (p *PatchIndex) Lines(start, end int) ([]string, bool) {
+	var lines []string
+	for line := start; line <= end; line++ {
+		text, ok := p.Text[line]
+		if !ok {
+			return nil, false
+		}
+		lines = append(lines, text)
+	}
+	return lines, len(lines) > 0
+}
+
+func (p *PatchIndex) hunkFor(line int) int {
+	for i, hunk := range p.Hunks {
+		n := sort.SearchInts(hunk.RightLines, line)
+		if n < len(hunk.RightLines) && hunk.RightLines[n] == line {
+			return i
+		}
+	}
+	return -1
+}
+
+// BuildPatchIndexes parses the patch of every file in prDetails, keyed by filename
+func BuildPatchIndexes(prDetails map[string]interface{}) map[string]*PatchIndex {
+	indexes := make(map[string]*PatchIndex)
+	files, _ := prDetails["files"].([]map[string]interface{})
+	for _, file := range files {
+		filename, _ := file["filename"].(string)
+		patch, _ := file["patch"].(string)
+		if filename == "" || patch == "" {
+			continue
+		}
+		index, err := ParsePatch(patch)
+		if err != nil {
+			continue
+		}
+		indexes[filename] = index
+	}
+	return indexes
+}
+
+func atoiDefault(s string, fallback int) int {
+	if s == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
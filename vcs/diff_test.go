@@ -0,0 +1,122 @@
+package vcs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		patch     string
+		wantRight [][]int     // RightLines of each hunk
+		wantLeft  map[int]int // old line of each context line, across hunks
+		wantText  map[int]string
+	}{
+		{
+			name:      "multiple hunks",
+			patch:     "@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -10,3 +11,2 @@\n x\n-y\n z",
+			wantRight: [][]int{{1, 2, 3}, {11, 12}},
+			wantLeft:  map[int]int{1: 1, 3: 2, 11: 10, 12: 12},
+			wantText:  map[int]string{1: "a", 2: "b", 3: "c", 11: "x", 12: "z"},
+		},
+		{
+			// A renamed file's patch may still carry the ---/+++ headers, which are not commentable
+			name:      "rename with file headers",
+			patch:     "--- a/old.go\n+++ b/new.go\n@@ -1 +1 @@\n-x\n+y",
+			wantRight: [][]int{{1}},
+			wantLeft:  map[int]int{},
+			wantText:  map[int]string{1: "y"},
+		},
+		{
+			name:      "binary file",
+			patch:     "",
+			wantRight: [][]int{},
+			wantLeft:  map[int]int{},
+			wantText:  map[int]string{},
+		},
+		{
+			name:      "no newline at end of file",
+			patch:     "@@ -1,2 +1,2 @@\n a\n-1.0\n\\ No newline at end of file\n+1.1\n\\ No newline at end of file",
+			wantRight: [][]int{{1, 2}},
+			wantLeft:  map[int]int{1: 1},
+			wantText:  map[int]string{1: "a", 2: "1.1"},
+		},
+		{
+			name:      "deleted file",
+			patch:     "@@ -1,2 +0,0 @@\n-a\n-b",
+			wantRight: [][]int{nil},
+			wantLeft:  map[int]int{},
+			wantText:  map[int]string{},
+		},
+		{
+			name:      "empty context line without its leading space",
+			patch:     "@@ -1,3 +1,4 @@\n a\n\n+b\n c\n",
+			wantRight: [][]int{{1, 2, 3, 4}},
+			wantLeft:  map[int]int{1: 1, 2: 2, 4: 3},
+			wantText:  map[int]string{1: "a", 2: "", 3: "b", 4: "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := ParsePatch(tt.patch)
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			right := [][]int{}
+			left := map[int]int{}
+			for _, hunk := range index.Hunks {
+				right = append(right, hunk.RightLines)
+				for newLine, oldLine := range hunk.LeftLines {
+					left[newLine] = oldLine
+				}
+			}
+			if fmt.Sprint(right) != fmt.Sprint(tt.wantRight) {
+				t.Errorf("RightLines = %v, want %v", right, tt.wantRight)
+			}
+			if fmt.Sprint(left) != fmt.Sprint(tt.wantLeft) {
+				t.Errorf("LeftLines = %v, want %v", left, tt.wantLeft)
+			}
+			if fmt.Sprint(index.Text) != fmt.Sprint(tt.wantText) {
+				t.Errorf("Text = %v, want %v", index.Text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestParsePatchRejectsInvalidHunkHeader(t *testing.T) {
+	if _, err := ParsePatch("@@ nonsense @@\n+a"); err == nil {
+		t.Error("ParsePatch accepted an invalid hunk header")
+	}
+}
+
+func TestPatchIndexLookups(t *testing.T) {
+	index, err := ParsePatch("@@ -1,2 +1,3 @@\n a\n+b\n c\n@@ -20,1 +21,2 @@\n x\n+y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.Contains(2) || index.Contains(10) {
+		t.Error("Contains disagrees with the hunks")
+	}
+	if !index.SameHunk(1, 3) || index.SameHunk(3, 21) {
+		t.Error("SameHunk disagrees with the hunks")
+	}
+	if line, ok := index.Nearest(8, maxSnapDistance); !ok || line != 3 {
+		t.Errorf("Nearest(8) = %d, %v, want 3", line, ok)
+	}
+	if _, ok := index.Nearest(12, maxSnapDistance); ok {
+		t.Error("Nearest(12) snapped further than maxSnapDistance")
+	}
+	if old, ok := index.OldLine(21); !ok || old != 20 {
+		t.Errorf("OldLine(21) = %d, %v, want 20", old, ok)
+	}
+	if _, ok := index.OldLine(22); ok {
+		t.Error("added line 22 has an old line")
+	}
+	if lines, ok := index.Lines(1, 3); !ok || fmt.Sprint(lines) != "[a b c]" {
+		t.Errorf("Lines(1, 3) = %v, %v", lines, ok)
+	}
+	if _, ok := index.Lines(3, 21); ok {
+		t.Error("Lines spanning lines outside the diff succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Kinds of hidden markers TracePR embeds in the comments it writes
+const (
+	MarkerSummary    = "summary"
+	MarkerSuggestion = "suggestion"
+	MarkerDashboard  = "dashboard"
+	MarkerAlert      = "alert"
+	MarkerCreateAll  = "create-all"
+	MarkerOutdated   = "outdated"
+)
+
+var markerPattern = regexp.MustCompile(`<!-- TRACEPR:([a-z-]+):(.*?) -->`)
+
+// Marker returns the hidden HTML comment identifying a TracePR comment across re-runs
+func Marker(kind, key string) string {
+	return fmt.Sprintf("<!-- TRACEPR:%s:%s -->", kind, key)
+}
+
+// ParseMarker extracts the first TracePR marker from a comment body
+func ParseMarker(body string) (kind, key string, ok bool) {
+	match := markerPattern.FindStringSubmatch(body)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// dataPattern matches the hidden block of structured data in a suggestion comment
+var dataPattern = regexp.MustCompile(`(?s)<!-- TRACEPR-DATA\n(.*?)\n-->\n?`)
+
+// WithData appends v as JSON in a hidden block so the suggestion can still be read back when the
+// visible text is edited. encoding/json escapes < and >, so the JSON can't close the comment early.
+func WithData(body string, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding comment data: %v", err)
+		return body
+	}
+	return body + "<!-- TRACEPR-DATA\n" + string(data) + "\n-->\n"
+}
+
+// ParseData decodes the hidden data block of a comment into v
+func ParseData(body string, v interface{}) bool {
+	match := dataPattern.FindStringSubmatch(body)
+	if match == nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(match[1]), v); err != nil {
+		log.Printf("Error parsing comment data: %v", err)
+		return false
+	}
+	return true
+}
+
+// WithMarker appends the hidden marker to a comment body
+func WithMarker(body, kind, key string) string {
+	return body + "\n\n" + Marker(kind, key)
+}
+
+// OutdatedBody replaces a stale TracePR comment so reviewers can see it no longer applies.
+// Suggestion blocks are downgraded to plain code so they can't be committed by accident.
+func OutdatedBody(previous, key string) string {
+	previous = markerPattern.ReplaceAllString(previous, "")
+	previous = dataPattern.ReplaceAllString(previous, "")
+	previous = strings.ReplaceAll(previous, "```suggestion", "```")
+	// Drop the legacy create markers so the comment is no longer picked up by the CI workflows
+	previous = regexp.MustCompile(`<!-- (DASHBOARD|ALERT)_CREATE:.*? -->`).ReplaceAllString(previous, "")
+	previous = regexp.MustCompile(`(?m)^## (Dashboard|Alert) Suggestion: `).ReplaceAllString(previous, "## Previous suggestion: ")
+
+	body := "**Outdated:** TracePR no longer makes this suggestion after the latest changes.\n\n"
+	body += "<details>\n<summary>Previous suggestion</summary>\n\n"
+	body += strings.TrimSpace(previous) + "\n\n</details>"
+	return WithMarker(body, MarkerOutdated, key)
+}
+
+// MarkedComment is a comment body TracePR wants present on the PR, identified by its marker
+type MarkedComment struct {
+	Kind string
+	Key  string
+	Body string
+}
+
+// SyncComments makes the conversation match the desired comments: existing comments with the
+// same marker are edited in place, missing ones are created, and previously posted comments of
+// the given stale kinds that are no longer desired are marked as outdated. Providers supply the
+// existing comments, self (the user TracePR posts as, whose comments are the only ones matched)
+// and the create and edit calls for their API.
+func SyncComments(existing []Comment, self string, desired []MarkedComment, create func(body string) error, edit func(id int64, body string) error, staleKinds ...string) error {
+	byMarker := make(map[string]Comment)
+	for _, comment := range OwnComments(existing, self) {
+		if kind, key, ok := ParseMarker(comment.Body); ok {
+			byMarker[kind+":"+key] = comment
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, comment := range desired {
+		id := comment.Kind + ":" + comment.Key
+		wanted[id] = true
+		body := WithMarker(comment.Body, comment.Kind, comment.Key)
+
+		if previous, ok := byMarker[id]; ok {
+			if previous.Body == body {
+				log.Printf("Comment %s is unchanged, skipping", id)
+				continue
+			}
+			log.Printf("Updating existing comment %s", id)
+			if err := edit(previous.ID, body); err != nil {
+				return fmt.Errorf("error updating comment %s: %v", id, err)
+			}
+			continue
+		}
+
+		log.Printf("Creating comment %s", id)
+		if err := create(body); err != nil {
+			return fmt.Errorf("error creating comment %s: %v", id, err)
+		}
+	}
+
+	for id, previous := range byMarker {
+		kind, key, _ := ParseMarker(previous.Body)
+		if wanted[id] || !containsString(staleKinds, kind) {
+			continue
+		}
+		log.Printf("Marking stale comment %s as outdated", id)
+		if err := edit(previous.ID, OutdatedBody(previous.Body, key)); err != nil {
+			return fmt.Errorf("error marking comment %s as outdated: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
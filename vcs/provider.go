@@ -0,0 +1,50 @@
+package vcs
+
+import (
+	"tracepr/config"
+	"context"
+)
+
+// Provider is implemented by every source-control host TracePR can review changes on.
+// Each provider is created for a single pull or merge request from the loaded config.
+type Provider interface {
+	// Name returns the host name used in logs, e.g. "github"
+	Name() string
+	// FetchChangeDetails returns the prDetails map describing the change and the config
+	// updated with its source branch
+	FetchChangeDetails(ctx context.Context) (config.Config, map[string]interface{}, error)
+	// PostReview posts the observability summary and inline suggestions as a review
+	PostReview(ctx context.Context, suggestions []config.FileSuggestion, prDetails map[string]interface{}, summary string) error
+	// SyncComments makes TracePR's conversation comments match desired, marking comments of
+	// the stale kinds that are no longer desired as outdated
+	SyncComments(ctx context.Context, desired []MarkedComment, staleKinds ...string) error
+	// ListComments returns every conversation comment on the change, from any author
+	ListComments(ctx context.Context) ([]Comment, error)
+	// Identity returns the user TracePR posts as, the only author whose comments it trusts
+	Identity(ctx context.Context) (string, error)
+	// CommitFiles writes and deletes files on the change's source branch in a single new commit
+	CommitFiles(ctx context.Context, changes []FileChange, message string) error
+}
+
+// FileChange is a file written, or deleted, by a commit
+type FileChange struct {
+	Path    string
+	Content string
+	Delete  bool
+}
+
+// CollapseFileChanges keeps only the last change to each path, in the order the paths were
+// first changed, since hosts reject commits touching a path twice
+func CollapseFileChanges(changes []FileChange) []FileChange {
+	index := make(map[string]int)
+	var collapsed []FileChange
+	for _, change := range changes {
+		if i, ok := index[change.Path]; ok {
+			collapsed[i] = change
+			continue
+		}
+		index[change.Path] = len(collapsed)
+		collapsed = append(collapsed, change)
+	}
+	return collapsed
+}
@@ -0,0 +1,240 @@
+package vcs
+
+import (
+	"tracepr/config"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ReviewComment is an inline or file-level review comment positioned against the diff
+type ReviewComment struct {
+	Key         string // file:line the model targeted, used to recognise the comment on re-runs
+	Path        string
+	Line        int
+	StartLine   int
+	SubjectType string // "line" or "file"
+	Body        string
+}
+
+// PlaceSuggestion maps a suggestion onto a position the host accepts for this diff.
+// Lines outside the diff are snapped to the nearest commentable line, and suggestions
+// that cannot be placed at all fall back to a file-level comment.
+func PlaceSuggestion(suggestion config.FileSuggestion, indexes map[string]*PatchIndex) (ReviewComment, error) {
+	lineNum, err := strconv.Atoi(suggestion.LineNum)
+	if err != nil {
+		return ReviewComment{}, fmt.Errorf("invalid line number %q: %v", suggestion.LineNum, err)
+	}
+	startLine, _ := strconv.Atoi(suggestion.StartLineNum)
+
+	index, ok := indexes[suggestion.FileName]
+	if !ok {
+		return ReviewComment{}, fmt.Errorf("file %s is not part of the analyzed diff", suggestion.FileName)
+	}
+
+	comment := ReviewComment{Key: SuggestionKey(suggestion), Path: suggestion.FileName, SubjectType: "line"}
+	switch {
+	case startLine > 0 && index.SameHunk(startLine, lineNum):
+		comment.StartLine = startLine
+		comment.Line = lineNum
+		comment.Body = fmt.Sprintf("```suggestion\n%s\n```", suggestion.Content)
+	case startLine == 0 && index.Contains(lineNum):
+		comment.Line = lineNum
+		comment.Body = fmt.Sprintf("```suggestion\n%s\n```", suggestion.Content)
+	default:
+		// A suggestion block would replace the wrong lines here, so show the code as plain text
+		target := DescribeLines(startLine, lineNum)
+		if nearest, found := index.Nearest(lineNum, maxSnapDistance); found {
+			log.Printf("Relocating suggestion for %s from %s to line %d", suggestion.FileName, target, nearest)
+			comment.Line = nearest
+			comment.Body = fmt.Sprintf("Suggested change for %s, which is not part of this PR's diff:\n\n```\n%s\n```", target, suggestion.Content)
+		} else {
+			log.Printf("No commentable line near %s in %s, falling back to a file-level comment", target, suggestion.FileName)
+			comment.SubjectType = "file"
+			comment.Line = lineNum
+			comment.StartLine = startLine
+			comment.Body = fmt.Sprintf("```\n%s\n```", suggestion.Content)
+		}
+	}
+	return comment, nil
+}
+
+// AttachOriginals records in each suggestion the lines it replaces, as they are in the analyzed
+// diff, so applying it later can tell whether the file has changed since
+func AttachOriginals(suggestions []config.FileSuggestion, prDetails map[string]interface{}) {
+	indexes := BuildPatchIndexes(prDetails)
+	for i, suggestion := range suggestions {
+		index, ok := indexes[suggestion.FileName]
+		if !ok {
+			continue
+		}
+		line, err := strconv.Atoi(suggestion.LineNum)
+		if err != nil {
+			continue
+		}
+		start := line
+		if startLine, err := strconv.Atoi(suggestion.StartLineNum); err == nil && startLine > 0 && startLine < line {
+			start = startLine
+		}
+		if lines, ok := index.Lines(start, line); ok {
+			suggestions[i].Original = strings.Join(lines, "\n")
+		}
+	}
+}
+
+// SuggestionKey identifies a suggestion across re-runs
+func SuggestionKey(suggestion config.FileSuggestion) string {
+	return suggestion.FileName + ":" + suggestion.LineNum
+}
+
+func DescribeLines(startLine, line int) string {
+	if startLine > 0 && startLine < line {
+		return fmt.Sprintf("lines %d-%d", startLine, line)
+	}
+	return fmt.Sprintf("line %d", line)
+}
+
+// FormatFileLevelComments renders comments that cannot be attached to a diff line for the review body
+func FormatFileLevelComments(comments []ReviewComment) string {
+	if len(comments) == 0 {
+		return ""
+	}
+	body := "\n\n### Suggestions outside the diff\n\n"
+	for _, comment := range comments {
+		body += fmt.Sprintf("**%s** (%s)\n\n%s\n\n", comment.Path, DescribeLines(comment.StartLine, comment.Line), comment.Body)
+	}
+	return body
+}
+
+// HasHighSeverity reports whether any suggestion is high severity, which requests changes
+func HasHighSeverity(suggestions []config.FileSuggestion) bool {
+	for _, suggestion := range suggestions {
+		if suggestion.Severity == "high" {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatSkippedFiles renders the "Files not analyzed" section listing files dropped or
+// truncated to fit the diff budget, or an empty string when everything was analyzed
+func FormatSkippedFiles(prDetails map[string]interface{}) string {
+	skipped, _ := prDetails["skipped_files"].([]config.SkippedFile)
+	if len(skipped) == 0 {
+		return ""
+	}
+
+	body := "\n\n### Files not analyzed\n\n"
+	body += "These files were left out or only partially analyzed to stay within the diff budget:\n\n"
+	for _, file := range skipped {
+		body += fmt.Sprintf("- `%s`: %s\n", file.FileName, file.Reason)
+	}
+	return body
+}
+
+// BuildApproveSummary renders the summary comment posted when the model found nothing to change
+func BuildApproveSummary(summary string) string {
+	body := "## TracePR Observability Check\n\n"
+	body += "**No observability gaps found.** The instrumentation in this PR looks complete.\n"
+	if summary != "" {
+		body += "\n" + summary + "\n"
+	}
+	return body
+}
+
+func ShortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// ReviewPlan is what a provider has to post for an observability review, taking into account
+// the suggestion comments earlier runs already left on the diff
+type ReviewPlan struct {
+	LineComments []ReviewComment // new inline comments, excluding ones already posted
+	FileComments []ReviewComment // suggestions that can't be attached to a diff line
+	Stale        []Comment       // previously posted suggestion comments the model no longer makes
+	Summary      string          // review summary including file-level suggestions and skipped files
+}
+
+// PlanReview places every suggestion against the diff and works out which inline comments are
+// new and which earlier ones are stale. posted holds the existing inline review comments, of
+// which only the ones written by self are TracePR's.
+func PlanReview(suggestions []config.FileSuggestion, prDetails map[string]interface{}, summary string, posted []Comment, self string) ReviewPlan {
+	previous := make(map[string]Comment)
+	for _, comment := range OwnComments(posted, self) {
+		if kind, key, ok := ParseMarker(comment.Body); ok && kind == MarkerSuggestion {
+			previous[key] = comment
+		}
+	}
+
+	// Map every suggestion onto the diff before posting anything
+	log.Printf("Placing %d inline suggestions against the diff", len(suggestions))
+	indexes := BuildPatchIndexes(prDetails)
+	var plan ReviewPlan
+	current := make(map[string]bool)
+	for _, suggestion := range suggestions {
+		comment, err := PlaceSuggestion(suggestion, indexes)
+		if err != nil {
+			log.Printf("Could not place suggestion for %s: %v", suggestion.FileName, err)
+			lineNum, _ := strconv.Atoi(suggestion.LineNum)
+			comment = ReviewComment{Key: SuggestionKey(suggestion), Path: suggestion.FileName, Line: lineNum, SubjectType: "file", Body: fmt.Sprintf("```\n%s\n```", suggestion.Content)}
+		}
+		current[comment.Key] = true
+		if comment.SubjectType == "file" {
+			plan.FileComments = append(plan.FileComments, comment)
+		} else if _, ok := previous[comment.Key]; ok {
+			log.Printf("Suggestion for %s was already posted, skipping", comment.Key)
+		} else {
+			plan.LineComments = append(plan.LineComments, comment)
+		}
+	}
+
+	for key, comment := range previous {
+		if !current[key] {
+			plan.Stale = append(plan.Stale, comment)
+		}
+	}
+
+	if summary == "" {
+		summary = fmt.Sprintf("TracePR found %d observability suggestions for this PR.", len(suggestions))
+	}
+	plan.Summary = summary + FormatFileLevelComments(plan.FileComments) + FormatSkippedFiles(prDetails)
+	return plan
+}
+
+// PostInlineComments posts a review plan for hosts that create inline comments one at a time:
+// stale suggestions are marked as outdated through edit and every new line comment is posted
+// through create. A comment the host rejects only moves that suggestion into the summary,
+// which is returned for the provider to post.
+func PostInlineComments(plan ReviewPlan, create func(comment ReviewComment) error, edit func(id int64, body string) error) string {
+	for _, comment := range plan.Stale {
+		_, key, _ := ParseMarker(comment.Body)
+		log.Printf("Marking stale suggestion %s as outdated", key)
+		if err := edit(comment.ID, OutdatedBody(comment.Body, key)); err != nil {
+			log.Printf("Error marking suggestion %s as outdated: %v", key, err)
+		}
+	}
+
+	var failed []ReviewComment
+	log.Printf("Posting %d inline suggestions", len(plan.LineComments))
+	for _, comment := range plan.LineComments {
+		if err := create(comment); err != nil {
+			log.Printf("Could not post inline suggestion %s, adding it to the summary: %v", comment.Key, err)
+			failed = append(failed, comment)
+		}
+	}
+
+	return plan.Summary + FormatFileLevelComments(failed)
+}
+
+// PlainSuggestion turns a suggestion block into a labelled code block for hosts that can't
+// apply suggested changes
+func PlainSuggestion(body string) string {
+	if !strings.HasPrefix(body, "```suggestion\n") {
+		return body
+	}
+	return "Suggested change:\n\n```" + strings.TrimPrefix(body, "```suggestion")
+}